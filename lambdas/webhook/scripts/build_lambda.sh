@@ -10,4 +10,9 @@ GOOS=linux GOARCH=arm64 go build -tags lambda.norpc -o ./../build/bootstrap
 # zip the go executable in a zip file (Terraform will reference this)
 zip -j ./../build/lambda.zip ./../build/bootstrap
 
+# bundle the grants file for the file access store, if there is one
+if [ -f ./../config/grants.yaml ]; then
+    zip -j ./../build/lambda.zip ./../config/grants.yaml
+fi
+
 cd - 
\ No newline at end of file
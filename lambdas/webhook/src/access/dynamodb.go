@@ -0,0 +1,436 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.uber.org/zap"
+)
+
+const (
+	LOGIN_ATTRIBUTE    = "login"
+	REPO_ENV_ATTRIBUTE = "repo-env"
+	EFFECT_ATTRIBUTE   = "effect"
+
+	// epoch seconds, not_after doubles as the table's TTL attribute
+	NOT_BEFORE_ATTRIBUTE = "not_before"
+	NOT_AFTER_ATTRIBUTE  = "not_after"
+
+	// attributes of policy items
+	WINDOW_DAYS_ATTRIBUTE        = "window_days"
+	WINDOW_START_ATTRIBUTE       = "window_start"
+	WINDOW_END_ATTRIBUTE         = "window_end"
+	WINDOW_TIMEZONE_ATTRIBUTE    = "window_timezone"
+	FREEZES_ATTRIBUTE            = "freezes"
+	FREEZE_NAME_ATTRIBUTE        = "name"
+	FREEZE_START_ATTRIBUTE       = "start"
+	FREEZE_END_ATTRIBUTE         = "end"
+	ON_BLOCKED_ATTRIBUTE         = "on_blocked"
+	ON_NO_ACCESS_ATTRIBUTE       = "on_no_access"
+	APPROVED_COMMENT_ATTRIBUTE   = "approved_comment"
+	REJECTED_COMMENT_ATTRIBUTE   = "rejected_comment"
+	REQUIRED_APPROVALS_ATTRIBUTE = "required_approvals"
+
+	// BatchGetItem accepts at most 100 keys per request
+	BATCH_GET_ITEM_MAX_KEYS = 100
+	// attempts made at fetching unprocessed keys before giving up
+	UNPROCESSED_KEYS_MAX_ATTEMPTS = 5
+	UNPROCESSED_KEYS_BASE_DELAY   = 50 * time.Millisecond
+)
+
+/*
+DynamoDBStore looks grants up in a table keyed by
+login (partition key) and repo-env (sort key)
+*/
+type DynamoDBStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewDynamoDBStore(client *dynamodb.Client, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, tableName: tableName}
+}
+
+/*
+fetches every key of every login with BatchGetItem, keys are
+deduplicated and only split across requests past BATCH_GET_ITEM_MAX_KEYS
+*/
+func (s *DynamoDBStore) GetGrants(ctx context.Context, logins []string, keys []string) ([]Grant, error) {
+	funcLogger := logInstance.With(zap.Strings("logins", logins), zap.Int("key_count", len(keys)))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBStore.GetGrants")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	items, err := s.getItems(ctx, logins, keys)
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to batch get dynamodb items", zap.Error(err))
+		return nil, err
+	}
+
+	var grants []Grant
+	for _, item := range items {
+		grants = append(grants, grantFromItem(item))
+	}
+
+	return grants, nil
+}
+
+/*
+fetches the policy of every key with BatchGetItem, policies
+are the items of the POLICY_SUBJECT login
+*/
+func (s *DynamoDBStore) GetPolicies(ctx context.Context, keys []string) ([]Policy, error) {
+	funcLogger := logInstance.With(zap.Int("key_count", len(keys)))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBStore.GetPolicies")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	items, err := s.getItems(ctx, []string{POLICY_SUBJECT}, keys)
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to batch get dynamodb items", zap.Error(err))
+		return nil, err
+	}
+
+	var policies []Policy
+	for _, item := range items {
+		policy, err := policyFromItem(item)
+		if err != nil {
+			// a policy that can't be read could be a freeze, so fail rather than skip it
+			funcLogger.Errorln("error observed while trying to read policy", zap.String("policy", stringAttribute(item, REPO_ENV_ATTRIBUTE)), zap.Error(err))
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+/*
+gets the item of every key of every login, keys are deduplicated
+and only split across requests past BATCH_GET_ITEM_MAX_KEYS
+*/
+func (s *DynamoDBStore) getItems(ctx context.Context, logins []string, keys []string) ([]map[string]types.AttributeValue, error) {
+	var tableKeys []map[string]types.AttributeValue
+	seen := map[[2]string]struct{}{}
+	for _, login := range logins {
+		for _, key := range keys {
+			if _, exists := seen[[2]string{login, key}]; exists {
+				continue // BatchGetItem rejects duplicate keys
+			}
+			seen[[2]string{login, key}] = struct{}{}
+			tableKeys = append(tableKeys, map[string]types.AttributeValue{
+				LOGIN_ATTRIBUTE:    &types.AttributeValueMemberS{Value: login},
+				REPO_ENV_ATTRIBUTE: &types.AttributeValueMemberS{Value: key},
+			})
+		}
+	}
+
+	var items []map[string]types.AttributeValue
+	for start := 0; start < len(tableKeys); start += BATCH_GET_ITEM_MAX_KEYS {
+		chunk := tableKeys[start:min(start+BATCH_GET_ITEM_MAX_KEYS, len(tableKeys))]
+
+		chunkItems, err := s.batchGetItems(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, chunkItems...)
+	}
+
+	return items, nil
+}
+
+/*
+gets the items for keys, retrying unprocessed keys
+with an exponential backoff until all keys are processed
+*/
+func (s *DynamoDBStore) batchGetItems(ctx context.Context, keys []map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	funcLogger := logInstance.With(zap.String("table_name", s.tableName))
+
+	requestItems := map[string]types.KeysAndAttributes{
+		s.tableName: {Keys: keys},
+	}
+
+	var items []map[string]types.AttributeValue
+	for attempt := 0; len(requestItems) > 0; attempt++ {
+		if attempt == UNPROCESSED_KEYS_MAX_ATTEMPTS {
+			return nil, fmt.Errorf("unprocessed keys remained after %d attempts", UNPROCESSED_KEYS_MAX_ATTEMPTS)
+		}
+
+		if attempt > 0 {
+			delay := UNPROCESSED_KEYS_BASE_DELAY * time.Duration(1<<(attempt-1))
+			funcLogger.Warnln("retrying unprocessed keys", zap.Int("attempt", attempt), zap.Duration("delay", delay))
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		output, err := s.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: requestItems})
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, output.Responses[s.tableName]...)
+		requestItems = output.UnprocessedKeys
+	}
+
+	return items, nil
+}
+
+/*
+queries every item of each login that might be a pattern grant
+*/
+func (s *DynamoDBStore) GetPatternGrants(ctx context.Context, logins []string) ([]Grant, error) {
+	funcLogger := logInstance.With(zap.Strings("logins", logins))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBStore.GetPatternGrants")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	var grants []Grant
+	for _, login := range logins {
+		loginGrants, err := s.queryPatternGrants(ctx, login)
+		if err != nil {
+			funcLogger.Errorln("error observed while trying to query pattern grants", zap.String("login", login), zap.Error(err))
+			return nil, err
+		}
+		grants = append(grants, loginGrants...)
+	}
+
+	return grants, nil
+}
+
+/*
+queries every item of login that might be a pattern grant, the filter
+narrows the items read to keys with pattern characters and IsPattern
+drops the whole segment wildcards (<repo>#*, *#<env>, *#*). Invalid allow
+patterns are skipped while an invalid deny pattern fails the lookup
+*/
+func (s *DynamoDBStore) queryPatternGrants(ctx context.Context, login string) ([]Grant, error) {
+	funcLogger := logInstance.With(zap.String("login", login))
+
+	keyCondition := "#login = :login"
+	filter := "contains(#repoEnv, :star) OR contains(#repoEnv, :question) OR contains(#repoEnv, :bracket)"
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              &s.tableName,
+		KeyConditionExpression: &keyCondition,
+		FilterExpression:       &filter,
+		ExpressionAttributeNames: map[string]string{
+			"#login":   LOGIN_ATTRIBUTE,
+			"#repoEnv": REPO_ENV_ATTRIBUTE,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":login":    &types.AttributeValueMemberS{Value: login},
+			":star":     &types.AttributeValueMemberS{Value: "*"},
+			":question": &types.AttributeValueMemberS{Value: "?"},
+			":bracket":  &types.AttributeValueMemberS{Value: "["},
+		},
+	})
+
+	var grants []Grant
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			grant := grantFromItem(item)
+			if !IsPattern(grant.Key) {
+				continue
+			}
+			if err := ValidatePattern(grant.Key); err != nil {
+				// a deny that can never match would fail open, so the lookup fails instead
+				if grant.Denies() {
+					funcLogger.Errorln("invalid deny pattern grant", zap.String("grant", grant.Key), zap.Error(err))
+					return nil, fmt.Errorf("invalid deny pattern grant %q of %s: %w", grant.Key, login, err)
+				}
+				funcLogger.Warnln("skipping invalid allow pattern grant", zap.String("grant", grant.Key), zap.Error(err))
+				continue
+			}
+			grants = append(grants, grant)
+		}
+	}
+
+	return grants, nil
+}
+
+/*
+scans the logins of the table for the team subjects of org, only the login
+attribute is read and each team is returned once no matter how many grants it holds.
+The table is keyed by login so this reads every item, callers cache the result
+*/
+func (s *DynamoDBStore) GetTeamSubjects(ctx context.Context, org string) ([]string, error) {
+	funcLogger := logInstance.With(zap.String("org", org))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBStore.GetTeamSubjects")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	projection := "#login"
+	filter := "begins_with(#login, :prefix)"
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName:            &s.tableName,
+		ProjectionExpression: &projection,
+		FilterExpression:     &filter,
+		ExpressionAttributeNames: map[string]string{
+			"#login": LOGIN_ATTRIBUTE,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: TeamSubject(org, "")},
+		},
+	})
+
+	var subjects []string
+	seen := map[string]struct{}{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			funcLogger.Errorln("error observed while trying to scan team subjects", zap.Error(err))
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			subject := stringAttribute(item, LOGIN_ATTRIBUTE)
+			if _, exists := seen[subject]; exists || subject == "" {
+				continue
+			}
+			seen[subject] = struct{}{}
+			subjects = append(subjects, subject)
+		}
+	}
+
+	return subjects, nil
+}
+
+func grantFromItem(item map[string]types.AttributeValue) Grant {
+	grant := Grant{
+		Login: stringAttribute(item, LOGIN_ATTRIBUTE),
+		Key:   stringAttribute(item, REPO_ENV_ATTRIBUTE),
+	}
+
+	effect, valid := normalizeEffect(stringAttribute(item, EFFECT_ATTRIBUTE))
+	if !valid {
+		logInstance.Warnln("grant has an unknown effect, treating it as deny",
+			zap.String("login", grant.Login), zap.String("grant", grant.Key), zap.String("effect", stringAttribute(item, EFFECT_ATTRIBUTE)))
+	}
+	grant.Effect = effect
+
+	var notBeforeErr, notAfterErr error
+	grant.NotBefore, notBeforeErr = numberAttribute(item, NOT_BEFORE_ATTRIBUTE)
+	grant.NotAfter, notAfterErr = numberAttribute(item, NOT_AFTER_ATTRIBUTE)
+	if notBeforeErr != nil || notAfterErr != nil {
+		logInstance.Warnln("grant has an invalid validity window, treating it as deny",
+			zap.String("login", grant.Login), zap.String("grant", grant.Key), zap.Error(errors.Join(notBeforeErr, notAfterErr)))
+		grant.Effect = EFFECT_DENY
+	}
+
+	return grant
+}
+
+/*
+reads a policy item, the window attributes are
+window_days, window_start, window_end and window_timezone
+and freezes is a list of name, start and end maps
+*/
+func policyFromItem(item map[string]types.AttributeValue) (Policy, error) {
+	policy := Policy{
+		Key:             stringAttribute(item, REPO_ENV_ATTRIBUTE),
+		ApprovedComment: stringAttribute(item, APPROVED_COMMENT_ATTRIBUTE),
+		RejectedComment: stringAttribute(item, REJECTED_COMMENT_ATTRIBUTE),
+	}
+
+	var err error
+	if policy.OnBlocked, err = normalizeAction(ON_BLOCKED_ATTRIBUTE, stringAttribute(item, ON_BLOCKED_ATTRIBUTE)); err != nil {
+		return Policy{}, err
+	}
+	if policy.OnNoAccess, err = normalizeAction(ON_NO_ACCESS_ATTRIBUTE, stringAttribute(item, ON_NO_ACCESS_ATTRIBUTE)); err != nil {
+		return Policy{}, err
+	}
+
+	if _, ok := item[REQUIRED_APPROVALS_ATTRIBUTE]; ok {
+		requiredApprovals, err := numberAttribute(item, REQUIRED_APPROVALS_ATTRIBUTE)
+		if err != nil {
+			return Policy{}, fmt.Errorf("invalid %s: %w", REQUIRED_APPROVALS_ATTRIBUTE, err)
+		}
+		if requiredApprovals < 0 {
+			return Policy{}, fmt.Errorf("%s %d is negative", REQUIRED_APPROVALS_ATTRIBUTE, requiredApprovals)
+		}
+		required := int(requiredApprovals)
+		policy.RequiredApprovals = &required
+	}
+
+	if days := stringAttribute(item, WINDOW_DAYS_ATTRIBUTE); days != "" {
+		policy.Window, err = ParseWindow(days, stringAttribute(item, WINDOW_START_ATTRIBUTE), stringAttribute(item, WINDOW_END_ATTRIBUTE), stringAttribute(item, WINDOW_TIMEZONE_ATTRIBUTE))
+		if err != nil {
+			return Policy{}, err
+		}
+	}
+
+	if freezes, ok := item[FREEZES_ATTRIBUTE].(*types.AttributeValueMemberL); ok {
+		for i, value := range freezes.Value {
+			freezeItem, ok := value.(*types.AttributeValueMemberM)
+			if !ok {
+				return Policy{}, fmt.Errorf("freeze %d is not a map", i)
+			}
+
+			freeze := Freeze{Name: stringAttribute(freezeItem.Value, FREEZE_NAME_ATTRIBUTE)}
+			var startErr, endErr error
+			freeze.Start, startErr = numberAttribute(freezeItem.Value, FREEZE_START_ATTRIBUTE)
+			freeze.End, endErr = numberAttribute(freezeItem.Value, FREEZE_END_ATTRIBUTE)
+			if err := errors.Join(startErr, endErr); err != nil {
+				return Policy{}, fmt.Errorf("freeze %d has an invalid start or end: %w", i, err)
+			}
+			if freeze.End <= freeze.Start {
+				return Policy{}, fmt.Errorf("freeze %d end %d is not after start %d", i, freeze.End, freeze.Start)
+			}
+			policy.Freezes = append(policy.Freezes, freeze)
+		}
+	}
+
+	return policy, nil
+}
+
+/*
+returns the integer value of the number attribute, or
+zero if the attribute is missing or not a number
+*/
+func numberAttribute(item map[string]types.AttributeValue, name string) (int64, error) {
+	if value, ok := item[name].(*types.AttributeValueMemberN); ok {
+		return strconv.ParseInt(value.Value, 10, 64)
+	}
+	return 0, nil
+}
+
+/*
+returns the string value of the attribute, or an empty
+string if the attribute is missing or not a string
+*/
+func stringAttribute(item map[string]types.AttributeValue, name string) string {
+	if value, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return value.Value
+	}
+	return ""
+}
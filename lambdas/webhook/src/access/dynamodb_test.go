@@ -0,0 +1,343 @@
+package access
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/awsdocs/aws-doc-sdk-examples/gov2/testtools"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	login_name = "github-requester"
+	grant_key  = "test-repo#test-env"
+)
+
+/*
+Test for case where one of the keys exists in the table,
+duplicate keys should only be requested once
+*/
+func TestDynamoDBStoreGetGrants(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store := NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), TABLE_NAME_DEFAULT)
+	stubBatchGetItem(stubber, login_name, []string{grant_key, "*#*"}, []string{grant_key}, nil)
+
+	// act
+	grants, err := store.GetGrants(context.TODO(), []string{login_name}, []string{grant_key, "*#*", grant_key})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, []Grant{{Login: login_name, Key: grant_key, Effect: EFFECT_ALLOW}}, grants)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where none of the keys exist in the table
+*/
+func TestDynamoDBStoreMissingGrants(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store := NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), TABLE_NAME_DEFAULT)
+	stubBatchGetItem(stubber, login_name, []string{grant_key}, nil, nil)
+
+	// act
+	grants, err := store.GetGrants(context.TODO(), []string{login_name}, []string{grant_key})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Empty(t, grants)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where DynamoDB does not process all keys
+in the first request, unprocessed keys should be retried
+*/
+func TestDynamoDBStoreUnprocessedKeys(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store := NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), TABLE_NAME_DEFAULT)
+	stubBatchGetItem(stubber, login_name, []string{grant_key, "*#*"}, []string{grant_key}, []string{"*#*"})
+	stubBatchGetItem(stubber, login_name, []string{"*#*"}, []string{"*#*"}, nil)
+
+	// act
+	grants, err := store.GetGrants(context.TODO(), []string{login_name}, []string{grant_key, "*#*"})
+
+	// assert
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []Grant{{Login: login_name, Key: grant_key, Effect: EFFECT_ALLOW}, {Login: login_name, Key: "*#*", Effect: EFFECT_ALLOW}}, grants)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where an item has the deny effect,
+unknown effects should also be read as deny
+*/
+func TestDynamoDBStoreDenyEffect(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store := NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), TABLE_NAME_DEFAULT)
+
+	denyItem := tableKey(login_name, grant_key)
+	denyItem[EFFECT_ATTRIBUTE] = &types.AttributeValueMemberS{Value: "Deny"}
+	unknownItem := tableKey(login_name, "*#*")
+	unknownItem[EFFECT_ATTRIBUTE] = &types.AttributeValueMemberS{Value: "maybe"}
+
+	tableName := TABLE_NAME_DEFAULT
+	stubber.Add(testtools.Stub{
+		OperationName: "BatchGetItem",
+		Input: &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]types.KeysAndAttributes{tableName: {Keys: []map[string]types.AttributeValue{tableKey(login_name, grant_key), tableKey(login_name, "*#*")}}},
+		},
+		Output: &dynamodb.BatchGetItemOutput{
+			Responses: map[string][]map[string]types.AttributeValue{tableName: {denyItem, unknownItem}},
+		},
+		SkipErrorTest: true,
+	})
+
+	// act
+	grants, err := store.GetGrants(context.TODO(), []string{login_name}, []string{grant_key, "*#*"})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Len(t, grants, 2)
+	for _, grant := range grants {
+		assert.True(t, grant.Denies(), "grant %s should deny", grant.Key)
+	}
+}
+
+/*
+Test for case where items have validity windows, the bounds
+should be read and an invalid bound should be read as deny
+*/
+func TestDynamoDBStoreValidityWindow(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store := NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), TABLE_NAME_DEFAULT)
+
+	boundedItem := tableKey(login_name, grant_key)
+	boundedItem[NOT_BEFORE_ATTRIBUTE] = &types.AttributeValueMemberN{Value: "1700000000"}
+	boundedItem[NOT_AFTER_ATTRIBUTE] = &types.AttributeValueMemberN{Value: "1800000000"}
+	invalidItem := tableKey(login_name, "*#*")
+	invalidItem[NOT_AFTER_ATTRIBUTE] = &types.AttributeValueMemberN{Value: "soon"}
+
+	tableName := TABLE_NAME_DEFAULT
+	stubber.Add(testtools.Stub{
+		OperationName: "BatchGetItem",
+		Input: &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]types.KeysAndAttributes{tableName: {Keys: []map[string]types.AttributeValue{tableKey(login_name, grant_key), tableKey(login_name, "*#*")}}},
+		},
+		Output: &dynamodb.BatchGetItemOutput{
+			Responses: map[string][]map[string]types.AttributeValue{tableName: {boundedItem, invalidItem}},
+		},
+		SkipErrorTest: true,
+	})
+
+	// act
+	grants, err := store.GetGrants(context.TODO(), []string{login_name}, []string{grant_key, "*#*"})
+
+	// assert
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []Grant{
+		{Login: login_name, Key: grant_key, Effect: EFFECT_ALLOW, NotBefore: 1700000000, NotAfter: 1800000000},
+		{Login: login_name, Key: "*#*", Effect: EFFECT_DENY},
+	}, grants)
+}
+
+/*
+Test for case where a policy item has a window and a freeze
+*/
+func TestDynamoDBStoreGetPolicies(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store := NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), TABLE_NAME_DEFAULT)
+
+	policyItem := tableKey(POLICY_SUBJECT, grant_key)
+	policyItem[WINDOW_DAYS_ATTRIBUTE] = &types.AttributeValueMemberS{Value: "mon-fri"}
+	policyItem[WINDOW_START_ATTRIBUTE] = &types.AttributeValueMemberS{Value: "09:00"}
+	policyItem[WINDOW_END_ATTRIBUTE] = &types.AttributeValueMemberS{Value: "16:00"}
+	policyItem[ON_BLOCKED_ATTRIBUTE] = &types.AttributeValueMemberS{Value: "Reject"}
+	policyItem[REQUIRED_APPROVALS_ATTRIBUTE] = &types.AttributeValueMemberN{Value: "2"}
+	policyItem[FREEZES_ATTRIBUTE] = &types.AttributeValueMemberL{Value: []types.AttributeValue{
+		&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			FREEZE_NAME_ATTRIBUTE:  &types.AttributeValueMemberS{Value: "year end"},
+			FREEZE_START_ATTRIBUTE: &types.AttributeValueMemberN{Value: "1700000000"},
+			FREEZE_END_ATTRIBUTE:   &types.AttributeValueMemberN{Value: "1800000000"},
+		}},
+	}}
+
+	tableName := TABLE_NAME_DEFAULT
+	stubber.Add(testtools.Stub{
+		OperationName: "BatchGetItem",
+		Input: &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]types.KeysAndAttributes{tableName: {Keys: []map[string]types.AttributeValue{tableKey(POLICY_SUBJECT, grant_key)}}},
+		},
+		Output: &dynamodb.BatchGetItemOutput{
+			Responses: map[string][]map[string]types.AttributeValue{tableName: {policyItem}},
+		},
+		SkipErrorTest: true,
+	})
+
+	// act
+	policies, err := store.GetPolicies(context.TODO(), []string{grant_key})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Len(t, policies, 1)
+	assert.Equal(t, grant_key, policies[0].Key)
+	assert.Equal(t, ACTION_REJECT, policies[0].OnBlocked)
+	if assert.NotNil(t, policies[0].RequiredApprovals) {
+		assert.Equal(t, 2, *policies[0].RequiredApprovals)
+	}
+	assert.Equal(t, "mon,tue,wed,thu,fri 09:00-16:00 UTC", policies[0].Window.String())
+	assert.Equal(t, []Freeze{{Name: "year end", Start: 1700000000, End: 1800000000}}, policies[0].Freezes)
+}
+
+/*
+Test for case where the login has pattern and whole segment
+wildcard grants, only the pattern grants should be returned
+*/
+func TestDynamoDBStoreGetPatternGrants(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store := NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), TABLE_NAME_DEFAULT)
+
+	stubber.Add(testtools.Stub{
+		OperationName: "Query",
+		Input:         &dynamodb.QueryInput{},
+		IgnoreFields:  []string{"TableName", "KeyConditionExpression", "FilterExpression", "ExpressionAttributeNames", "ExpressionAttributeValues"},
+		Output: &dynamodb.QueryOutput{
+			Items: []map[string]types.AttributeValue{
+				tableKey(login_name, "service-*#prod-*"),
+				tableKey(login_name, "*#*"),
+				tableKey(login_name, "service-[#prod"),
+			},
+		},
+		SkipErrorTest: true,
+	})
+
+	// act
+	grants, err := store.GetPatternGrants(context.TODO(), []string{login_name})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, []Grant{{Login: login_name, Key: "service-*#prod-*", Effect: EFFECT_ALLOW}}, grants)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where a deny pattern grant is malformed, the lookup should
+fail rather than drop the deny, malformed allow patterns are still skipped
+*/
+func TestDynamoDBStoreInvalidDenyPattern(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store := NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), TABLE_NAME_DEFAULT)
+
+	denyItem := tableKey(login_name, "octo-org/service-[#prod")
+	denyItem[EFFECT_ATTRIBUTE] = &types.AttributeValueMemberS{Value: EFFECT_DENY}
+	stubber.Add(testtools.Stub{
+		OperationName: "Query",
+		Input:         &dynamodb.QueryInput{},
+		IgnoreFields:  []string{"TableName", "KeyConditionExpression", "FilterExpression", "ExpressionAttributeNames", "ExpressionAttributeValues"},
+		Output: &dynamodb.QueryOutput{
+			Items: []map[string]types.AttributeValue{
+				tableKey(login_name, "octo-org/service-*#prod-*"),
+				denyItem,
+			},
+		},
+		SkipErrorTest: true,
+	})
+
+	// act
+	grants, err := store.GetPatternGrants(context.TODO(), []string{login_name})
+
+	// assert
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "octo-org/service-[#prod")
+	assert.Nil(t, grants)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where a team holds several grants, the
+team should only be returned once
+*/
+func TestDynamoDBStoreGetTeamSubjects(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store := NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), TABLE_NAME_DEFAULT)
+
+	stubber.Add(testtools.Stub{
+		OperationName: "Scan",
+		Input:         &dynamodb.ScanInput{},
+		IgnoreFields:  []string{"TableName", "ProjectionExpression", "FilterExpression", "ExpressionAttributeNames", "ExpressionAttributeValues"},
+		Output: &dynamodb.ScanOutput{
+			Items: []map[string]types.AttributeValue{
+				{LOGIN_ATTRIBUTE: &types.AttributeValueMemberS{Value: "team:octo-org/deployers"}},
+				{LOGIN_ATTRIBUTE: &types.AttributeValueMemberS{Value: "team:octo-org/deployers"}},
+				{LOGIN_ATTRIBUTE: &types.AttributeValueMemberS{Value: "team:octo-org/reviewers"}},
+			},
+		},
+		SkipErrorTest: true,
+	})
+
+	// act
+	subjects, err := store.GetTeamSubjects(context.TODO(), "octo-org")
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"team:octo-org/deployers", "team:octo-org/reviewers"}, subjects)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+func tableKey(login string, grant string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		LOGIN_ATTRIBUTE:    &types.AttributeValueMemberS{Value: login},
+		REPO_ENV_ATTRIBUTE: &types.AttributeValueMemberS{Value: grant},
+	}
+}
+
+/*
+stubs a BatchGetItem call requesting keys, returning an item for
+each of existing and leaving the unprocessed keys for a later call
+*/
+func stubBatchGetItem(stubber *testtools.AwsmStubber, login string, keys []string, existing []string, unprocessed []string) {
+
+	tableName := TABLE_NAME_DEFAULT
+
+	var requested []map[string]types.AttributeValue
+	for _, key := range keys {
+		requested = append(requested, tableKey(login, key))
+	}
+	input := &dynamodb.BatchGetItemInput{
+		RequestItems: map[string]types.KeysAndAttributes{tableName: {Keys: requested}},
+	}
+
+	var items []map[string]types.AttributeValue
+	for _, key := range existing {
+		items = append(items, tableKey(login, key))
+	}
+	output := &dynamodb.BatchGetItemOutput{
+		Responses: map[string][]map[string]types.AttributeValue{tableName: items},
+	}
+
+	if len(unprocessed) > 0 {
+		var unprocessedKeys []map[string]types.AttributeValue
+		for _, key := range unprocessed {
+			unprocessedKeys = append(unprocessedKeys, tableKey(login, key))
+		}
+		output.UnprocessedKeys = map[string]types.KeysAndAttributes{tableName: {Keys: unprocessedKeys}}
+	}
+
+	stubber.Add(testtools.Stub{
+		OperationName: "BatchGetItem",
+		Input:         input,
+		Output:        output,
+		SkipErrorTest: true,
+		Error:         nil,
+	})
+}
@@ -0,0 +1,190 @@
+package access
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+GrantsFile is the layout of a YAML (or JSON) grants file,
+each grant mirrors an item of the DynamoDB table
+
+	grants:
+	  - login: octocat
+	    repo-env: "*#*"
+	  - login: octocat
+	    repo-env: payments#production
+	    effect: deny
+	  - login: hubot
+	    repo-env: service-*#prod-*
+	  - login: contractor
+	    repo-env: payments#staging
+	    not_before: 1767225600
+	    not_after: 1769904000
+	policies:
+	  - repo-env: octo-org/*#production
+	    window_days: mon-fri
+	    window_start: "09:00"
+	    window_end: "16:00"
+	    window_timezone: America/New_York
+	    freezes:
+	      - name: year end
+	        start: 1766620800
+	        end: 1767312000
+*/
+type GrantsFile struct {
+	Grants   []GrantEntry  `yaml:"grants" json:"grants"`
+	Policies []PolicyEntry `yaml:"policies" json:"policies"`
+}
+
+type GrantEntry struct {
+	Login   string `yaml:"login" json:"login"`
+	RepoEnv string `yaml:"repo-env" json:"repo-env"`
+	Effect  string `yaml:"effect" json:"effect"`
+
+	// epoch seconds, same as the not_before and not_after attributes of table items
+	NotBefore int64 `yaml:"not_before" json:"not_before"`
+	NotAfter  int64 `yaml:"not_after" json:"not_after"`
+}
+
+type PolicyEntry struct {
+	RepoEnv           string        `yaml:"repo-env" json:"repo-env"`
+	WindowDays        string        `yaml:"window_days" json:"window_days"`
+	WindowStart       string        `yaml:"window_start" json:"window_start"`
+	WindowEnd         string        `yaml:"window_end" json:"window_end"`
+	WindowTimezone    string        `yaml:"window_timezone" json:"window_timezone"`
+	Freezes           []FreezeEntry `yaml:"freezes" json:"freezes"`
+	OnBlocked         string        `yaml:"on_blocked" json:"on_blocked"`
+	OnNoAccess        string        `yaml:"on_no_access" json:"on_no_access"`
+	ApprovedComment   string        `yaml:"approved_comment" json:"approved_comment"`
+	RejectedComment   string        `yaml:"rejected_comment" json:"rejected_comment"`
+	RequiredApprovals *int          `yaml:"required_approvals" json:"required_approvals"`
+}
+
+type FreezeEntry struct {
+	Name  string `yaml:"name" json:"name"`
+	Start int64  `yaml:"start" json:"start"`
+	End   int64  `yaml:"end" json:"end"`
+}
+
+/*
+FileStore serves grants read from a YAML or JSON file, either bundled
+with the lambda or read from disk. The file is read once when created.
+*/
+type FileStore struct {
+	*MemoryStore
+	path string
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	funcLogger := logInstance.With(zap.String("path", path))
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		funcLogger.Errorln("error observed while reading grants file", zap.Error(err))
+		return nil, err
+	}
+
+	store, err := parseGrantsFile(contents)
+	if err != nil {
+		funcLogger.Errorln("error observed while parsing grants file", zap.Error(err))
+		return nil, err
+	}
+
+	funcLogger.Infoln("loaded grants file")
+	return &FileStore{MemoryStore: store, path: path}, nil
+}
+
+/*
+parses YAML or JSON (JSON being a subset of YAML) grants into a memory store
+*/
+func parseGrantsFile(contents []byte) (*MemoryStore, error) {
+	var grantsFile GrantsFile
+	if err := yaml.Unmarshal(contents, &grantsFile); err != nil {
+		return nil, err
+	}
+
+	store := NewMemoryStore()
+	for i, grant := range grantsFile.Grants {
+		if grant.Login == "" || grant.RepoEnv == "" {
+			return nil, fmt.Errorf("grant %d is missing a login or repo-env", i)
+		}
+		if !strings.Contains(grant.RepoEnv, GRANT_SEPARATOR) {
+			return nil, fmt.Errorf("grant %d repo-env %q is not of the form <repo>#<env>", i, grant.RepoEnv)
+		}
+		if IsPattern(grant.RepoEnv) {
+			if err := ValidatePattern(grant.RepoEnv); err != nil {
+				return nil, fmt.Errorf("grant %d repo-env %q is not a valid pattern: %w", i, grant.RepoEnv, err)
+			}
+		}
+		effect, valid := normalizeEffect(grant.Effect)
+		if !valid {
+			return nil, fmt.Errorf("grant %d effect %q is not %s or %s", i, grant.Effect, EFFECT_ALLOW, EFFECT_DENY)
+		}
+		if grant.NotBefore != 0 && grant.NotAfter != 0 && grant.NotAfter <= grant.NotBefore {
+			return nil, fmt.Errorf("grant %d not_after %d is not after not_before %d", i, grant.NotAfter, grant.NotBefore)
+		}
+		store.Put(Grant{
+			Login:     strings.ToLower(grant.Login),
+			Key:       strings.ToLower(grant.RepoEnv),
+			Effect:    effect,
+			NotBefore: grant.NotBefore,
+			NotAfter:  grant.NotAfter,
+		})
+	}
+
+	for i, entry := range grantsFile.Policies {
+		policy, err := parsePolicyEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("policy %d: %w", i, err)
+		}
+		store.PutPolicy(policy)
+	}
+
+	return store, nil
+}
+
+/*
+validates and converts a policy of the grants file
+*/
+func parsePolicyEntry(entry PolicyEntry) (Policy, error) {
+	if !strings.Contains(entry.RepoEnv, GRANT_SEPARATOR) {
+		return Policy{}, fmt.Errorf("repo-env %q is not of the form <repo>#<env>", entry.RepoEnv)
+	}
+	if entry.RequiredApprovals != nil && *entry.RequiredApprovals < 0 {
+		return Policy{}, fmt.Errorf("required_approvals %d is negative", *entry.RequiredApprovals)
+	}
+	policy := Policy{
+		Key:               strings.ToLower(entry.RepoEnv),
+		ApprovedComment:   entry.ApprovedComment,
+		RejectedComment:   entry.RejectedComment,
+		RequiredApprovals: entry.RequiredApprovals,
+	}
+
+	var err error
+	if policy.OnBlocked, err = normalizeAction("on_blocked", entry.OnBlocked); err != nil {
+		return Policy{}, err
+	}
+	if policy.OnNoAccess, err = normalizeAction("on_no_access", entry.OnNoAccess); err != nil {
+		return Policy{}, err
+	}
+
+	if entry.WindowDays != "" {
+		if policy.Window, err = ParseWindow(entry.WindowDays, entry.WindowStart, entry.WindowEnd, entry.WindowTimezone); err != nil {
+			return Policy{}, err
+		}
+	}
+
+	for i, freeze := range entry.Freezes {
+		if freeze.End <= freeze.Start {
+			return Policy{}, fmt.Errorf("freeze %d end %d is not after start %d", i, freeze.End, freeze.Start)
+		}
+		policy.Freezes = append(policy.Freezes, Freeze{Name: freeze.Name, Start: freeze.Start, End: freeze.End})
+	}
+
+	return policy, nil
+}
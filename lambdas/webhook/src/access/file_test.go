@@ -0,0 +1,225 @@
+package access
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Test for case where grants are read from a YAML file on disk
+*/
+func TestFileStoreYAML(t *testing.T) {
+	// arrange
+	path := writeGrantsFile(t, "grants.yaml", `
+grants:
+  - login: GitHub-Requester
+    repo-env: test-repo#test-env
+  - login: github-requester
+    repo-env: "*#staging"
+`)
+
+	// act
+	store, err := NewFileStore(path)
+
+	// assert
+	assert.Nil(t, err)
+	assertHasGrant(t, store, login_name, "test-repo#test-env", true)
+	assertHasGrant(t, store, login_name, "*#staging", true)
+	assertHasGrant(t, store, login_name, "test-repo#production", false)
+	assertHasGrant(t, store, "someone-else", "test-repo#test-env", false)
+}
+
+/*
+Test for case where grants are read from a JSON file on disk
+*/
+func TestFileStoreJSON(t *testing.T) {
+	// arrange
+	path := writeGrantsFile(t, "grants.json", `{"grants": [{"login": "github-requester", "repo-env": "test-repo#*"}]}`)
+
+	// act
+	store, err := NewFileStore(path)
+
+	// assert
+	assert.Nil(t, err)
+	assertHasGrant(t, store, login_name, "test-repo#*", true)
+}
+
+/*
+Test for case where the file has a deny grant
+*/
+func TestFileStoreDenyGrant(t *testing.T) {
+	// arrange
+	path := writeGrantsFile(t, "grants.yaml", `
+grants:
+  - login: github-requester
+    repo-env: "*#*"
+  - login: github-requester
+    repo-env: payments#production
+    effect: deny
+`)
+
+	// act
+	store, err := NewFileStore(path)
+	grants, getErr := store.GetGrants(context.TODO(), []string{login_name}, []string{"payments#production", "*#*"})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, getErr)
+	assert.Equal(t, []Grant{
+		{Login: login_name, Key: "payments#production", Effect: EFFECT_DENY},
+		{Login: login_name, Key: "*#*", Effect: EFFECT_ALLOW},
+	}, grants)
+}
+
+/*
+Test for case where a grant in the file has an unknown effect
+*/
+func TestFileStoreInvalidEffect(t *testing.T) {
+	// arrange
+	path := writeGrantsFile(t, "grants.yaml", `
+grants:
+  - login: github-requester
+    repo-env: "*#*"
+    effect: maybe
+`)
+
+	// act
+	_, err := NewFileStore(path)
+
+	// assert
+	assert.NotNil(t, err)
+}
+
+/*
+Test for case where a grant in the file is not a <repo>#<env> key
+*/
+func TestFileStoreInvalidGrant(t *testing.T) {
+	// arrange
+	path := writeGrantsFile(t, "grants.yaml", `
+grants:
+  - login: github-requester
+    repo-env: test-repo
+`)
+
+	// act
+	_, err := NewFileStore(path)
+
+	// assert
+	assert.NotNil(t, err)
+}
+
+/*
+Test for case where a grant in the file has a validity window
+*/
+func TestFileStoreValidityWindow(t *testing.T) {
+	// arrange
+	path := writeGrantsFile(t, "grants.yaml", `
+grants:
+  - login: github-requester
+    repo-env: payments#staging
+    not_before: 1700000000
+    not_after: 1800000000
+`)
+
+	// act
+	store, err := NewFileStore(path)
+	grants, getErr := store.GetGrants(context.TODO(), []string{login_name}, []string{"payments#staging"})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, getErr)
+	assert.Equal(t, []Grant{
+		{Login: login_name, Key: "payments#staging", Effect: EFFECT_ALLOW, NotBefore: 1700000000, NotAfter: 1800000000},
+	}, grants)
+}
+
+/*
+Test for case where a grant in the file ends before it starts
+*/
+func TestFileStoreInvalidValidityWindow(t *testing.T) {
+	// arrange
+	path := writeGrantsFile(t, "grants.yaml", `
+grants:
+  - login: github-requester
+    repo-env: payments#staging
+    not_before: 1800000000
+    not_after: 1700000000
+`)
+
+	// act
+	_, err := NewFileStore(path)
+
+	// assert
+	assert.NotNil(t, err)
+}
+
+/*
+Test for case where the file has a deployment policy
+*/
+func TestFileStorePolicy(t *testing.T) {
+	// arrange
+	path := writeGrantsFile(t, "grants.yaml", `
+policies:
+  - repo-env: octo-org/*#production
+    window_days: mon-fri
+    window_start: "09:00"
+    window_end: "16:00"
+    on_blocked: reject
+    required_approvals: 1
+    freezes:
+      - name: year end
+        start: 1700000000
+        end: 1800000000
+`)
+
+	// act
+	store, err := NewFileStore(path)
+	policies, getErr := store.GetPolicies(context.TODO(), []string{"octo-org/*#production", "octo-org/*#*"})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, getErr)
+	assert.Len(t, policies, 1)
+	assert.Equal(t, ACTION_REJECT, policies[0].OnBlocked)
+	if assert.NotNil(t, policies[0].RequiredApprovals) {
+		assert.Equal(t, 1, *policies[0].RequiredApprovals)
+	}
+	assert.Equal(t, "mon,tue,wed,thu,fri 09:00-16:00 UTC", policies[0].Window.String())
+	assert.Equal(t, []Freeze{{Name: "year end", Start: 1700000000, End: 1800000000}}, policies[0].Freezes)
+}
+
+/*
+Test for case where a policy in the file has an unknown on_blocked
+*/
+func TestFileStoreInvalidPolicy(t *testing.T) {
+	// arrange
+	path := writeGrantsFile(t, "grants.yaml", `
+policies:
+  - repo-env: octo-org/*#production
+    on_blocked: ignore
+`)
+
+	// act
+	_, err := NewFileStore(path)
+
+	// assert
+	assert.NotNil(t, err)
+}
+
+func writeGrantsFile(t *testing.T, name string, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("unable to write grants file: %s", err)
+	}
+	return path
+}
+
+func assertHasGrant(t *testing.T, store AccessStore, login string, grant string, expected bool) {
+	grants, err := store.GetGrants(context.TODO(), []string{login}, []string{grant})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, len(grants) == 1, "unexpected grant result for %s %s", login, grant)
+}
@@ -0,0 +1,122 @@
+package access
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+/*
+MemoryStore keeps grants in memory, it backs the file store
+and can be used on its own in tests
+*/
+type MemoryStore struct {
+	mutex    sync.RWMutex
+	grants   map[string]map[string]Grant
+	policies map[string]Policy
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{grants: map[string]map[string]Grant{}, policies: map[string]Policy{}}
+}
+
+/*
+gives login the grant (a <repo>#<env> key) with an allow effect
+*/
+func (s *MemoryStore) Add(login string, grant string) {
+	s.Put(Grant{Login: login, Key: grant, Effect: EFFECT_ALLOW})
+}
+
+/*
+denies login the grant (a <repo>#<env> key), overriding any allow
+*/
+func (s *MemoryStore) Deny(login string, grant string) {
+	s.Put(Grant{Login: login, Key: grant, Effect: EFFECT_DENY})
+}
+
+/*
+stores the grant, replacing any grant with the same login and key
+*/
+func (s *MemoryStore) Put(grant Grant) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.grants[grant.Login]; !exists {
+		s.grants[grant.Login] = map[string]Grant{}
+	}
+	s.grants[grant.Login][grant.Key] = grant
+}
+
+func (s *MemoryStore) GetGrants(ctx context.Context, logins []string, keys []string) ([]Grant, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var grants []Grant
+	for _, login := range logins {
+		for _, key := range keys {
+			if grant, exists := s.grants[login][key]; exists {
+				grants = append(grants, grant)
+			}
+		}
+	}
+	return grants, nil
+}
+
+func (s *MemoryStore) GetPatternGrants(ctx context.Context, logins []string) ([]Grant, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var grants []Grant
+	for _, login := range logins {
+		var loginGrants []Grant
+		for key, grant := range s.grants[login] {
+			if IsPattern(key) {
+				loginGrants = append(loginGrants, grant)
+			}
+		}
+
+		// map iteration order is random, keep the result stable
+		sort.Slice(loginGrants, func(i, j int) bool { return loginGrants[i].Key < loginGrants[j].Key })
+		grants = append(grants, loginGrants...)
+	}
+	return grants, nil
+}
+
+func (s *MemoryStore) GetTeamSubjects(ctx context.Context, org string) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var subjects []string
+	for login, grants := range s.grants {
+		if _, isTeam := TeamSlug(login, org); isTeam && len(grants) > 0 {
+			subjects = append(subjects, login)
+		}
+	}
+
+	// map iteration order is random, keep the result stable
+	sort.Strings(subjects)
+	return subjects, nil
+}
+
+/*
+stores the policy, replacing any policy with the same key
+*/
+func (s *MemoryStore) PutPolicy(policy Policy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.policies[policy.Key] = policy
+}
+
+func (s *MemoryStore) GetPolicies(ctx context.Context, keys []string) ([]Policy, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var policies []Policy
+	for _, key := range keys {
+		if policy, exists := s.policies[key]; exists {
+			policies = append(policies, policy)
+		}
+	}
+	return policies, nil
+}
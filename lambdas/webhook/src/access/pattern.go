@@ -0,0 +1,95 @@
+package access
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	// characters that make a grant key segment a glob pattern
+	PATTERN_CHARACTERS = "*?["
+)
+
+/*
+returns the <repo> and <env> segments of a grant key
+*/
+func SplitGrantKey(key string) (string, string, bool) {
+	return strings.Cut(key, GRANT_SEPARATOR)
+}
+
+/*
+returns the owner, repo and env segments of a grant key,
+owner is empty for legacy un-scoped keys
+*/
+func splitPatternSegments(key string) ([]string, bool) {
+	repository, environment, found := SplitGrantKey(key)
+	if !found {
+		return nil, false
+	}
+
+	owner, scopedRepository, scoped := strings.Cut(repository, OWNER_SEPARATOR)
+	if !scoped {
+		return []string{"", repository, environment}, true
+	}
+	return []string{owner, scopedRepository, environment}, true
+}
+
+/*
+returns true if the grant key is a pattern grant, such as service-*#prod-*,
+octo-org/service-*#prod-* or *#staging-eu-*. Keys made only of exact names and
+whole segment * wildcards (<repo>#*, <owner>/*#<env>, *#*) are looked up by key instead,
+except for a * owner (ex. the api#prod repo of every owner) as no key is looked up across owners.
+*/
+func IsPattern(key string) bool {
+	segments, found := splitPatternSegments(key)
+	if !found {
+		return false
+	}
+
+	for i, segment := range segments {
+		if i == 0 && segment == WILDCARD {
+			return true
+		}
+		if segment != WILDCARD && strings.ContainsAny(segment, PATTERN_CHARACTERS) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+returns an error if the pattern grant key is not a valid glob
+*/
+func ValidatePattern(key string) error {
+	segments, _ := splitPatternSegments(key)
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+returns true if the pattern grant key matches the owner, repository and environment,
+each segment is matched on its own with glob syntax (*, ? and [...]).
+Legacy un-scoped pattern keys match the repository of any owner.
+*/
+func MatchPattern(key string, owner string, repository string, environment string) bool {
+	segments, found := splitPatternSegments(key)
+	if !found {
+		return false
+	}
+
+	values := []string{owner, repository, environment}
+	for i, segment := range segments {
+		if i == 0 && segment == "" {
+			continue // legacy un-scoped key, any owner
+		}
+		match, err := path.Match(segment, values[i])
+		if err != nil || !match {
+			return false
+		}
+	}
+	return true
+}
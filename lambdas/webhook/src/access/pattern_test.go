@@ -0,0 +1,64 @@
+package access
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPattern(t *testing.T) {
+	cases := map[string]bool{
+		"my-repo#production": false,
+		"my-repo#*":          false,
+		"*#production":       false,
+		"*#*":                false,
+		"service-*#prod-*":   true,
+		"*#staging-eu-*":     true,
+		"api-?#production":   true,
+		"[ab]pi#*":           true,
+		"not-a-grant-key":    false,
+		"octo-org/*#*":       false,
+		"octo-org/api#*":     false,
+		"octo-org/svc-*#*":   true,
+		"octo-*/api#*":       true,
+		"*/api#production":   true,
+		"*/*#*":              true,
+	}
+
+	for key, expected := range cases {
+		assert.Equal(t, expected, IsPattern(key), "unexpected IsPattern result for %s", key)
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		key         string
+		owner       string
+		repository  string
+		environment string
+		expected    bool
+	}{
+		{"service-*#prod-*", "octo-org", "service-api", "prod-us", true},
+		{"service-*#prod-*", "octo-org", "service-api", "staging-us", false},
+		{"service-*#prod-*", "octo-org", "api-service", "prod-us", false},
+		{"*#staging-eu-*", "octo-org", "any-repo", "staging-eu-west", true},
+		{"*#staging-eu-*", "octo-org", "any-repo", "staging-us-west", false},
+		{"api-?#production", "octo-org", "api-1", "production", true},
+		{"api-?#production", "octo-org", "api-10", "production", false},
+		{"[ab]pi#*", "octo-org", "bpi", "anything", true},
+		{"octo-org/service-*#prod-*", "octo-org", "service-api", "prod-us", true},
+		{"octo-org/service-*#prod-*", "other-org", "service-api", "prod-us", false},
+		{"octo-*/api#prod-*", "octo-org", "api", "prod-us", true},
+		{"*/api#prod", "any-org", "api", "prod", true},
+		{"*/api#prod", "any-org", "api", "staging", false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, MatchPattern(c.key, c.owner, c.repository, c.environment), "unexpected match of %s against %s/%s#%s", c.key, c.owner, c.repository, c.environment)
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	assert.Nil(t, ValidatePattern("service-*#prod-*"))
+	assert.NotNil(t, ValidatePattern("service-[#prod"))
+}
@@ -0,0 +1,197 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// policies are stored alongside grants under a login no GitHub user can have
+	POLICY_SUBJECT = "policy:deployment"
+
+	// what happens to a deployment that is blocked or that the requester has no access to
+	ACTION_PENDING = "pending"
+	ACTION_REJECT  = "reject"
+
+	WINDOW_TIME_LAYOUT = "15:04"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+/*
+Policy is a deployment policy for the environments matched by its
+<owner>/<repo>#<env> key (the repo and env segments may be the * wildcard).
+Deployments are only approved within the Window (if any) and never during
+one of the Freezes. OnBlocked is what happens to a deployment that is
+blocked by the policy and OnNoAccess what happens to a deployment the
+requester has no access to, either left pending (the default) or rejected.
+ApprovedComment and RejectedComment are text/template comments that replace
+the configured comments for the environments of the policy. RequiredApprovals
+is the number of grant holders other than the requester that must approve a
+deployment before it is approved, the requester's own grant is not enough.
+It is nil when the policy leaves the number to the broader policies, so an
+explicit 0 lets a more specific policy restore single approval.
+*/
+type Policy struct {
+	Key             string
+	Window          *Window
+	Freezes         []Freeze
+	OnBlocked       string
+	OnNoAccess      string
+	ApprovedComment string
+	RejectedComment string
+	// 0 when the requester's grant is enough to approve, nil when unset
+	RequiredApprovals *int
+}
+
+/*
+Window is a recurring deployment window, such as weekdays 09:00-16:00
+in a timezone. Start and End are offsets from midnight, a window whose
+End is before its Start spans midnight.
+*/
+type Window struct {
+	Days     []time.Weekday
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+/*
+Freeze is a named period (a holiday, an incident) during which
+no deployment is approved, Start and End are epoch seconds
+*/
+type Freeze struct {
+	Name  string
+	Start int64
+	End   int64
+}
+
+/*
+returns true if the time is within the window
+*/
+func (w Window) Contains(t time.Time) bool {
+	local := t.In(w.Location)
+	offset := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+
+	day := local.Weekday()
+	inWindow := offset >= w.Start && offset < w.End
+	if w.End <= w.Start {
+		// the early hours of a window spanning midnight belong to the day before
+		inWindow = offset >= w.Start || offset < w.End
+		if offset < w.End {
+			day = local.AddDate(0, 0, -1).Weekday()
+		}
+	}
+
+	if !inWindow {
+		return false
+	}
+	for _, windowDay := range w.Days {
+		if windowDay == day {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+describes the window, ex. mon,tue,wed 09:00-16:00 America/New_York
+*/
+func (w Window) String() string {
+	var days []string
+	for _, day := range w.Days {
+		days = append(days, strings.ToLower(day.String()[:3]))
+	}
+	midnight := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)
+	return fmt.Sprintf("%s %s-%s %s", strings.Join(days, ","),
+		midnight.Add(w.Start).Format(WINDOW_TIME_LAYOUT), midnight.Add(w.End).Format(WINDOW_TIME_LAYOUT), w.Location)
+}
+
+/*
+returns true if the time is within the freeze
+*/
+func (f Freeze) Contains(t time.Time) bool {
+	return t.Unix() >= f.Start && t.Unix() < f.End
+}
+
+/*
+parses a window from its days (ex. mon-fri or mon,wed,fri), start and end
+(ex. 09:00 and 16:00) and IANA timezone (UTC if empty)
+*/
+func ParseWindow(days string, start string, end string, timezone string) (*Window, error) {
+	window := &Window{Location: time.UTC}
+
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+
+		fromDay, exists := weekdays[from]
+		if !exists {
+			return nil, fmt.Errorf("unknown window day %q", from)
+		}
+		if !isRange {
+			window.Days = append(window.Days, fromDay)
+			continue
+		}
+
+		toDay, exists := weekdays[to]
+		if !exists {
+			return nil, fmt.Errorf("unknown window day %q", to)
+		}
+		for day := fromDay; ; day = (day + 1) % 7 {
+			window.Days = append(window.Days, day)
+			if day == toDay {
+				break
+			}
+		}
+	}
+
+	var err error
+	if window.Start, err = parseWindowTime(start); err != nil {
+		return nil, err
+	}
+	if window.End, err = parseWindowTime(end); err != nil {
+		return nil, err
+	}
+
+	if timezone != "" {
+		if window.Location, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("unknown window timezone %q: %w", timezone, err)
+		}
+	}
+
+	return window, nil
+}
+
+/*
+parses an HH:MM time of day into its offset from midnight
+*/
+func parseWindowTime(value string) (time.Duration, error) {
+	parsed, err := time.Parse(WINDOW_TIME_LAYOUT, strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("window time %q is not of the form HH:MM", value)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+/*
+normalizes an action attribute (on_blocked, on_no_access), a missing
+value is left empty so a less specific policy can still set it
+*/
+func normalizeAction(name string, action string) (string, error) {
+	switch value := strings.ToLower(strings.TrimSpace(action)); value {
+	case "", ACTION_PENDING, ACTION_REJECT:
+		return value, nil
+	default:
+		return "", fmt.Errorf("%s %q is not %s or %s", name, action, ACTION_PENDING, ACTION_REJECT)
+	}
+}
@@ -0,0 +1,65 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWindow(t *testing.T) {
+	window, err := ParseWindow("mon-fri", "09:00", "16:00", "America/New_York")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, window.Days)
+	assert.Equal(t, 9*time.Hour, window.Start)
+	assert.Equal(t, 16*time.Hour, window.End)
+	assert.Equal(t, "mon,tue,wed,thu,fri 09:00-16:00 America/New_York", window.String())
+}
+
+func TestParseWindowInvalid(t *testing.T) {
+	cases := [][4]string{
+		{"weekdays", "09:00", "16:00", ""},
+		{"mon-fri", "9am", "16:00", ""},
+		{"mon-fri", "09:00", "16:00", "Mars/Olympus_Mons"},
+	}
+
+	for _, c := range cases {
+		_, err := ParseWindow(c[0], c[1], c[2], c[3])
+		assert.NotNil(t, err, "expected an error for %v", c)
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	weekdays, _ := ParseWindow("mon-fri", "09:00", "16:00", "UTC")
+	overnight, _ := ParseWindow("fri", "22:00", "02:00", "UTC")
+
+	cases := []struct {
+		window   *Window
+		time     time.Time
+		expected bool
+	}{
+		// 2024-01-01 was a monday
+		{weekdays, time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), true},
+		{weekdays, time.Date(2024, 1, 1, 15, 59, 0, 0, time.UTC), true},
+		{weekdays, time.Date(2024, 1, 1, 16, 0, 0, 0, time.UTC), false},
+		{weekdays, time.Date(2024, 1, 1, 8, 59, 0, 0, time.UTC), false},
+		{weekdays, time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC), false},
+		{overnight, time.Date(2024, 1, 5, 23, 0, 0, 0, time.UTC), true},
+		{overnight, time.Date(2024, 1, 6, 1, 0, 0, 0, time.UTC), true},
+		{overnight, time.Date(2024, 1, 6, 23, 0, 0, 0, time.UTC), false},
+		{overnight, time.Date(2024, 1, 5, 1, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.window.Contains(c.time), "unexpected Contains result for %s at %s", c.window, c.time)
+	}
+}
+
+func TestFreezeContains(t *testing.T) {
+	freeze := Freeze{Name: "year end", Start: 1000, End: 2000}
+
+	assert.False(t, freeze.Contains(time.Unix(999, 0)))
+	assert.True(t, freeze.Contains(time.Unix(1000, 0)))
+	assert.False(t, freeze.Contains(time.Unix(2000, 0)))
+}
@@ -0,0 +1,210 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"webhook/db"
+	"webhook/logger"
+	"webhook/util"
+
+	"go.uber.org/zap"
+)
+
+var (
+	accessStoreInstance util.Lazy[AccessStore]
+
+	logInstance *zap.SugaredLogger
+)
+
+const (
+	ACCESS_STORE_TYPE_ENV_VAR_KEY = "ACCESS_STORE_TYPE"
+	ACCESS_STORE_TYPE_DEFAULT     = DYNAMODB_STORE_TYPE
+
+	ACCESS_STORE_FILE_PATH_ENV_VAR_KEY = "ACCESS_STORE_FILE_PATH"
+	ACCESS_STORE_FILE_PATH_DEFAULT     = "grants.yaml"
+
+	TABLE_NAME_ENV_VAR_KEY = "DYNAMO_DB_TABLE_NAME"
+	TABLE_NAME_DEFAULT     = "deployment-webhooks-table"
+
+	DYNAMODB_STORE_TYPE = "dynamodb"
+	FILE_STORE_TYPE     = "file"
+	MEMORY_STORE_TYPE   = "memory"
+
+	TEAM_SUBJECT_PREFIX = "team:"
+
+	GRANT_VALID         = "valid"
+	GRANT_EXPIRED       = "expired"
+	GRANT_NOT_YET_VALID = "not yet valid"
+
+	GRANT_SEPARATOR = "#"
+	OWNER_SEPARATOR = "/"
+	WILDCARD        = "*"
+
+	EFFECT_ALLOW = "allow"
+	EFFECT_DENY  = "deny"
+)
+
+/*
+AccessStore answers which grants a set of logins hold, where a grant is an
+<owner>/<repo>#<env> key or a legacy un-scoped <repo>#<env> key
+(the repo and env segments may be the * wildcard). A login is either a
+GitHub user login or a team subject (see TeamSubject).
+All candidate logins and keys are passed at once so stores can look them up
+in as few round trips as possible. Pattern grants (see IsPattern)
+cannot be looked up by key, so they are listed for the logins instead.
+Deployment policies (see Policy) are stored alongside the grants and
+looked up by the same keys. GetTeamSubjects lists the team subjects of an
+org holding any grant, so only those teams' memberships need resolving.
+*/
+type AccessStore interface {
+	GetGrants(ctx context.Context, logins []string, keys []string) ([]Grant, error)
+	GetPatternGrants(ctx context.Context, logins []string) ([]Grant, error)
+	GetTeamSubjects(ctx context.Context, org string) ([]string, error)
+	GetPolicies(ctx context.Context, keys []string) ([]Policy, error)
+}
+
+/*
+Grant is a <repo>#<env> key held by a login, the effect of
+a grant is either allow (the default) or deny. A deny grant beats
+any allow grant for the same environment at any level.
+A grant may be bounded in time by NotBefore and NotAfter
+(epoch seconds, zero when unbounded), outside of that window it
+is as if the login did not hold the grant.
+*/
+type Grant struct {
+	Login     string
+	Key       string
+	Effect    string
+	NotBefore int64
+	NotAfter  int64
+}
+
+/*
+returns true if the grant denies access rather than allowing it
+*/
+func (g Grant) Denies() bool {
+	return g.Effect == EFFECT_DENY
+}
+
+/*
+returns the validity of the grant at the time passed,
+GRANT_VALID if the time is within the grant's window
+*/
+func (g Grant) ValidityAt(t time.Time) string {
+	switch {
+	case g.NotBefore != 0 && t.Unix() < g.NotBefore:
+		return GRANT_NOT_YET_VALID
+	case g.NotAfter != 0 && t.Unix() >= g.NotAfter:
+		return GRANT_EXPIRED
+	default:
+		return GRANT_VALID
+	}
+}
+
+/*
+normalizes an effect attribute, a missing effect is an allow.
+Unknown effects are treated as deny so a typo never grants access.
+*/
+func normalizeEffect(effect string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(effect)) {
+	case "", EFFECT_ALLOW:
+		return EFFECT_ALLOW, true
+	case EFFECT_DENY:
+		return EFFECT_DENY, true
+	default:
+		return EFFECT_DENY, false
+	}
+}
+
+func init() {
+	logInstance = logger.GetLogger().Sugar()
+}
+
+/*
+builds a <repo>#<env> grant key, pass a ScopedRepository
+as the repository to build an <owner>/<repo>#<env> key
+*/
+func GrantKey(repository string, environment string) string {
+	return strings.Join([]string{repository, environment}, GRANT_SEPARATOR)
+}
+
+/*
+builds the <owner>/<repo> repository segment of an owner scoped grant key,
+<owner>/* is the org-wide wildcard
+*/
+func ScopedRepository(owner string, repository string) string {
+	return strings.Join([]string{owner, repository}, OWNER_SEPARATOR)
+}
+
+/*
+builds the team:<org>/<slug> login of grants given to a GitHub team
+*/
+func TeamSubject(org string, slug string) string {
+	return TEAM_SUBJECT_PREFIX + strings.Join([]string{org, slug}, OWNER_SEPARATOR)
+}
+
+/*
+returns the slug of a team:<org>/<slug> subject of org, false if
+the subject is not a team subject of org
+*/
+func TeamSlug(subject string, org string) (string, bool) {
+	return strings.CutPrefix(subject, TeamSubject(org, ""))
+}
+
+/*
+returns true if the login of a grant is a team subject
+*/
+func IsTeamSubject(login string) bool {
+	return strings.HasPrefix(login, TEAM_SUBJECT_PREFIX)
+}
+
+/*
+returns true if the grant key is scoped to an owner (<owner>/<repo>#<env>),
+false for legacy un-scoped keys (<repo>#<env>)
+*/
+func IsScoped(key string) bool {
+	repository, _, _ := SplitGrantKey(key)
+	return strings.Contains(repository, OWNER_SEPARATOR)
+}
+
+/*
+Returns the access store configured through ACCESS_STORE_TYPE_ENV_VAR_KEY
+*/
+func GetAccessStore(ctx context.Context) (AccessStore, error) {
+	store, err := accessStoreInstance.Get(func() (AccessStore, error) {
+		return newAccessStoreFromEnv(ctx)
+	})
+	if err != nil {
+		logInstance.Errorln("cannot configure access store", zap.Error(err))
+		return nil, err
+	}
+	return store, nil
+}
+
+func newAccessStoreFromEnv(ctx context.Context) (AccessStore, error) {
+	storeType := strings.ToLower(util.LookupEnv(ACCESS_STORE_TYPE_ENV_VAR_KEY, ACCESS_STORE_TYPE_DEFAULT, false))
+	funcLogger := logInstance.With(zap.String("store_type", storeType))
+
+	switch storeType {
+	case DYNAMODB_STORE_TYPE:
+		client, err := db.GetDynamoClient(ctx)
+		if err != nil {
+			funcLogger.Errorln("error observed while trying to get dynamodb client", zap.Error(err))
+			return nil, err
+		}
+		tableName := util.LookupEnv(TABLE_NAME_ENV_VAR_KEY, TABLE_NAME_DEFAULT, false)
+		return NewDynamoDBStore(client, tableName), nil
+	case FILE_STORE_TYPE:
+		filePath := util.LookupEnv(ACCESS_STORE_FILE_PATH_ENV_VAR_KEY, ACCESS_STORE_FILE_PATH_DEFAULT, false)
+		return NewFileStore(filePath)
+	case MEMORY_STORE_TYPE:
+		funcLogger.Warnln("using an empty in-memory access store, no requester will have access")
+		return NewMemoryStore(), nil
+	default:
+		err := fmt.Errorf("unsupported access store type %q", storeType)
+		funcLogger.Errorln("invalid access store type", zap.Error(err))
+		return nil, err
+	}
+}
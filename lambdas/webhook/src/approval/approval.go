@@ -0,0 +1,122 @@
+package approval
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"webhook/db"
+	"webhook/logger"
+	"webhook/util"
+
+	"go.uber.org/zap"
+)
+
+var (
+	storeInstance util.Lazy[Store]
+
+	logInstance *zap.SugaredLogger
+)
+
+const (
+	APPROVAL_STORE_TYPE_ENV_VAR_KEY = "APPROVAL_STORE_TYPE"
+	APPROVAL_STORE_TYPE_DEFAULT     = DYNAMODB_STORE_TYPE
+
+	APPROVAL_TABLE_NAME_ENV_VAR_KEY = "APPROVAL_TABLE_NAME"
+	APPROVAL_TABLE_NAME_DEFAULT     = "deployment-webhooks-approvals-table"
+
+	// how long a quorum is kept open, GitHub fails
+	// runs that have been waiting on a review for 30 days
+	APPROVAL_TTL_ENV_VAR_KEY = "APPROVAL_TTL"
+	APPROVAL_TTL_DEFAULT     = "720h"
+
+	DYNAMODB_STORE_TYPE = "dynamodb"
+	MEMORY_STORE_TYPE   = "memory"
+)
+
+func init() {
+	logInstance = logger.GetLogger().Sugar()
+}
+
+/*
+Quorum is the approval state of the pending deployment of a run to an
+environment that needs the approval of grant holders other than its requester.
+Key is the <owner>/<repo>#<env> of the environment and Approvers the
+logins that have approved the deployment so far
+*/
+type Quorum struct {
+	Key       string
+	RunID     int64
+	Requester string
+	Approvers []string
+	ExpiresAt int64
+}
+
+/*
+returns true if the login has approved the deployment
+*/
+func (q Quorum) HasApproved(login string) bool {
+	return slices.ContainsFunc(q.Approvers, func(approver string) bool {
+		return strings.EqualFold(approver, login)
+	})
+}
+
+/*
+Store keeps the quorums of pending deployments until enough grant holders
+have approved them. A quorum is opened when the run's deployment is found
+pending, approved by the comments of other grant holders and closed once
+the deployment is approved.
+*/
+type Store interface {
+	// opens the quorum unless it is already open for the same requester, the open quorum is returned
+	Open(ctx context.Context, quorum Quorum) (Quorum, error)
+	// adds the approver to the open quorum and returns it, nil if there is no quorum open for the requester
+	Approve(ctx context.Context, key string, runID int64, requester string, approver string) (*Quorum, error)
+	Close(ctx context.Context, key string, runID int64) error
+	// returns the quorum open for the requester without changing it, nil if there is none (ex. for dry runs)
+	Get(ctx context.Context, key string, runID int64, requester string) (*Quorum, error)
+}
+
+/*
+Returns the store configured through APPROVAL_STORE_TYPE_ENV_VAR_KEY
+*/
+func GetStore(ctx context.Context) (Store, error) {
+	store, err := storeInstance.Get(func() (Store, error) {
+		return newStoreFromEnv(ctx)
+	})
+	if err != nil {
+		logInstance.Errorln("cannot configure approval store", zap.Error(err))
+		return nil, err
+	}
+	return store, nil
+}
+
+func newStoreFromEnv(ctx context.Context) (Store, error) {
+	storeType := strings.ToLower(util.LookupEnv(APPROVAL_STORE_TYPE_ENV_VAR_KEY, APPROVAL_STORE_TYPE_DEFAULT, false))
+	funcLogger := logInstance.With(zap.String("approval_store_type", storeType))
+
+	ttl, err := time.ParseDuration(util.LookupEnv(APPROVAL_TTL_ENV_VAR_KEY, APPROVAL_TTL_DEFAULT, false))
+	if err != nil || ttl <= 0 {
+		err = fmt.Errorf("invalid approval TTL: %w", err)
+		funcLogger.Errorln("invalid approval TTL", zap.Error(err))
+		return nil, err
+	}
+
+	switch storeType {
+	case DYNAMODB_STORE_TYPE:
+		client, err := db.GetDynamoClient(ctx)
+		if err != nil {
+			funcLogger.Errorln("error observed while trying to get dynamodb client", zap.Error(err))
+			return nil, err
+		}
+		return NewDynamoDBStore(client, util.LookupEnv(APPROVAL_TABLE_NAME_ENV_VAR_KEY, APPROVAL_TABLE_NAME_DEFAULT, false), ttl), nil
+	case MEMORY_STORE_TYPE:
+		funcLogger.Warnln("using an in-memory approval store, approvals are lost with the lambda")
+		return NewMemoryStore(ttl), nil
+	default:
+		err := fmt.Errorf("unsupported approval store type %q", storeType)
+		funcLogger.Errorln("invalid approval store type", zap.Error(err))
+		return nil, err
+	}
+}
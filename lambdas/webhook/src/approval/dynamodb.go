@@ -0,0 +1,253 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.uber.org/zap"
+)
+
+const (
+	KEY_ATTRIBUTE       = "key"
+	RUN_ID_ATTRIBUTE    = "run_id"
+	REQUESTER_ATTRIBUTE = "requester"
+	// string set of the logins that have approved
+	APPROVERS_ATTRIBUTE = "approvers"
+	// epoch seconds, doubles as the table's TTL attribute
+	EXPIRES_AT_ATTRIBUTE = "expires_at"
+
+	// the quorum is new, was opened for another requester (ex. the run was re-run)
+	// or is only remembered because TTL deletion lags behind expiry
+	OPEN_CONDITION = "attribute_not_exists(" + KEY_ATTRIBUTE + ") OR " + REQUESTER_ATTRIBUTE + " <> :requester OR " + EXPIRES_AT_ATTRIBUTE + " < :now"
+	// approvals only count towards a quorum that is open for the requester
+	APPROVE_CONDITION = REQUESTER_ATTRIBUTE + " = :requester AND " + EXPIRES_AT_ATTRIBUTE + " >= :now"
+)
+
+/*
+DynamoDBStore keeps quorums in a table keyed by <owner>/<repo>#<env>
+(partition key) and run_id (sort key), items expire through the table's TTL
+*/
+type DynamoDBStore struct {
+	client    *dynamodb.Client
+	tableName string
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+func NewDynamoDBStore(client *dynamodb.Client, tableName string, ttl time.Duration) *DynamoDBStore {
+	return &DynamoDBStore{client: client, tableName: tableName, ttl: ttl, now: time.Now}
+}
+
+/*
+puts the quorum without approvers unless it is already open for the same requester,
+in which case the open quorum is read with a consistent read and returned
+*/
+func (s *DynamoDBStore) Open(ctx context.Context, quorum Quorum) (Quorum, error) {
+	funcLogger := logInstance.With(zap.String("key", quorum.Key), zap.Int64("runID", quorum.RunID), zap.String("table_name", s.tableName))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBApprovalStore.Open")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	now := s.now()
+	quorum.Approvers = nil
+	quorum.ExpiresAt = now.Add(s.ttl).Unix()
+
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item: map[string]types.AttributeValue{
+			KEY_ATTRIBUTE:        &types.AttributeValueMemberS{Value: quorum.Key},
+			RUN_ID_ATTRIBUTE:     &types.AttributeValueMemberS{Value: strconv.FormatInt(quorum.RunID, 10)},
+			REQUESTER_ATTRIBUTE:  &types.AttributeValueMemberS{Value: quorum.Requester},
+			EXPIRES_AT_ATTRIBUTE: &types.AttributeValueMemberN{Value: strconv.FormatInt(quorum.ExpiresAt, 10)},
+		},
+		ConditionExpression: aws.String(OPEN_CONDITION),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":requester": &types.AttributeValueMemberS{Value: quorum.Requester},
+			":now":       &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+
+	if err == nil {
+		funcLogger.Infoln("opened quorum")
+		return quorum, nil
+	}
+	var conditionFailed *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionFailed) {
+		funcLogger.Errorln("error observed while trying to open quorum", zap.Error(err))
+		return Quorum{}, err
+	}
+
+	output, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            itemKey(quorum.Key, quorum.RunID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to read open quorum", zap.Error(err))
+		return Quorum{}, err
+	}
+
+	open, err := quorumFromItem(output.Item)
+	if err != nil {
+		funcLogger.Errorln("invalid quorum item", zap.Error(err))
+		return Quorum{}, err
+	}
+	return open, nil
+}
+
+/*
+adds the approver to the approvers set of the quorum, the
+condition fails if no quorum is open for the requester
+*/
+func (s *DynamoDBStore) Approve(ctx context.Context, key string, runID int64, requester string, approver string) (*Quorum, error) {
+	funcLogger := logInstance.With(zap.String("key", key), zap.Int64("runID", runID), zap.String("approver", approver), zap.String("table_name", s.tableName))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBApprovalStore.Approve")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	output, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 itemKey(key, runID),
+		UpdateExpression:    aws.String("ADD " + APPROVERS_ATTRIBUTE + " :approver"),
+		ConditionExpression: aws.String(APPROVE_CONDITION),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":approver":  &types.AttributeValueMemberSS{Value: []string{approver}},
+			":requester": &types.AttributeValueMemberS{Value: requester},
+			":now":       &types.AttributeValueMemberN{Value: strconv.FormatInt(s.now().Unix(), 10)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		funcLogger.Infoln("no quorum is open for the requester")
+		return nil, nil
+	}
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to approve quorum", zap.Error(err))
+		return nil, err
+	}
+
+	quorum, err := quorumFromItem(output.Attributes)
+	if err != nil {
+		funcLogger.Errorln("invalid quorum item", zap.Error(err))
+		return nil, err
+	}
+	return &quorum, nil
+}
+
+/*
+deletes the quorum so a re-run of the run needs new approvals
+*/
+func (s *DynamoDBStore) Close(ctx context.Context, key string, runID int64) error {
+	funcLogger := logInstance.With(zap.String("key", key), zap.Int64("runID", runID), zap.String("table_name", s.tableName))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBApprovalStore.Close")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       itemKey(key, runID),
+	})
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to close quorum", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+/*
+reads the quorum with a consistent read, a quorum opened for another
+requester or expired (but not yet deleted by TTL) is not open
+*/
+func (s *DynamoDBStore) Get(ctx context.Context, key string, runID int64, requester string) (*Quorum, error) {
+	funcLogger := logInstance.With(zap.String("key", key), zap.Int64("runID", runID), zap.String("table_name", s.tableName))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBApprovalStore.Get")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	output, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            itemKey(key, runID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to read quorum", zap.Error(err))
+		return nil, err
+	}
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+
+	quorum, err := quorumFromItem(output.Item)
+	if err != nil {
+		funcLogger.Errorln("invalid quorum item", zap.Error(err))
+		return nil, err
+	}
+	if quorum.Requester != requester || quorum.ExpiresAt < s.now().Unix() {
+		return nil, nil
+	}
+	return &quorum, nil
+}
+
+func itemKey(key string, runID int64) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		KEY_ATTRIBUTE:    &types.AttributeValueMemberS{Value: key},
+		RUN_ID_ATTRIBUTE: &types.AttributeValueMemberS{Value: strconv.FormatInt(runID, 10)},
+	}
+}
+
+/*
+reads a quorum item, approvers are sorted as string sets have no order
+*/
+func quorumFromItem(item map[string]types.AttributeValue) (Quorum, error) {
+	var quorum Quorum
+
+	key, _ := item[KEY_ATTRIBUTE].(*types.AttributeValueMemberS)
+	runID, _ := item[RUN_ID_ATTRIBUTE].(*types.AttributeValueMemberS)
+	requester, _ := item[REQUESTER_ATTRIBUTE].(*types.AttributeValueMemberS)
+	expiresAt, _ := item[EXPIRES_AT_ATTRIBUTE].(*types.AttributeValueMemberN)
+	if key == nil || runID == nil || requester == nil || expiresAt == nil {
+		return Quorum{}, errors.New("quorum item is missing its key, run_id, requester or expires_at")
+	}
+
+	var runIDErr, expiresAtErr error
+	quorum.Key = key.Value
+	quorum.Requester = requester.Value
+	quorum.RunID, runIDErr = strconv.ParseInt(runID.Value, 10, 64)
+	quorum.ExpiresAt, expiresAtErr = strconv.ParseInt(expiresAt.Value, 10, 64)
+	if err := errors.Join(runIDErr, expiresAtErr); err != nil {
+		return Quorum{}, fmt.Errorf("quorum item has an invalid run_id or expires_at: %w", err)
+	}
+
+	if approvers, ok := item[APPROVERS_ATTRIBUTE].(*types.AttributeValueMemberSS); ok {
+		quorum.Approvers = slices.Sorted(slices.Values(approvers.Value))
+	}
+
+	return quorum, nil
+}
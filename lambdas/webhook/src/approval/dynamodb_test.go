@@ -0,0 +1,205 @@
+package approval
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/awsdocs/aws-doc-sdk-examples/gov2/testtools"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Test for case where no quorum is open for the run's environment,
+the quorum should be put without approvers
+*/
+func TestDynamoDBStoreOpen(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store, now := getStubbedDynamoDBStore(stubber)
+	stubPutQuorum(stubber, now, nil)
+
+	// act
+	quorum, err := store.Open(context.TODO(), Quorum{Key: quorum_key, RunID: run_id, Requester: requester_name})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Empty(t, quorum.Approvers)
+	assert.Equal(t, now.Add(time.Hour).Unix(), quorum.ExpiresAt)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where the quorum is already open for the requester,
+the open quorum should be returned with its approvers
+*/
+func TestDynamoDBStoreAlreadyOpen(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store, now := getStubbedDynamoDBStore(stubber)
+	stubPutQuorum(stubber, now, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")})
+	stubber.Add(testtools.Stub{
+		OperationName: "GetItem",
+		Input: &dynamodb.GetItemInput{
+			TableName:      aws.String(APPROVAL_TABLE_NAME_DEFAULT),
+			Key:            itemKey(quorum_key, run_id),
+			ConsistentRead: aws.Bool(true),
+		},
+		Output:        &dynamodb.GetItemOutput{Item: quorumItem(now, approver_name)},
+		SkipErrorTest: true,
+	})
+
+	// act
+	quorum, err := store.Open(context.TODO(), Quorum{Key: quorum_key, RunID: run_id, Requester: requester_name})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, []string{approver_name}, quorum.Approvers)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where a grant holder approves, the updated
+quorum should be returned with every approver
+*/
+func TestDynamoDBStoreApprove(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store, now := getStubbedDynamoDBStore(stubber)
+	stubApproveQuorum(stubber, now, &dynamodb.UpdateItemOutput{Attributes: quorumItem(now, "other-approver", approver_name)}, nil)
+
+	// act
+	quorum, err := store.Approve(context.TODO(), quorum_key, run_id, requester_name, approver_name)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, quorum)
+	assert.Equal(t, []string{approver_name, "other-approver"}, quorum.Approvers)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where no quorum is open for the requester,
+the approval should be dropped rather than fail
+*/
+func TestDynamoDBStoreApproveNotOpen(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store, now := getStubbedDynamoDBStore(stubber)
+	stubApproveQuorum(stubber, now, nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")})
+
+	// act
+	quorum, err := store.Approve(context.TODO(), quorum_key, run_id, requester_name, approver_name)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, quorum)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where the quorum is read for a dry run, the quorum
+open for the requester should be returned and other requesters'
+quorums should not be
+*/
+func TestDynamoDBStoreGet(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	store, now := getStubbedDynamoDBStore(stubber)
+	for range 2 {
+		stubber.Add(testtools.Stub{
+			OperationName: "GetItem",
+			Input: &dynamodb.GetItemInput{
+				TableName:      aws.String(APPROVAL_TABLE_NAME_DEFAULT),
+				Key:            itemKey(quorum_key, run_id),
+				ConsistentRead: aws.Bool(true),
+			},
+			Output:        &dynamodb.GetItemOutput{Item: quorumItem(now, approver_name)},
+			SkipErrorTest: true,
+		})
+	}
+
+	// act
+	quorum, err := store.Get(context.TODO(), quorum_key, run_id, requester_name)
+	other, otherErr := store.Get(context.TODO(), quorum_key, run_id, approver_name)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, quorum)
+	assert.Equal(t, []string{approver_name}, quorum.Approvers)
+	assert.Nil(t, otherErr)
+	assert.Nil(t, other, "quorum opened for another requester should not be returned")
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+func getStubbedDynamoDBStore(stubber *testtools.AwsmStubber) (*DynamoDBStore, time.Time) {
+	now := time.Unix(1700000000, 0)
+	store := NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), APPROVAL_TABLE_NAME_DEFAULT, time.Hour)
+	store.now = func() time.Time { return now }
+	return store, now
+}
+
+func quorumItem(now time.Time, approvers ...string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		KEY_ATTRIBUTE:        &types.AttributeValueMemberS{Value: quorum_key},
+		RUN_ID_ATTRIBUTE:     &types.AttributeValueMemberS{Value: strconv.FormatInt(run_id, 10)},
+		REQUESTER_ATTRIBUTE:  &types.AttributeValueMemberS{Value: requester_name},
+		APPROVERS_ATTRIBUTE:  &types.AttributeValueMemberSS{Value: approvers},
+		EXPIRES_AT_ATTRIBUTE: &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(time.Hour).Unix(), 10)},
+	}
+}
+
+func stubPutQuorum(stubber *testtools.AwsmStubber, now time.Time, err error) {
+	stub := testtools.Stub{
+		OperationName: "PutItem",
+		Input: &dynamodb.PutItemInput{
+			TableName: aws.String(APPROVAL_TABLE_NAME_DEFAULT),
+			Item: map[string]types.AttributeValue{
+				KEY_ATTRIBUTE:        &types.AttributeValueMemberS{Value: quorum_key},
+				RUN_ID_ATTRIBUTE:     &types.AttributeValueMemberS{Value: strconv.FormatInt(run_id, 10)},
+				REQUESTER_ATTRIBUTE:  &types.AttributeValueMemberS{Value: requester_name},
+				EXPIRES_AT_ATTRIBUTE: &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(time.Hour).Unix(), 10)},
+			},
+			ConditionExpression: aws.String(OPEN_CONDITION),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":requester": &types.AttributeValueMemberS{Value: requester_name},
+				":now":       &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			},
+		},
+		Output:        &dynamodb.PutItemOutput{},
+		SkipErrorTest: true,
+	}
+	if err != nil {
+		// the open quorum is read after the condition fails
+		stub.Error = &testtools.StubError{Err: err, ContinueAfter: true}
+	}
+	stubber.Add(stub)
+}
+
+func stubApproveQuorum(stubber *testtools.AwsmStubber, now time.Time, output *dynamodb.UpdateItemOutput, err error) {
+	stub := testtools.Stub{
+		OperationName: "UpdateItem",
+		Input: &dynamodb.UpdateItemInput{
+			TableName:           aws.String(APPROVAL_TABLE_NAME_DEFAULT),
+			Key:                 itemKey(quorum_key, run_id),
+			UpdateExpression:    aws.String("ADD " + APPROVERS_ATTRIBUTE + " :approver"),
+			ConditionExpression: aws.String(APPROVE_CONDITION),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":approver":  &types.AttributeValueMemberSS{Value: []string{approver_name}},
+				":requester": &types.AttributeValueMemberS{Value: requester_name},
+				":now":       &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			},
+			ReturnValues: types.ReturnValueAllNew,
+		},
+		Output:        output,
+		SkipErrorTest: true,
+	}
+	if err != nil {
+		stub.Error = &testtools.StubError{Err: err}
+	}
+	stubber.Add(stub)
+}
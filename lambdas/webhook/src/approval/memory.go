@@ -0,0 +1,97 @@
+package approval
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+MemoryStore keeps quorums in memory, approvals are only shared
+within a warm lambda so it is meant for tests and local runs
+*/
+type MemoryStore struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	quorums map[string]Quorum
+	now     func() time.Time
+}
+
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{ttl: ttl, quorums: map[string]Quorum{}, now: time.Now}
+}
+
+func (s *MemoryStore) Open(_ context.Context, quorum Quorum) (Quorum, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if open, exists := s.open(quorum.Key, quorum.RunID); exists && open.Requester == quorum.Requester {
+		return copyQuorum(open), nil
+	}
+
+	quorum.Approvers = nil
+	quorum.ExpiresAt = s.now().Add(s.ttl).Unix()
+	s.quorums[memoryKey(quorum.Key, quorum.RunID)] = quorum
+	return copyQuorum(quorum), nil
+}
+
+func (s *MemoryStore) Approve(_ context.Context, key string, runID int64, requester string, approver string) (*Quorum, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	quorum, exists := s.open(key, runID)
+	if !exists || quorum.Requester != requester {
+		return nil, nil
+	}
+
+	if !quorum.HasApproved(approver) {
+		quorum.Approvers = append(slices.Clone(quorum.Approvers), approver)
+		s.quorums[memoryKey(key, runID)] = quorum
+	}
+
+	approved := copyQuorum(quorum)
+	return &approved, nil
+}
+
+func (s *MemoryStore) Close(_ context.Context, key string, runID int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.quorums, memoryKey(key, runID))
+	return nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string, runID int64, requester string) (*Quorum, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	quorum, exists := s.open(key, runID)
+	if !exists || quorum.Requester != requester {
+		return nil, nil
+	}
+
+	open := copyQuorum(quorum)
+	return &open, nil
+}
+
+/*
+returns the quorum of the run's environment unless it has expired, the caller must hold the mutex
+*/
+func (s *MemoryStore) open(key string, runID int64) (Quorum, bool) {
+	quorum, exists := s.quorums[memoryKey(key, runID)]
+	if !exists || quorum.ExpiresAt < s.now().Unix() {
+		return Quorum{}, false
+	}
+	return quorum, true
+}
+
+func memoryKey(key string, runID int64) string {
+	return key + "@" + strconv.FormatInt(runID, 10)
+}
+
+func copyQuorum(quorum Quorum) Quorum {
+	quorum.Approvers = slices.Clone(quorum.Approvers)
+	return quorum
+}
@@ -0,0 +1,74 @@
+package approval
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	quorum_key     = "github-owner/test-repo#production"
+	run_id         = int64(123456)
+	requester_name = "github-requester"
+	approver_name  = "github-approver"
+)
+
+/*
+Test for case where the same grant holder approves twice,
+they should only be counted once towards the quorum
+*/
+func TestMemoryStoreDuplicateApproval(t *testing.T) {
+	// arrange
+	store := NewMemoryStore(time.Hour)
+	store.Open(context.TODO(), Quorum{Key: quorum_key, RunID: run_id, Requester: requester_name})
+
+	// act
+	store.Approve(context.TODO(), quorum_key, run_id, requester_name, approver_name)
+	quorum, err := store.Approve(context.TODO(), quorum_key, run_id, requester_name, approver_name)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, []string{approver_name}, quorum.Approvers)
+}
+
+/*
+Test for case where the run is re-run by another requester,
+the approvals given to the first requester should not carry over
+*/
+func TestMemoryStoreReopenedForOtherRequester(t *testing.T) {
+	// arrange
+	store := NewMemoryStore(time.Hour)
+	store.Open(context.TODO(), Quorum{Key: quorum_key, RunID: run_id, Requester: requester_name})
+	store.Approve(context.TODO(), quorum_key, run_id, requester_name, approver_name)
+
+	// act
+	quorum, err := store.Open(context.TODO(), Quorum{Key: quorum_key, RunID: run_id, Requester: approver_name})
+	stale, staleErr := store.Approve(context.TODO(), quorum_key, run_id, requester_name, "other-approver")
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, staleErr)
+	assert.Empty(t, quorum.Approvers)
+	assert.Nil(t, stale, "approvals for the previous requester should be dropped")
+}
+
+/*
+Test for case where the quorum has expired, approvals should be dropped
+*/
+func TestMemoryStoreExpiredQuorum(t *testing.T) {
+	// arrange
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryStore(time.Hour)
+	store.now = func() time.Time { return now }
+	store.Open(context.TODO(), Quorum{Key: quorum_key, RunID: run_id, Requester: requester_name})
+	now = now.Add(2 * time.Hour)
+
+	// act
+	quorum, err := store.Approve(context.TODO(), quorum_key, run_id, requester_name, approver_name)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, quorum)
+}
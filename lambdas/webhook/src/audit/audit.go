@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"webhook/db"
+	"webhook/logger"
+	"webhook/util"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+var (
+	auditorInstance util.Lazy[Auditor]
+
+	logInstance *zap.SugaredLogger
+)
+
+const (
+	AUDIT_LOG_TYPE_ENV_VAR_KEY = "AUDIT_LOG_TYPE"
+	AUDIT_LOG_TYPE_DEFAULT     = DYNAMODB_AUDIT_LOG_TYPE
+
+	AUDIT_TABLE_NAME_ENV_VAR_KEY = "AUDIT_TABLE_NAME"
+	AUDIT_TABLE_NAME_DEFAULT     = "deployment-webhooks-audit-table"
+
+	// records are also written to the bucket as JSON Lines when set
+	AUDIT_S3_BUCKET_ENV_VAR_KEY = "AUDIT_S3_BUCKET"
+	AUDIT_S3_BUCKET_DEFAULT     = ""
+
+	AUDIT_S3_PREFIX_ENV_VAR_KEY = "AUDIT_S3_PREFIX"
+	AUDIT_S3_PREFIX_DEFAULT     = "audit/"
+
+	DYNAMODB_AUDIT_LOG_TYPE = "dynamodb"
+	MEMORY_AUDIT_LOG_TYPE   = "memory"
+
+	// fixed width unlike time.RFC3339Nano, which trims trailing zeros,
+	// so records sort by RecordedAt in the order they were recorded
+	RECORDED_AT_LAYOUT = "2006-01-02T15:04:05.000000000Z07:00"
+
+	APPROVED_DECISION = "approved"
+	REJECTED_DECISION = "rejected"
+	// the deployment was left pending for a reviewer
+	PENDING_DECISION = "pending"
+)
+
+type deliveryIDKey struct{}
+
+func init() {
+	logInstance = logger.GetLogger().Sugar()
+}
+
+/*
+Record is the audit record of the decision made for one pending environment,
+kept so who approved what (and why) can be answered without reading the logs
+*/
+type Record struct {
+	// <owner>/<repo>#<env>, records of an environment are sorted by RecordedAt (UTC, RECORDED_AT_LAYOUT)
+	Key        string `json:"key"`
+	RecordedAt string `json:"recorded_at"`
+
+	DeliveryID  string `json:"delivery_id,omitempty"`
+	Source      string `json:"source"`
+	Requester   string `json:"requester"`
+	Owner       string `json:"owner"`
+	Repository  string `json:"repository"`
+	Environment string `json:"environment"`
+	RunID       int64  `json:"run_id,omitempty"`
+	// the grant holder whose approval or rejection was evaluated, empty when the requester's grant decided
+	Approver string `json:"approver,omitempty"`
+
+	// the grant the decision was made on, empty when no grant matched
+	GrantKey     string `json:"grant_key,omitempty"`
+	GrantSubject string `json:"grant_subject,omitempty"`
+
+	Decision string `json:"decision"`
+	Reason   string `json:"reason"`
+	DryRun   bool   `json:"dry_run"`
+
+	// status of GitHub's response to the review, 0 when GitHub wasn't called
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	TraceID    string `json:"trace_id,omitempty"`
+}
+
+/*
+Auditor durably records the decisions made for an event
+*/
+type Auditor interface {
+	Write(ctx context.Context, records []Record) error
+}
+
+/*
+returns a copy of ctx carrying the X-GitHub-Delivery GUID of the event being processed
+*/
+func WithDeliveryID(ctx context.Context, deliveryID string) context.Context {
+	return context.WithValue(ctx, deliveryIDKey{}, deliveryID)
+}
+
+/*
+returns the X-GitHub-Delivery GUID carried by ctx, empty when the event has no delivery (ex. the reconciler)
+*/
+func DeliveryID(ctx context.Context) string {
+	deliveryID, _ := ctx.Value(deliveryIDKey{}).(string)
+	return deliveryID
+}
+
+/*
+Returns the auditor configured through AUDIT_LOG_TYPE_ENV_VAR_KEY
+*/
+func GetAuditor(ctx context.Context) (Auditor, error) {
+	auditor, err := auditorInstance.Get(func() (Auditor, error) {
+		return newAuditorFromEnv(ctx)
+	})
+	if err != nil {
+		logInstance.Errorln("cannot configure audit log", zap.Error(err))
+		return nil, err
+	}
+	return auditor, nil
+}
+
+func newAuditorFromEnv(ctx context.Context) (Auditor, error) {
+	auditLogType := strings.ToLower(util.LookupEnv(AUDIT_LOG_TYPE_ENV_VAR_KEY, AUDIT_LOG_TYPE_DEFAULT, false))
+	funcLogger := logInstance.With(zap.String("audit_log_type", auditLogType))
+
+	var auditor Auditor
+	switch auditLogType {
+	case DYNAMODB_AUDIT_LOG_TYPE:
+		client, err := db.GetDynamoClient(ctx)
+		if err != nil {
+			funcLogger.Errorln("error observed while trying to get dynamodb client", zap.Error(err))
+			return nil, err
+		}
+		auditor = NewDynamoDBAuditor(client, util.LookupEnv(AUDIT_TABLE_NAME_ENV_VAR_KEY, AUDIT_TABLE_NAME_DEFAULT, false))
+	case MEMORY_AUDIT_LOG_TYPE:
+		funcLogger.Warnln("using an in-memory audit log, records are lost with the lambda")
+		auditor = NewMemoryAuditor()
+	default:
+		err := fmt.Errorf("unsupported audit log type %q", auditLogType)
+		funcLogger.Errorln("invalid audit log type", zap.Error(err))
+		return nil, err
+	}
+
+	bucket := util.LookupEnv(AUDIT_S3_BUCKET_ENV_VAR_KEY, AUDIT_S3_BUCKET_DEFAULT, false)
+	if bucket == "" {
+		return auditor, nil
+	}
+
+	cfg, err := db.GetAWSConfig(ctx)
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to get s3 client", zap.Error(err))
+		return nil, err
+	}
+	prefix := util.LookupEnv(AUDIT_S3_PREFIX_ENV_VAR_KEY, AUDIT_S3_PREFIX_DEFAULT, false)
+	return NewMultiAuditor(auditor, NewS3Auditor(s3.NewFromConfig(cfg), bucket, prefix)), nil
+}
+
+/*
+MultiAuditor writes the records to every auditor, an auditor
+that fails doesn't stop the records from being written to the others
+*/
+type MultiAuditor struct {
+	auditors []Auditor
+}
+
+func NewMultiAuditor(auditors ...Auditor) *MultiAuditor {
+	return &MultiAuditor{auditors: auditors}
+}
+
+func (a *MultiAuditor) Write(ctx context.Context, records []Record) error {
+	var errs []error
+	for _, auditor := range a.auditors {
+		if err := auditor.Write(ctx, records); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
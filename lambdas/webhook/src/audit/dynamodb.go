@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.uber.org/zap"
+)
+
+const (
+	KEY_ATTRIBUTE         = "key"
+	RECORDED_AT_ATTRIBUTE = "recorded_at"
+	// the table's requester index answers who made a deployment
+	REQUESTER_ATTRIBUTE = "requester"
+)
+
+/*
+DynamoDBAuditor puts every record in a table keyed by <owner>/<repo>#<env>
+and sorted by the time it was recorded
+*/
+type DynamoDBAuditor struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewDynamoDBAuditor(client *dynamodb.Client, tableName string) *DynamoDBAuditor {
+	return &DynamoDBAuditor{client: client, tableName: tableName}
+}
+
+/*
+puts the records one by one, a record that fails doesn't stop the others from being put
+*/
+func (a *DynamoDBAuditor) Write(ctx context.Context, records []Record) error {
+	funcLogger := logInstance.With(zap.String("table_name", a.tableName), zap.Int("record_count", len(records)))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBAuditor.Write")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	var errs []error
+	for _, record := range records {
+		_, err := a.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(a.tableName),
+			Item:      recordItem(record),
+		})
+		if err != nil {
+			funcLogger.Errorln("error observed while trying to put audit record", zap.String("key", record.Key), zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+returns the item of the record, empty attributes are left out
+*/
+func recordItem(record Record) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		KEY_ATTRIBUTE:         &types.AttributeValueMemberS{Value: record.Key},
+		RECORDED_AT_ATTRIBUTE: &types.AttributeValueMemberS{Value: record.RecordedAt},
+		"dry_run":             &types.AttributeValueMemberBOOL{Value: record.DryRun},
+	}
+
+	for name, value := range map[string]string{
+		"delivery_id":       record.DeliveryID,
+		"source":            record.Source,
+		REQUESTER_ATTRIBUTE: record.Requester,
+		"owner":             record.Owner,
+		"repository":        record.Repository,
+		"environment":       record.Environment,
+		"approver":          record.Approver,
+		"grant_key":         record.GrantKey,
+		"grant_subject":     record.GrantSubject,
+		"decision":          record.Decision,
+		"reason":            record.Reason,
+		"error":             record.Error,
+		"trace_id":          record.TraceID,
+	} {
+		if value != "" {
+			item[name] = &types.AttributeValueMemberS{Value: value}
+		}
+	}
+
+	if record.RunID != 0 {
+		item["run_id"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(record.RunID, 10)}
+	}
+	if record.StatusCode != 0 {
+		item["status_code"] = &types.AttributeValueMemberN{Value: strconv.Itoa(record.StatusCode)}
+	}
+
+	return item
+}
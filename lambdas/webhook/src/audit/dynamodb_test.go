@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/awsdocs/aws-doc-sdk-examples/gov2/testtools"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	approval = Record{
+		Key:         "octo-org/octo-repo#production",
+		RecordedAt:  "2024-11-05T14:03:07.123456789Z",
+		DeliveryID:  "72d3162e-cc78-11e3-81ab-4c9367dc0958",
+		Source:      "workflow_run",
+		Requester:   "octocat",
+		Owner:       "octo-org",
+		Repository:  "octo-repo",
+		Environment: "production",
+		RunID:       30433642,
+		GrantKey:    "octo-org/octo-repo#production",
+		Decision:    APPROVED_DECISION,
+		Reason:      "allow grant octo-org/octo-repo#production",
+		StatusCode:  200,
+	}
+)
+
+/*
+Test for case where a decision is audited, the record should be
+put with its empty attributes left out
+*/
+func TestDynamoDBAuditorWrite(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	auditor := NewDynamoDBAuditor(dynamodb.NewFromConfig(*stubber.SdkConfig), AUDIT_TABLE_NAME_DEFAULT)
+	stubber.Add(testtools.Stub{
+		OperationName: "PutItem",
+		Input: &dynamodb.PutItemInput{
+			TableName: aws.String(AUDIT_TABLE_NAME_DEFAULT),
+			Item: map[string]types.AttributeValue{
+				KEY_ATTRIBUTE:         &types.AttributeValueMemberS{Value: approval.Key},
+				RECORDED_AT_ATTRIBUTE: &types.AttributeValueMemberS{Value: approval.RecordedAt},
+				"delivery_id":         &types.AttributeValueMemberS{Value: approval.DeliveryID},
+				"source":              &types.AttributeValueMemberS{Value: approval.Source},
+				REQUESTER_ATTRIBUTE:   &types.AttributeValueMemberS{Value: approval.Requester},
+				"owner":               &types.AttributeValueMemberS{Value: approval.Owner},
+				"repository":          &types.AttributeValueMemberS{Value: approval.Repository},
+				"environment":         &types.AttributeValueMemberS{Value: approval.Environment},
+				"run_id":              &types.AttributeValueMemberN{Value: "30433642"},
+				"grant_key":           &types.AttributeValueMemberS{Value: approval.GrantKey},
+				"decision":            &types.AttributeValueMemberS{Value: approval.Decision},
+				"reason":              &types.AttributeValueMemberS{Value: approval.Reason},
+				"dry_run":             &types.AttributeValueMemberBOOL{Value: false},
+				"status_code":         &types.AttributeValueMemberN{Value: "200"},
+			},
+		},
+		Output:        &dynamodb.PutItemOutput{},
+		SkipErrorTest: true,
+	})
+
+	// act
+	err := auditor.Write(context.TODO(), []Record{approval})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where one of the records can't be put, the other
+records should still be put and the error returned
+*/
+func TestDynamoDBAuditorWriteError(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	auditor := NewDynamoDBAuditor(dynamodb.NewFromConfig(*stubber.SdkConfig), AUDIT_TABLE_NAME_DEFAULT)
+	rejection := approval
+	rejection.Decision = REJECTED_DECISION
+	stubber.Add(testtools.Stub{
+		OperationName: "PutItem",
+		Input:         &dynamodb.PutItemInput{TableName: aws.String(AUDIT_TABLE_NAME_DEFAULT), Item: recordItem(approval)},
+		Error:         &testtools.StubError{Err: errors.New("throttled")},
+	})
+	stubber.Add(testtools.Stub{
+		OperationName: "PutItem",
+		Input:         &dynamodb.PutItemInput{TableName: aws.String(AUDIT_TABLE_NAME_DEFAULT), Item: recordItem(rejection)},
+		Output:        &dynamodb.PutItemOutput{},
+		SkipErrorTest: true,
+	})
+
+	// act
+	err := auditor.Write(context.TODO(), []Record{approval, rejection})
+
+	// assert
+	assert.NotNil(t, err)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
@@ -0,0 +1,34 @@
+package audit
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+MemoryAuditor keeps the records in memory, it stands in for DynamoDB locally and in tests
+*/
+type MemoryAuditor struct {
+	mutex   sync.Mutex
+	records []Record
+}
+
+func NewMemoryAuditor() *MemoryAuditor {
+	return &MemoryAuditor{}
+}
+
+func (a *MemoryAuditor) Write(_ context.Context, records []Record) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.records = append(a.records, records...)
+	return nil
+}
+
+/*
+returns the records written so far
+*/
+func (a *MemoryAuditor) Records() []Record {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]Record(nil), a.records...)
+}
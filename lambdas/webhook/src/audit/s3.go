@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.uber.org/zap"
+)
+
+const (
+	JSON_LINES_CONTENT_TYPE = "application/x-ndjson"
+)
+
+/*
+S3Auditor writes the records of an event to a bucket as one JSON Lines object,
+objects are partitioned by day (<prefix>dt=YYYY-MM-DD/) so they can be queried with Athena
+*/
+type S3Auditor struct {
+	client *s3.Client
+	bucket string
+	prefix string
+	now    func() time.Time
+}
+
+func NewS3Auditor(client *s3.Client, bucket string, prefix string) *S3Auditor {
+	return &S3Auditor{client: client, bucket: bucket, prefix: prefix, now: time.Now}
+}
+
+func (a *S3Auditor) Write(ctx context.Context, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	key := a.objectKey(records[0])
+	funcLogger := logInstance.With(zap.String("bucket", a.bucket), zap.String("object_key", key), zap.Int("record_count", len(records)))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "S3Auditor.Write")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	body, err := jsonLines(records)
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to marshal audit records", zap.Error(err))
+		return err
+	}
+
+	_, err = a.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(a.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(JSON_LINES_CONTENT_TYPE),
+	})
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to put audit records", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+/*
+returns the key of the object the records are written to, named after the time of
+the first record and the delivery (or run) they were recorded for so keys don't collide
+*/
+func (a *S3Auditor) objectKey(first Record) string {
+	now := a.now().UTC()
+	name := first.DeliveryID
+	if name == "" {
+		name = fmt.Sprintf("%s-%s-%d", first.Owner, first.Repository, first.RunID)
+	}
+	return fmt.Sprintf("%sdt=%s/%s-%s.jsonl", a.prefix, now.Format(time.DateOnly), now.Format("150405.000000000"), strings.ReplaceAll(name, "/", "-"))
+}
+
+func jsonLines(records []Record) ([]byte, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return body.Bytes(), nil
+}
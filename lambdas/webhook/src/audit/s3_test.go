@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Test for case where the records of an event are written to S3,
+each record should be a line of JSON in the same order
+*/
+func TestJSONLines(t *testing.T) {
+	// arrange
+	rejection := approval
+	rejection.Decision = REJECTED_DECISION
+
+	// act
+	body, err := jsonLines([]Record{approval, rejection})
+
+	// assert
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	var record Record
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, rejection, record)
+}
+
+/*
+Test for case where records are written for a delivery and for a run
+without a delivery, keys should be partitioned by day and unique per event
+*/
+func TestObjectKey(t *testing.T) {
+	// arrange
+	auditor := NewS3Auditor(nil, "audit-bucket", AUDIT_S3_PREFIX_DEFAULT)
+	auditor.now = func() time.Time { return time.Date(2024, 11, 5, 14, 3, 7, 123456789, time.UTC) }
+	reconciled := approval
+	reconciled.DeliveryID = ""
+
+	// act
+	deliveryKey := auditor.objectKey(approval)
+	runKey := auditor.objectKey(reconciled)
+
+	// assert
+	assert.Equal(t, "audit/dt=2024-11-05/140307.123456789-72d3162e-cc78-11e3-81ab-4c9367dc0958.jsonl", deliveryKey)
+	assert.Equal(t, "audit/dt=2024-11-05/140307.123456789-octo-org-octo-repo-30433642.jsonl", runKey)
+}
@@ -5,7 +5,9 @@ import (
 	"os"
 	"sync"
 	"webhook/logger"
+	"webhook/util"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
@@ -13,6 +15,8 @@ import (
 )
 
 var (
+	awsConfig util.Lazy[aws.Config]
+
 	dynamoDbClientInstance *dynamodb.Client
 
 	logInstance *zap.SugaredLogger
@@ -24,6 +28,26 @@ func init() {
 	logInstance = logger.GetLogger().Sugar()
 }
 
+/*
+Returns the SDK config of the lambda's region with X-Ray instrumentation,
+the AWS clients of every package are built from it
+*/
+func GetAWSConfig(ctx context.Context) (aws.Config, error) {
+	cfg, err := awsConfig.Get(func() (aws.Config, error) {
+		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(os.Getenv("AWS_REGION")))
+		if err != nil {
+			return aws.Config{}, err
+		}
+		awsv2.AWSV2Instrumentor(&cfg.APIOptions)
+		return cfg, nil
+	})
+	if err != nil {
+		logInstance.Errorln("unable to load default SDK config", zap.Error(err))
+		return aws.Config{}, err
+	}
+	return cfg, nil
+}
+
 func GetDynamoClient(ctx context.Context) (*dynamodb.Client, error) {
 	if err := configureDynamoDbClient(ctx); err != nil {
 		logInstance.Errorln("error observed while trying to get dynamodb client", zap.Error(err))
@@ -37,13 +61,11 @@ func configureDynamoDbClient(ctx context.Context) error {
 
 	// ensures only one dynamodb client instance is created
 	once.Do(func() {
-		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(os.Getenv("AWS_REGION")))
+		cfg, err := GetAWSConfig(ctx)
 		if err != nil {
 			returnedErr = err
-			logInstance.Errorln("unable to load default SDK config for db client", zap.Error(err))
 			return
 		}
-		awsv2.AWSV2Instrumentor(&cfg.APIOptions)
 		dynamoDbClientInstance = dynamodb.NewFromConfig(cfg)
 	})
 
@@ -0,0 +1,143 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.uber.org/zap"
+)
+
+const (
+	DELIVERY_ID_ATTRIBUTE = "delivery_id"
+	// epoch seconds, doubles as the table's TTL attribute
+	EXPIRES_AT_ATTRIBUTE = "expires_at"
+
+	// the delivery is new, or only remembered because TTL deletion lags behind expiry
+	CLAIM_CONDITION = "attribute_not_exists(" + DELIVERY_ID_ATTRIBUTE + ") OR " + EXPIRES_AT_ATTRIBUTE + " < :now"
+)
+
+/*
+DynamoDBLedger claims deliveries with a conditional put in a table
+keyed by delivery_id, items expire through the table's TTL
+*/
+type DynamoDBLedger struct {
+	client    *dynamodb.Client
+	tableName string
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+func NewDynamoDBLedger(client *dynamodb.Client, tableName string, ttl time.Duration) *DynamoDBLedger {
+	return &DynamoDBLedger{client: client, tableName: tableName, ttl: ttl, now: time.Now}
+}
+
+/*
+puts the delivery unless it is already in the table and has not expired
+*/
+func (l *DynamoDBLedger) Claim(ctx context.Context, deliveryID string) (bool, error) {
+	funcLogger := logInstance.With(zap.String("delivery_id", deliveryID), zap.String("table_name", l.tableName))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBLedger.Claim")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	now := l.now()
+	_, err := l.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(l.tableName),
+		Item: map[string]types.AttributeValue{
+			DELIVERY_ID_ATTRIBUTE: &types.AttributeValueMemberS{Value: deliveryID},
+			EXPIRES_AT_ATTRIBUTE:  &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(l.ttl).Unix(), 10)},
+		},
+		ConditionExpression: aws.String(CLAIM_CONDITION),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		funcLogger.Infoln("delivery has already been claimed")
+		return false, nil
+	}
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to claim delivery", zap.Error(err))
+		return false, err
+	}
+
+	return true, nil
+}
+
+/*
+deletes the delivery so a redelivery of it is processed again
+*/
+func (l *DynamoDBLedger) Release(ctx context.Context, deliveryID string) error {
+	funcLogger := logInstance.With(zap.String("delivery_id", deliveryID), zap.String("table_name", l.tableName))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBLedger.Release")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	_, err := l.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(l.tableName),
+		Key: map[string]types.AttributeValue{
+			DELIVERY_ID_ATTRIBUTE: &types.AttributeValueMemberS{Value: deliveryID},
+		},
+	})
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to release delivery", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+/*
+reads the delivery with a consistent read, a delivery only remembered
+because TTL deletion lags behind expiry has not been claimed
+*/
+func (l *DynamoDBLedger) Claimed(ctx context.Context, deliveryID string) (bool, error) {
+	funcLogger := logInstance.With(zap.String("delivery_id", deliveryID), zap.String("table_name", l.tableName))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "DynamoDBLedger.Claimed")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	output, err := l.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(l.tableName),
+		Key: map[string]types.AttributeValue{
+			DELIVERY_ID_ATTRIBUTE: &types.AttributeValueMemberS{Value: deliveryID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to read delivery", zap.Error(err))
+		return false, err
+	}
+
+	expiresAt, ok := output.Item[EXPIRES_AT_ATTRIBUTE].(*types.AttributeValueMemberN)
+	if !ok {
+		return false, nil
+	}
+	expiry, err := strconv.ParseInt(expiresAt.Value, 10, 64)
+	if err != nil {
+		funcLogger.Errorln("invalid delivery expiry", zap.String("expires_at", expiresAt.Value), zap.Error(err))
+		return false, err
+	}
+
+	return expiry >= l.now().Unix(), nil
+}
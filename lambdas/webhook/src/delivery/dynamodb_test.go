@@ -0,0 +1,187 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/awsdocs/aws-doc-sdk-examples/gov2/testtools"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Test for case where the delivery has not been claimed,
+the delivery should be put with its expiry
+*/
+func TestDynamoDBLedgerClaim(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	ledger, now := getStubbedDynamoDBLedger(stubber)
+	stubPutDelivery(stubber, now, nil)
+
+	// act
+	claimed, err := ledger.Claim(context.TODO(), delivery_id)
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where the delivery has already been claimed,
+the failed condition should be read as a duplicate rather than an error
+*/
+func TestDynamoDBLedgerDuplicateClaim(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	ledger, now := getStubbedDynamoDBLedger(stubber)
+	stubPutDelivery(stubber, now, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")})
+
+	// act
+	claimed, err := ledger.Claim(context.TODO(), delivery_id)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where the table can't be written to, the error
+should be returned so the delivery isn't processed unclaimed
+*/
+func TestDynamoDBLedgerClaimError(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	ledger, now := getStubbedDynamoDBLedger(stubber)
+	stubPutDelivery(stubber, now, errors.New("throttled"))
+
+	// act
+	claimed, err := ledger.Claim(context.TODO(), delivery_id)
+
+	// assert
+	assert.NotNil(t, err)
+	assert.False(t, claimed)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where the cache has seen the delivery, the
+delivery should be a duplicate without a call to DynamoDB
+*/
+func TestCachedLedgerDuplicateDelivery(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	dynamoDBLedger, now := getStubbedDynamoDBLedger(stubber)
+	stubPutDelivery(stubber, now, nil)
+	ledger := NewCachedLedger(NewMemoryLedger(DELIVERY_CACHE_SIZE, time.Hour), dynamoDBLedger)
+
+	// act
+	claimed, err := ledger.Claim(context.TODO(), delivery_id)
+	duplicate, duplicateErr := ledger.Claim(context.TODO(), delivery_id)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, duplicateErr)
+	assert.True(t, claimed)
+	assert.False(t, duplicate)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where a delivery is released, the delivery should be
+removed from the cache and the table so a redelivery is processed
+*/
+func TestCachedLedgerRelease(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	dynamoDBLedger, now := getStubbedDynamoDBLedger(stubber)
+	cache := NewMemoryLedger(DELIVERY_CACHE_SIZE, time.Hour)
+	ledger := NewCachedLedger(cache, dynamoDBLedger)
+	stubPutDelivery(stubber, now, nil)
+	stubber.Add(testtools.Stub{
+		OperationName: "DeleteItem",
+		Input: &dynamodb.DeleteItemInput{
+			TableName: aws.String(DELIVERY_TABLE_NAME_DEFAULT),
+			Key:       map[string]types.AttributeValue{DELIVERY_ID_ATTRIBUTE: &types.AttributeValueMemberS{Value: delivery_id}},
+		},
+		Output:        &dynamodb.DeleteItemOutput{},
+		SkipErrorTest: true,
+	})
+	ledger.Claim(context.TODO(), delivery_id)
+
+	// act
+	err := ledger.Release(context.TODO(), delivery_id)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, cache.Contains(delivery_id))
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+Test for case where the delivery is only remembered because TTL
+deletion lags behind expiry, the delivery should not be claimed
+*/
+func TestDynamoDBLedgerExpiredClaimed(t *testing.T) {
+	// arrange
+	stubber := testtools.NewStubber()
+	ledger, now := getStubbedDynamoDBLedger(stubber)
+	stubber.Add(testtools.Stub{
+		OperationName: "GetItem",
+		Input: &dynamodb.GetItemInput{
+			TableName:      aws.String(DELIVERY_TABLE_NAME_DEFAULT),
+			Key:            map[string]types.AttributeValue{DELIVERY_ID_ATTRIBUTE: &types.AttributeValueMemberS{Value: delivery_id}},
+			ConsistentRead: aws.Bool(true),
+		},
+		Output: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+			DELIVERY_ID_ATTRIBUTE: &types.AttributeValueMemberS{Value: delivery_id},
+			EXPIRES_AT_ATTRIBUTE:  &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)},
+		}},
+		SkipErrorTest: true,
+	})
+
+	// act
+	claimed, err := ledger.Claimed(context.TODO(), delivery_id)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+func getStubbedDynamoDBLedger(stubber *testtools.AwsmStubber) (*DynamoDBLedger, time.Time) {
+	now := time.Unix(1700000000, 0)
+	ledger := NewDynamoDBLedger(dynamodb.NewFromConfig(*stubber.SdkConfig), DELIVERY_TABLE_NAME_DEFAULT, time.Hour)
+	ledger.now = func() time.Time { return now }
+	return ledger, now
+}
+
+func stubPutDelivery(stubber *testtools.AwsmStubber, now time.Time, err error) {
+	stub := testtools.Stub{
+		OperationName: "PutItem",
+		Input: &dynamodb.PutItemInput{
+			TableName: aws.String(DELIVERY_TABLE_NAME_DEFAULT),
+			Item: map[string]types.AttributeValue{
+				DELIVERY_ID_ATTRIBUTE: &types.AttributeValueMemberS{Value: delivery_id},
+				EXPIRES_AT_ATTRIBUTE:  &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(time.Hour).Unix(), 10)},
+			},
+			ConditionExpression: aws.String(CLAIM_CONDITION),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			},
+		},
+		Output:        &dynamodb.PutItemOutput{},
+		SkipErrorTest: true,
+	}
+	if err != nil {
+		stub.Error = &testtools.StubError{Err: err}
+	}
+	stubber.Add(stub)
+}
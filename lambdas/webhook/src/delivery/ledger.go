@@ -0,0 +1,141 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"webhook/db"
+	"webhook/logger"
+	"webhook/util"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ledgerInstance util.Lazy[Ledger]
+
+	logInstance *zap.SugaredLogger
+)
+
+const (
+	DELIVERY_LEDGER_TYPE_ENV_VAR_KEY = "DELIVERY_LEDGER_TYPE"
+	DELIVERY_LEDGER_TYPE_DEFAULT     = DYNAMODB_LEDGER_TYPE
+
+	DELIVERY_TABLE_NAME_ENV_VAR_KEY = "DELIVERY_TABLE_NAME"
+	DELIVERY_TABLE_NAME_DEFAULT     = "deployment-webhooks-deliveries-table"
+
+	// how long a delivery is remembered, GitHub only allows deliveries
+	// from the past 3 days to be redelivered
+	DELIVERY_TTL_ENV_VAR_KEY = "DELIVERY_TTL"
+	DELIVERY_TTL_DEFAULT     = "72h"
+
+	// deliveries remembered by a warm lambda, duplicates among
+	// them are answered without a round trip to DynamoDB
+	DELIVERY_CACHE_SIZE = 1024
+
+	DYNAMODB_LEDGER_TYPE = "dynamodb"
+	MEMORY_LEDGER_TYPE   = "memory"
+)
+
+func init() {
+	logInstance = logger.GetLogger().Sugar()
+}
+
+/*
+Ledger records the X-GitHub-Delivery GUIDs that have been processed so a
+delivery (a replayed payload or a redelivery by GitHub) is only processed once.
+A delivery is claimed before it is processed and released if processing
+fails, so a redelivery of a failed delivery is processed again.
+*/
+type Ledger interface {
+	// returns true if the delivery was claimed, false if it was already claimed
+	Claim(ctx context.Context, deliveryID string) (bool, error)
+	Release(ctx context.Context, deliveryID string) error
+	// returns true if the delivery has been claimed and not released, without claiming it
+	Claimed(ctx context.Context, deliveryID string) (bool, error)
+}
+
+/*
+Returns the ledger configured through DELIVERY_LEDGER_TYPE_ENV_VAR_KEY
+*/
+func GetLedger(ctx context.Context) (Ledger, error) {
+	ledger, err := ledgerInstance.Get(func() (Ledger, error) {
+		return newLedgerFromEnv(ctx)
+	})
+	if err != nil {
+		logInstance.Errorln("cannot configure delivery ledger", zap.Error(err))
+		return nil, err
+	}
+	return ledger, nil
+}
+
+func newLedgerFromEnv(ctx context.Context) (Ledger, error) {
+	ledgerType := strings.ToLower(util.LookupEnv(DELIVERY_LEDGER_TYPE_ENV_VAR_KEY, DELIVERY_LEDGER_TYPE_DEFAULT, false))
+	funcLogger := logInstance.With(zap.String("ledger_type", ledgerType))
+
+	ttl, err := time.ParseDuration(util.LookupEnv(DELIVERY_TTL_ENV_VAR_KEY, DELIVERY_TTL_DEFAULT, false))
+	if err != nil || ttl <= 0 {
+		err = fmt.Errorf("invalid delivery TTL: %w", err)
+		funcLogger.Errorln("invalid delivery TTL", zap.Error(err))
+		return nil, err
+	}
+
+	switch ledgerType {
+	case DYNAMODB_LEDGER_TYPE:
+		client, err := db.GetDynamoClient(ctx)
+		if err != nil {
+			funcLogger.Errorln("error observed while trying to get dynamodb client", zap.Error(err))
+			return nil, err
+		}
+		tableName := util.LookupEnv(DELIVERY_TABLE_NAME_ENV_VAR_KEY, DELIVERY_TABLE_NAME_DEFAULT, false)
+		return NewCachedLedger(NewMemoryLedger(DELIVERY_CACHE_SIZE, ttl), NewDynamoDBLedger(client, tableName, ttl)), nil
+	case MEMORY_LEDGER_TYPE:
+		funcLogger.Warnln("using an in-memory delivery ledger, deliveries are only deduplicated within a warm lambda")
+		return NewMemoryLedger(DELIVERY_CACHE_SIZE, ttl), nil
+	default:
+		err := fmt.Errorf("unsupported delivery ledger type %q", ledgerType)
+		funcLogger.Errorln("invalid delivery ledger type", zap.Error(err))
+		return nil, err
+	}
+}
+
+/*
+CachedLedger fronts a ledger with the in-memory ledger of the warm lambda,
+deliveries the cache has seen are duplicates without asking the ledger
+*/
+type CachedLedger struct {
+	cache  *MemoryLedger
+	ledger Ledger
+}
+
+func NewCachedLedger(cache *MemoryLedger, ledger Ledger) *CachedLedger {
+	return &CachedLedger{cache: cache, ledger: ledger}
+}
+
+func (l *CachedLedger) Claim(ctx context.Context, deliveryID string) (bool, error) {
+	if l.cache.Contains(deliveryID) {
+		return false, nil
+	}
+
+	claimed, err := l.ledger.Claim(ctx, deliveryID)
+	if err != nil {
+		return false, err
+	}
+
+	// claimed here or elsewhere, either way the delivery is a duplicate from now on
+	l.cache.Claim(ctx, deliveryID)
+	return claimed, nil
+}
+
+func (l *CachedLedger) Release(ctx context.Context, deliveryID string) error {
+	l.cache.Release(ctx, deliveryID)
+	return l.ledger.Release(ctx, deliveryID)
+}
+
+func (l *CachedLedger) Claimed(ctx context.Context, deliveryID string) (bool, error) {
+	if l.cache.Contains(deliveryID) {
+		return true, nil
+	}
+	return l.ledger.Claimed(ctx, deliveryID)
+}
@@ -0,0 +1,93 @@
+package delivery
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+MemoryLedger remembers up to size deliveries for ttl, evicting the least
+recently claimed delivery once full. It only deduplicates deliveries
+within a warm lambda, so it is used on its own in tests and as the
+cache in front of the DynamoDB ledger
+*/
+type MemoryLedger struct {
+	mutex      sync.Mutex
+	size       int
+	ttl        time.Duration
+	order      *list.List
+	deliveries map[string]*list.Element
+	now        func() time.Time
+}
+
+type claimedDelivery struct {
+	deliveryID string
+	expiresAt  time.Time
+}
+
+func NewMemoryLedger(size int, ttl time.Duration) *MemoryLedger {
+	return &MemoryLedger{
+		size:       size,
+		ttl:        ttl,
+		order:      list.New(),
+		deliveries: map[string]*list.Element{},
+		now:        time.Now,
+	}
+}
+
+func (l *MemoryLedger) Claim(_ context.Context, deliveryID string) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.contains(deliveryID) {
+		return false, nil
+	}
+
+	l.deliveries[deliveryID] = l.order.PushFront(&claimedDelivery{deliveryID: deliveryID, expiresAt: l.now().Add(l.ttl)})
+	for l.order.Len() > l.size {
+		l.remove(l.order.Back())
+	}
+	return true, nil
+}
+
+func (l *MemoryLedger) Release(_ context.Context, deliveryID string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if element, ok := l.deliveries[deliveryID]; ok {
+		l.remove(element)
+	}
+	return nil
+}
+
+func (l *MemoryLedger) Claimed(_ context.Context, deliveryID string) (bool, error) {
+	return l.Contains(deliveryID), nil
+}
+
+/*
+returns true if the delivery has been claimed and has not expired
+*/
+func (l *MemoryLedger) Contains(deliveryID string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.contains(deliveryID)
+}
+
+func (l *MemoryLedger) contains(deliveryID string) bool {
+	element, ok := l.deliveries[deliveryID]
+	if !ok {
+		return false
+	}
+	if l.now().After(element.Value.(*claimedDelivery).expiresAt) {
+		l.remove(element)
+		return false
+	}
+	return true
+}
+
+func (l *MemoryLedger) remove(element *list.Element) {
+	l.order.Remove(element)
+	delete(l.deliveries, element.Value.(*claimedDelivery).deliveryID)
+}
@@ -0,0 +1,87 @@
+package delivery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	delivery_id = "72d3162e-cc78-11e3-81ab-4c9367dc0958"
+)
+
+/*
+Test for case where a delivery is claimed twice, only
+the first claim should succeed
+*/
+func TestMemoryLedgerDuplicateDelivery(t *testing.T) {
+	// arrange
+	ledger := NewMemoryLedger(DELIVERY_CACHE_SIZE, time.Hour)
+
+	// act
+	claimed, err := ledger.Claim(context.TODO(), delivery_id)
+	duplicate, duplicateErr := ledger.Claim(context.TODO(), delivery_id)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, duplicateErr)
+	assert.True(t, claimed)
+	assert.False(t, duplicate)
+}
+
+/*
+Test for case where a claimed delivery is released,
+the delivery should be claimable again
+*/
+func TestMemoryLedgerReleasedDelivery(t *testing.T) {
+	// arrange
+	ledger := NewMemoryLedger(DELIVERY_CACHE_SIZE, time.Hour)
+	ledger.Claim(context.TODO(), delivery_id)
+
+	// act
+	ledger.Release(context.TODO(), delivery_id)
+	claimed, err := ledger.Claim(context.TODO(), delivery_id)
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+}
+
+/*
+Test for case where a claimed delivery has expired,
+the delivery should be claimable again
+*/
+func TestMemoryLedgerExpiredDelivery(t *testing.T) {
+	// arrange
+	ledger := NewMemoryLedger(DELIVERY_CACHE_SIZE, time.Hour)
+	ledger.Claim(context.TODO(), delivery_id)
+	ledger.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	// act
+	claimed, err := ledger.Claim(context.TODO(), delivery_id)
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+}
+
+/*
+Test for case where the ledger is full, the least
+recently claimed delivery should be evicted
+*/
+func TestMemoryLedgerEviction(t *testing.T) {
+	// arrange
+	ledger := NewMemoryLedger(2, time.Hour)
+
+	// act
+	ledger.Claim(context.TODO(), "first")
+	ledger.Claim(context.TODO(), "second")
+	ledger.Claim(context.TODO(), "third")
+
+	// assert
+	assert.False(t, ledger.Contains("first"))
+	assert.True(t, ledger.Contains("second"))
+	assert.True(t, ledger.Contains("third"))
+}
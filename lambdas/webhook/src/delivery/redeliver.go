@@ -0,0 +1,295 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	gh "webhook/github"
+	"webhook/util"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/google/go-github/v66/github"
+	"go.uber.org/zap"
+)
+
+const (
+	// comma separated hooks whose failed deliveries are redelivered,
+	// <owner>/<repo>:<hook_id> for a repository hook or <org>:<hook_id> for an organization hook
+	REDELIVERY_HOOKS_ENV_VAR_KEY = "REDELIVERY_HOOKS"
+	REDELIVERY_HOOKS_DEFAULT     = ""
+
+	// how far back failed deliveries are looked for, GitHub only
+	// allows deliveries from the past 3 days to be redelivered
+	REDELIVERY_LOOKBACK_ENV_VAR_KEY = "REDELIVERY_LOOKBACK"
+	REDELIVERY_LOOKBACK_DEFAULT     = "6h"
+	REDELIVERY_MAX_LOOKBACK         = 72 * time.Hour
+
+	// attempts (the original delivery and its redeliveries) after which a delivery is given up on
+	REDELIVERY_MAX_ATTEMPTS_ENV_VAR_KEY = "REDELIVERY_MAX_ATTEMPTS"
+	REDELIVERY_MAX_ATTEMPTS_DEFAULT     = "3"
+
+	HOOK_ID_SEPARATOR       = ":"
+	HOOK_DELIVERY_PAGE_SIZE = 100
+)
+
+/*
+Hook is a repository webhook, or an organization webhook when it has no repository
+*/
+type Hook struct {
+	Owner      string
+	Repository string
+	ID         int64
+}
+
+func (h Hook) String() string {
+	if h.Repository == "" {
+		return h.Owner + HOOK_ID_SEPARATOR + strconv.FormatInt(h.ID, 10)
+	}
+	return h.Owner + "/" + h.Repository + HOOK_ID_SEPARATOR + strconv.FormatInt(h.ID, 10)
+}
+
+/*
+returns the hooks sourced from REDELIVERY_HOOKS_ENV_VAR_KEY along with
+the lookback window and attempts after which a delivery is given up on
+*/
+func RedeliveryConfig() ([]Hook, time.Duration, int, error) {
+	var hooks []Hook
+	for _, value := range strings.Split(util.LookupEnv(REDELIVERY_HOOKS_ENV_VAR_KEY, REDELIVERY_HOOKS_DEFAULT, false), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		hook, err := parseHook(value)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	lookback, err := time.ParseDuration(util.LookupEnv(REDELIVERY_LOOKBACK_ENV_VAR_KEY, REDELIVERY_LOOKBACK_DEFAULT, false))
+	if err != nil || lookback <= 0 {
+		return nil, 0, 0, fmt.Errorf("invalid redelivery lookback: %w", err)
+	}
+	if lookback > REDELIVERY_MAX_LOOKBACK {
+		logInstance.Warnln("redelivery lookback is longer than GitHub allows deliveries to be redelivered", zap.Duration("lookback", lookback))
+		lookback = REDELIVERY_MAX_LOOKBACK
+	}
+
+	maxAttempts, err := strconv.Atoi(util.LookupEnv(REDELIVERY_MAX_ATTEMPTS_ENV_VAR_KEY, REDELIVERY_MAX_ATTEMPTS_DEFAULT, false))
+	if err != nil || maxAttempts <= 0 {
+		return nil, 0, 0, fmt.Errorf("invalid redelivery max attempts: %w", err)
+	}
+
+	return hooks, lookback, maxAttempts, nil
+}
+
+func parseHook(value string) (Hook, error) {
+	target, id, found := strings.Cut(value, HOOK_ID_SEPARATOR)
+	hookID, err := strconv.ParseInt(id, 10, 64)
+	if !found || target == "" || err != nil {
+		return Hook{}, fmt.Errorf("hook %q is not an <owner>/<repo>:<hook_id> or <org>:<hook_id>", value)
+	}
+
+	owner, repository, isRepository := strings.Cut(target, "/")
+	if owner == "" || (isRepository && repository == "") {
+		return Hook{}, fmt.Errorf("hook %q is not an <owner>/<repo>:<hook_id> or <org>:<hook_id>", value)
+	}
+
+	return Hook{Owner: owner, Repository: repository, ID: hookID}, nil
+}
+
+/*
+Redeliverer asks GitHub to redeliver the recent deliveries of hooks that failed,
+GitHub records failed deliveries (ex. a 500 from a transient DynamoDB error) but
+never retries them. Deliveries already claimed in the ledger were processed
+(ex. GitHub timed out waiting on the response) and are not redelivered
+*/
+type Redeliverer struct {
+	ledger      Ledger
+	lookback    time.Duration
+	maxAttempts int
+	clientFor   func(ctx context.Context, owner string) (*github.Client, error)
+	now         func() time.Time
+}
+
+/*
+creates a redeliverer using the client of the app installation of each hook's owner
+*/
+func NewRedeliverer(ledger Ledger, lookback time.Duration, maxAttempts int) *Redeliverer {
+	return &Redeliverer{
+		ledger:      ledger,
+		lookback:    lookback,
+		maxAttempts: maxAttempts,
+		clientFor: func(ctx context.Context, owner string) (*github.Client, error) {
+			return gh.GetInstallationClient(ctx, 0, owner)
+		},
+		now: time.Now,
+	}
+}
+
+/*
+redelivers the failed deliveries of every hook, a hook that
+fails doesn't stop the deliveries of the others from being redelivered
+*/
+func (r *Redeliverer) RedeliverFailed(ctx context.Context, hooks []Hook) error {
+	funcLogger := logInstance.With(zap.Duration("lookback", r.lookback), zap.Int("max_attempts", r.maxAttempts))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "RedeliverFailed")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	var errs []error
+	for _, hook := range hooks {
+		if err := r.redeliverHook(ctx, hook, funcLogger.With(zap.Stringer("hook", hook))); err != nil {
+			funcLogger.Errorln("error observed while redelivering failed deliveries of hook", zap.Stringer("hook", hook), zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Redeliverer) redeliverHook(ctx context.Context, hook Hook, funcLogger *zap.SugaredLogger) error {
+	client, err := r.clientFor(ctx, hook.Owner)
+	if err != nil {
+		funcLogger.Errorln("error while getting github client for hook", zap.Error(err))
+		return err
+	}
+
+	deliveries, err := listRecentDeliveries(ctx, client, hook, r.now().Add(-r.lookback))
+	if err != nil {
+		funcLogger.Errorln("error observed while listing hook deliveries", zap.Error(err))
+		return err
+	}
+
+	var errs []error
+	redelivered := 0
+	for _, attempts := range groupAttempts(deliveries) {
+		latest := attempts[0]
+		deliveryLogger := funcLogger.With(zap.String("delivery_id", latest.GetGUID()), zap.Int("attempt_count", len(attempts)), zap.Int("status_code", latest.GetStatusCode()))
+
+		if !retryable(attempts) {
+			continue
+		}
+		if len(attempts) >= r.maxAttempts {
+			deliveryLogger.Warnln("giving up on failed delivery, it has been attempted too many times")
+			continue
+		}
+
+		claimed, err := r.ledger.Claimed(ctx, latest.GetGUID())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if claimed {
+			deliveryLogger.Infoln("failed delivery has already been processed, not redelivering it")
+			continue
+		}
+
+		if err := redeliver(ctx, client, hook, latest.GetID()); err != nil {
+			deliveryLogger.Errorln("error observed while redelivering failed delivery", zap.Error(err))
+			errs = append(errs, err)
+			continue
+		}
+		deliveryLogger.Infoln("redelivered failed delivery")
+		redelivered++
+	}
+
+	funcLogger.Infoln("redelivered failed deliveries of hook", zap.Int("delivery_count", len(deliveries)), zap.Int("redelivered_count", redelivered))
+	return errors.Join(errs...)
+}
+
+/*
+lists the deliveries of the hook made since the given time, newest first.
+Deliveries are listed newest first so paging stops at the first older delivery
+*/
+func listRecentDeliveries(ctx context.Context, client *github.Client, hook Hook, since time.Time) ([]*github.HookDelivery, error) {
+	opts := &github.ListCursorOptions{PerPage: HOOK_DELIVERY_PAGE_SIZE}
+
+	var deliveries []*github.HookDelivery
+	for {
+		var page []*github.HookDelivery
+		var resp *github.Response
+		var err error
+		if hook.Repository == "" {
+			page, resp, err = client.Organizations.ListHookDeliveries(ctx, hook.Owner, hook.ID, opts)
+		} else {
+			page, resp, err = client.Repositories.ListHookDeliveries(ctx, hook.Owner, hook.Repository, hook.ID, opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, delivery := range page {
+			if delivery.GetDeliveredAt().Before(since) {
+				return deliveries, nil
+			}
+			deliveries = append(deliveries, delivery)
+		}
+
+		if resp.Cursor == "" {
+			return deliveries, nil
+		}
+		opts.Cursor = resp.Cursor
+	}
+}
+
+/*
+groups the attempts of each delivery by GUID, a redelivery keeps the GUID of the
+original delivery. Attempts keep the newest first order they were listed in
+*/
+func groupAttempts(deliveries []*github.HookDelivery) [][]*github.HookDelivery {
+	var groups [][]*github.HookDelivery
+	index := map[string]int{}
+	for _, delivery := range deliveries {
+		i, seen := index[delivery.GetGUID()]
+		if !seen {
+			i = len(groups)
+			index[delivery.GetGUID()] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], delivery)
+	}
+	return groups
+}
+
+/*
+returns true if no attempt of the delivery succeeded and the latest attempt failed
+with a server error or timed out (no status code). Deliveries the lambda rejected
+(ex. an invalid signature) would only be rejected again
+*/
+func retryable(attempts []*github.HookDelivery) bool {
+	for _, attempt := range attempts {
+		if statusCode := attempt.GetStatusCode(); statusCode >= 200 && statusCode < 300 {
+			return false
+		}
+	}
+
+	statusCode := attempts[0].GetStatusCode()
+	return statusCode == 0 || statusCode >= http.StatusInternalServerError
+}
+
+/*
+asks GitHub to redeliver the delivery, GitHub accepts the redelivery with a 202
+*/
+func redeliver(ctx context.Context, client *github.Client, hook Hook, deliveryID int64) error {
+	var err error
+	if hook.Repository == "" {
+		_, _, err = client.Organizations.RedeliverHookDelivery(ctx, hook.Owner, hook.ID, deliveryID)
+	} else {
+		_, _, err = client.Repositories.RedeliverHookDelivery(ctx, hook.Owner, hook.Repository, hook.ID, deliveryID)
+	}
+
+	var accepted *github.AcceptedError
+	if errors.As(err, &accepted) {
+		return nil
+	}
+	return err
+}
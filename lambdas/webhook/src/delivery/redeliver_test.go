@@ -0,0 +1,153 @@
+package delivery
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+)
+
+const (
+	failed_delivery_id    = "0b989ba4-242f-11e5-81e1-c7b6966d2516"
+	succeeded_delivery_id = "58474f00-b361-11eb-836d-0e4f3503ccbe"
+	rejected_delivery_id  = "e2b1d1f0-3c5a-11ef-9a0a-7a1bc6d4e2a1"
+	stale_delivery_id     = "9a7d8bb0-3c5a-11ef-8d2b-1e0b4e7f5c33"
+	hook_owner            = "octo-org"
+	hook_repository       = "octo-repo"
+	hook_id               = int64(12345678)
+)
+
+var (
+	redelivery_now = time.Unix(1700000000, 0)
+)
+
+/*
+Test for case where a repository hook has failed, succeeded, rejected and stale deliveries,
+only the failed delivery within the lookback window should be redelivered
+*/
+func TestRedeliverFailedDelivery(t *testing.T) {
+	// arrange
+	var redelivered []string
+	client := getMockedHooksGhClient(ghMock.GetReposHooksDeliveriesByOwnerByRepoByHookId, ghMock.PostReposHooksDeliveriesAttemptsByOwnerByRepoByHookIdByDeliveryId, []*github.HookDelivery{
+		hookDelivery(1, failed_delivery_id, 5*time.Minute, http.StatusInternalServerError),
+		hookDelivery(2, succeeded_delivery_id, 10*time.Minute, http.StatusOK),
+		hookDelivery(3, succeeded_delivery_id, 20*time.Minute, http.StatusInternalServerError),
+		hookDelivery(4, rejected_delivery_id, 30*time.Minute, http.StatusBadRequest),
+		hookDelivery(5, stale_delivery_id, 2*time.Hour, http.StatusInternalServerError),
+	}, &redelivered)
+	redeliverer := getRedeliverer(client, NewMemoryLedger(DELIVERY_CACHE_SIZE, time.Hour))
+
+	// act
+	err := redeliverer.RedeliverFailed(context.TODO(), []Hook{{Owner: hook_owner, Repository: hook_repository, ID: hook_id}})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1"}, redelivered)
+}
+
+/*
+Test for case where the failed delivery was claimed in the ledger (ex. GitHub
+timed out waiting on the response), the delivery should not be redelivered
+*/
+func TestRedeliverClaimedDelivery(t *testing.T) {
+	// arrange
+	var redelivered []string
+	client := getMockedHooksGhClient(ghMock.GetReposHooksDeliveriesByOwnerByRepoByHookId, ghMock.PostReposHooksDeliveriesAttemptsByOwnerByRepoByHookIdByDeliveryId, []*github.HookDelivery{
+		hookDelivery(1, failed_delivery_id, 5*time.Minute, 0),
+	}, &redelivered)
+	ledger := NewMemoryLedger(DELIVERY_CACHE_SIZE, time.Hour)
+	ledger.Claim(context.TODO(), failed_delivery_id)
+	redeliverer := getRedeliverer(client, ledger)
+
+	// act
+	err := redeliverer.RedeliverFailed(context.TODO(), []Hook{{Owner: hook_owner, Repository: hook_repository, ID: hook_id}})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Empty(t, redelivered)
+}
+
+/*
+Test for case where an organization hook's delivery has already been attempted
+the maximum number of times, the delivery should be given up on
+*/
+func TestRedeliverMaxAttempts(t *testing.T) {
+	// arrange
+	var redelivered []string
+	client := getMockedHooksGhClient(ghMock.GetOrgsHooksDeliveriesByOrgByHookId, ghMock.PostOrgsHooksDeliveriesAttemptsByOrgByHookIdByDeliveryId, []*github.HookDelivery{
+		hookDelivery(3, failed_delivery_id, 5*time.Minute, http.StatusBadGateway),
+		hookDelivery(2, failed_delivery_id, 10*time.Minute, http.StatusInternalServerError),
+		hookDelivery(1, failed_delivery_id, 15*time.Minute, http.StatusInternalServerError),
+		hookDelivery(4, succeeded_delivery_id, 20*time.Minute, http.StatusServiceUnavailable),
+	}, &redelivered)
+	redeliverer := getRedeliverer(client, NewMemoryLedger(DELIVERY_CACHE_SIZE, time.Hour))
+
+	// act
+	err := redeliverer.RedeliverFailed(context.TODO(), []Hook{{Owner: hook_owner, ID: hook_id}})
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"4"}, redelivered)
+}
+
+/*
+Test for case where the hooks are configured, repository and organization
+hooks should be parsed and entries without a hook ID should be refused
+*/
+func TestRedeliveryConfig(t *testing.T) {
+	// arrange
+	t.Setenv(REDELIVERY_HOOKS_ENV_VAR_KEY, " octo-org/octo-repo:123, ,octo-org:456 ")
+	t.Setenv(REDELIVERY_LOOKBACK_ENV_VAR_KEY, "96h")
+
+	// act
+	hooks, lookback, maxAttempts, err := RedeliveryConfig()
+	t.Setenv(REDELIVERY_HOOKS_ENV_VAR_KEY, "octo-org/octo-repo")
+	_, _, _, invalidErr := RedeliveryConfig()
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, []Hook{{Owner: "octo-org", Repository: "octo-repo", ID: 123}, {Owner: "octo-org", ID: 456}}, hooks)
+	assert.Equal(t, REDELIVERY_MAX_LOOKBACK, lookback)
+	assert.Equal(t, 3, maxAttempts)
+	assert.NotNil(t, invalidErr)
+}
+
+func getRedeliverer(client *github.Client, ledger Ledger) *Redeliverer {
+	redeliverer := NewRedeliverer(ledger, time.Hour, 3)
+	redeliverer.clientFor = func(ctx context.Context, owner string) (*github.Client, error) { return client, nil }
+	redeliverer.now = func() time.Time { return redelivery_now }
+	return redeliverer
+}
+
+func hookDelivery(id int64, guid string, age time.Duration, statusCode int) *github.HookDelivery {
+	return &github.HookDelivery{
+		ID:          github.Int64(id),
+		GUID:        github.String(guid),
+		DeliveredAt: &github.Timestamp{Time: redelivery_now.Add(-age)},
+		StatusCode:  github.Int(statusCode),
+	}
+}
+
+/*
+mocks the hook's deliveries, the IDs of redelivered deliveries are appended to redelivered
+*/
+func getMockedHooksGhClient(list ghMock.EndpointPattern, attempts ghMock.EndpointPattern, deliveries []*github.HookDelivery, redelivered *[]string) *github.Client {
+	mockedHTTPClient := ghMock.NewMockedHTTPClient(
+		ghMock.WithRequestMatch(list, deliveries),
+		ghMock.WithRequestMatchHandler(
+			attempts,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				*redelivered = append(*redelivered, path.Base(path.Dir(r.URL.Path)))
+				w.WriteHeader(http.StatusAccepted)
+			}),
+		),
+	)
+
+	return github.NewClient(mockedHTTPClient)
+}
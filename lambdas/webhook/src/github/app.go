@@ -0,0 +1,322 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"webhook/secrets"
+	"webhook/util"
+
+	"github.com/google/go-github/v66/github"
+	"go.uber.org/zap"
+)
+
+const (
+	// the app is used over the PAT when this secret name is set, the secret is
+	// a JSON object with the app_id and the PEM encoded private_key of the app
+	GITHUB_APP_SECRET_NAME_ENV_VAR_KEY = "GITHUB_APP_SECRET_NAME"
+	GITHUB_APP_SECRET_NAME_DEFAULT     = ""
+
+	// GitHub rejects app JWTs that expire more than 10 minutes out,
+	// the issued at time is backdated to allow for clock drift
+	APP_JWT_LIFETIME = 9 * time.Minute
+	APP_JWT_BACKDATE = time.Minute
+
+	// installation tokens live for an hour, they are refreshed
+	// when less than this is left so a token never expires mid event
+	INSTALLATION_TOKEN_REFRESH_WINDOW = 5 * time.Minute
+)
+
+var (
+	appAuthInstance *AppAuth
+
+	appOnce          sync.Once
+	appSourcingError error
+)
+
+/*
+AppCredentials is the layout of the GitHub App secret
+*/
+type AppCredentials struct {
+	AppID      int64  `json:"app_id"`
+	PrivateKey string `json:"private_key"`
+}
+
+/*
+AppAuth authenticates as a GitHub App, signing JWTs with the app's
+private key to create installation tokens. Installation tokens and the
+installation of each owner are cached for the life of the warm lambda.
+*/
+type AppAuth struct {
+	appID      int64
+	privateKey *rsa.PrivateKey
+	endpoint   *Endpoint
+
+	// guards the maps only, GitHub is never called while it is held
+	mutex         sync.Mutex
+	installations map[string]int64
+	tokens        map[int64]*installationToken
+}
+
+type installationToken struct {
+	client    *github.Client
+	expiresAt time.Time
+}
+
+/*
+creates an AppAuth from the app ID and PEM encoded (PKCS1 or PKCS8) private key
+for an app registered on the endpoint (github.com or GitHub Enterprise Server)
+*/
+func NewAppAuth(appID int64, privateKeyPEM []byte, endpoint *Endpoint) (*AppAuth, error) {
+	privateKey, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppAuth{
+		appID:         appID,
+		privateKey:    privateKey,
+		endpoint:      endpoint,
+		installations: map[string]int64{},
+		tokens:        map[int64]*installationToken{},
+	}, nil
+}
+
+/*
+returns a client for the installation, authenticated as the GitHub App when one is
+configured. The installation ID of the event is used if set, otherwise the installation
+is looked up by owner. The PAT client is only returned when no app is configured, an app
+that can't be used is an error so approvals never quietly fall back to the PAT's user.
+Events from GitHub Enterprise Server (see WithEnterpriseHost) get a client of that server.
+*/
+func GetInstallationClient(ctx context.Context, installationID int64, owner string) (*github.Client, error) {
+	host := EnterpriseHost(ctx)
+	funcLogger := logInstance.With(zap.Int64("installation_id", installationID), zap.String("owner", owner), zap.String("enterprise_host", host))
+
+	endpoint, err := ResolveEndpoint(host)
+	if err != nil {
+		funcLogger.Errorln("error observed while resolving github endpoint", zap.Error(err))
+		return nil, err
+	}
+
+	var appAuth *AppAuth
+	if endpoint.IsEnterprise() {
+		appAuth, err = getEnterpriseAppAuth(ctx, endpoint)
+	} else {
+		appAuth, err = getAppAuth(ctx)
+	}
+	if err != nil {
+		funcLogger.Errorln("error observed while sourcing github app credentials", zap.Error(err))
+		return nil, err
+	}
+	if appAuth == nil {
+		if endpoint.IsEnterprise() {
+			return getEnterprisePATClient(ctx, endpoint)
+		}
+		return GetGitHubClient(ctx)
+	}
+
+	client, err := appAuth.InstallationClient(ctx, installationID, owner)
+	if err != nil {
+		funcLogger.Errorln("error observed while getting github app installation client", zap.Error(err))
+		return nil, err
+	}
+
+	return client, nil
+}
+
+/*
+returns the client of the installation, creating an installation token
+if there is no cached token or the cached token is about to expire.
+Concurrent events missing the cache may each create a token, the last one is kept
+*/
+func (a *AppAuth) InstallationClient(ctx context.Context, installationID int64, owner string) (*github.Client, error) {
+	if installationID == 0 {
+		var err error
+		if installationID, err = a.findInstallation(ctx, owner); err != nil {
+			return nil, err
+		}
+	}
+
+	a.mutex.Lock()
+	token, cached := a.tokens[installationID]
+	a.mutex.Unlock()
+	if cached && time.Until(token.expiresAt) > INSTALLATION_TOKEN_REFRESH_WINDOW {
+		return token.client, nil
+	}
+
+	appClient, err := a.appClient()
+	if err != nil {
+		return nil, err
+	}
+
+	created, _, err := appClient.Apps.CreateInstallationToken(ctx, installationID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create installation token for installation %d: %w", installationID, err)
+	}
+
+	client, err := a.endpoint.NewClient(created.GetToken())
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	a.tokens[installationID] = &installationToken{
+		client:    client,
+		expiresAt: created.GetExpiresAt().Time,
+	}
+	a.mutex.Unlock()
+	logInstance.Infoln("created github app installation token", zap.Int64("installation_id", installationID), zap.Time("expires_at", created.GetExpiresAt().Time))
+
+	return client, nil
+}
+
+/*
+looks up the installation of the app on the owner's organization,
+or on the owner's user account if the owner isn't an organization
+*/
+func (a *AppAuth) findInstallation(ctx context.Context, owner string) (int64, error) {
+	if owner == "" {
+		return 0, errors.New("an installation ID or owner is needed to find the app installation")
+	}
+
+	owner = strings.ToLower(owner)
+	a.mutex.Lock()
+	installationID, cached := a.installations[owner]
+	a.mutex.Unlock()
+	if cached {
+		return installationID, nil
+	}
+
+	appClient, err := a.appClient()
+	if err != nil {
+		return 0, err
+	}
+
+	installation, resp, err := appClient.Apps.FindOrganizationInstallation(ctx, owner)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		installation, _, err = appClient.Apps.FindUserInstallation(ctx, owner)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to find app installation for %s: %w", owner, err)
+	}
+
+	a.mutex.Lock()
+	a.installations[owner] = installation.GetID()
+	a.mutex.Unlock()
+	return installation.GetID(), nil
+}
+
+/*
+returns a client authenticated as the app itself with a freshly signed JWT
+*/
+func (a *AppAuth) appClient() (*github.Client, error) {
+	jwt, err := a.signJWT(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return a.endpoint.NewClient(jwt)
+}
+
+/*
+signs an RS256 JWT issued by the app, valid from a minute before now for APP_JWT_LIFETIME
+*/
+func (a *AppAuth) signJWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-APP_JWT_BACKDATE).Unix(),
+		"exp": now.Add(APP_JWT_LIFETIME).Unix(),
+		"iss": strconv.FormatInt(a.appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+/*
+parses a PEM encoded PKCS1 (as downloaded from GitHub) or PKCS8 RSA private key
+*/
+func parsePrivateKey(privateKeyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("github app private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse github app private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("github app private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+/*
+returns the github.com app auth, or nil if no app is configured.
+The app credentials are only sourced from Secrets Manager once
+*/
+func getAppAuth(ctx context.Context) (*AppAuth, error) {
+	appOnce.Do(func() {
+		appSecretName := util.LookupEnv(GITHUB_APP_SECRET_NAME_ENV_VAR_KEY, GITHUB_APP_SECRET_NAME_DEFAULT, false)
+		appAuthInstance, appSourcingError = sourceAppAuth(ctx, appSecretName, dotcomEndpoint)
+	})
+
+	return appAuthInstance, appSourcingError
+}
+
+/*
+sources the app credentials from the secret and creates the app auth
+of the endpoint, returns nil if the secret name is empty
+*/
+func sourceAppAuth(ctx context.Context, appSecretName string, endpoint *Endpoint) (*AppAuth, error) {
+	if appSecretName == "" {
+		return nil, nil
+	}
+
+	appSecret, err := secrets.GetSecretValue(ctx, appSecretName)
+	if appSecret == nil || err != nil {
+		logInstance.Errorln("error while getting github app secret value", zap.Error(err))
+		return nil, errors.Join(errors.New("unable to get github app secret value"), err)
+	}
+
+	var credentials AppCredentials
+	if err := json.Unmarshal([]byte(*appSecret), &credentials); err != nil {
+		return nil, fmt.Errorf("github app secret is not valid JSON: %w", err)
+	}
+	if credentials.AppID == 0 || credentials.PrivateKey == "" {
+		return nil, errors.New("github app secret is missing the app_id or private_key")
+	}
+
+	return NewAppAuth(credentials.AppID, []byte(credentials.PrivateKey), endpoint)
+}
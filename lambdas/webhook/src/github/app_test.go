@@ -0,0 +1,308 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+)
+
+const (
+	app_id          = int64(4242)
+	installation_id = int64(1337)
+	owner_name      = "octo-org"
+)
+
+/*
+Test for case where the app signs a JWT, the JWT should be
+signed by the private key and issued by the app
+*/
+func TestSignJWT(t *testing.T) {
+	// arrange
+	privateKey := generatePrivateKey(t)
+	appAuth, err := NewAppAuth(app_id, encodePKCS1(privateKey), dotcomEndpoint)
+	assert.Nil(t, err)
+	now := time.Now()
+
+	// act
+	jwt, signErr := appAuth.signJWT(now)
+
+	// assert
+	assert.Nil(t, signErr)
+	parts := strings.Split(jwt, ".")
+	assert.Len(t, parts, 3)
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.Nil(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, digest[:], signature))
+
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims map[string]interface{}
+	assert.Nil(t, json.Unmarshal(claimsJSON, &claims))
+	assert.Equal(t, "4242", claims["iss"])
+	assert.Equal(t, float64(now.Add(-APP_JWT_BACKDATE).Unix()), claims["iat"])
+	assert.Equal(t, float64(now.Add(APP_JWT_LIFETIME).Unix()), claims["exp"])
+}
+
+/*
+Test for case where the private key is PKCS8 encoded
+*/
+func TestPKCS8PrivateKey(t *testing.T) {
+	// arrange
+	privateKey := generatePrivateKey(t)
+	der, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+
+	// act
+	_, err := NewAppAuth(app_id, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), dotcomEndpoint)
+
+	// assert
+	assert.Nil(t, err)
+}
+
+/*
+Test for case where the private key is not PEM encoded
+*/
+func TestInvalidPrivateKey(t *testing.T) {
+	// act
+	_, err := NewAppAuth(app_id, []byte("not a key"), dotcomEndpoint)
+
+	// assert
+	assert.NotNil(t, err)
+}
+
+/*
+Test for case where an installation token is still valid,
+the cached token should be reused
+*/
+func TestInstallationTokenCached(t *testing.T) {
+	// arrange
+	tokensCreated := 0
+	appAuth := getMockedAppAuth(t, &tokensCreated, time.Hour)
+
+	// act
+	_, err := appAuth.InstallationClient(context.TODO(), installation_id, owner_name)
+	_, cachedErr := appAuth.InstallationClient(context.TODO(), installation_id, owner_name)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, cachedErr)
+	assert.Equal(t, 1, tokensCreated)
+}
+
+/*
+Test for case where an installation token is about to
+expire, a new token should be created
+*/
+func TestInstallationTokenRefreshed(t *testing.T) {
+	// arrange
+	tokensCreated := 0
+	appAuth := getMockedAppAuth(t, &tokensCreated, INSTALLATION_TOKEN_REFRESH_WINDOW-time.Minute)
+
+	// act
+	_, err := appAuth.InstallationClient(context.TODO(), installation_id, owner_name)
+	_, refreshedErr := appAuth.InstallationClient(context.TODO(), installation_id, owner_name)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, refreshedErr)
+	assert.Equal(t, 2, tokensCreated)
+}
+
+/*
+Test for case where the event has no installation ID, the installation
+should be looked up by owner, falling back to the user installation
+*/
+func TestInstallationFoundByOwner(t *testing.T) {
+	// arrange
+	tokensCreated := 0
+	appAuth := getMockedAppAuth(t, &tokensCreated, time.Hour)
+
+	// act
+	_, err := appAuth.InstallationClient(context.TODO(), 0, owner_name)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, installation_id, appAuth.installations[owner_name])
+	assert.Equal(t, 1, tokensCreated)
+}
+
+/*
+Test for case where the app is configured but its credentials can't be
+sourced, the error should be returned rather than falling back to the PAT
+*/
+func TestAppSourcingErrorNotFallingBack(t *testing.T) {
+	// arrange
+	sourcingErr := errors.New("github app secret is not valid JSON")
+	useAppAuth(t, nil, sourcingErr)
+
+	// act
+	client, err := GetInstallationClient(context.TODO(), installation_id, owner_name)
+
+	// assert
+	assert.ErrorIs(t, err, sourcingErr)
+	assert.Nil(t, client)
+}
+
+/*
+Test for case where GitHub fails to create an installation token, the
+error should be returned rather than falling back to the PAT
+*/
+func TestInstallationTokenErrorNotFallingBack(t *testing.T) {
+	// arrange
+	useAppAuth(t, newMockedAppAuth(t,
+		ghMock.WithRequestMatchHandler(
+			ghMock.PostAppInstallationsAccessTokensByInstallationId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ghMock.WriteError(w, http.StatusUnprocessableEntity, "installation suspended")
+			}),
+		),
+	), nil)
+
+	// act
+	client, err := GetInstallationClient(context.TODO(), installation_id, owner_name)
+
+	// assert
+	assert.NotNil(t, err)
+	assert.Nil(t, client)
+}
+
+/*
+Test for case where looking up one owner's installation is slow, an event
+of another installation with a cached token should not wait for it
+*/
+func TestSlowInstallationLookupDoesNotBlock(t *testing.T) {
+	// arrange
+	lookupStarted, releaseLookup := make(chan struct{}), make(chan struct{})
+	appAuth := newMockedAppAuth(t,
+		ghMock.WithRequestMatchHandler(
+			ghMock.GetOrgsInstallationByOrg,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(lookupStarted)
+				<-releaseLookup
+				w.Write(ghMock.MustMarshal(github.Installation{ID: github.Int64(installation_id + 1)}))
+			}),
+		),
+		ghMock.WithRequestMatchHandler(
+			ghMock.PostAppInstallationsAccessTokensByInstallationId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(ghMock.MustMarshal(github.InstallationToken{
+					Token:     github.String("installation-token"),
+					ExpiresAt: &github.Timestamp{Time: time.Now().Add(time.Hour)},
+				}))
+			}),
+		),
+	)
+	_, cacheErr := appAuth.InstallationClient(context.TODO(), installation_id, owner_name)
+
+	lookupErr := make(chan error, 1)
+	go func() {
+		_, err := appAuth.InstallationClient(context.TODO(), 0, "slow-org")
+		lookupErr <- err
+	}()
+	<-lookupStarted
+
+	// act
+	cached := make(chan error, 1)
+	go func() {
+		_, err := appAuth.InstallationClient(context.TODO(), installation_id, owner_name)
+		cached <- err
+	}()
+
+	// assert
+	assert.Nil(t, cacheErr)
+	select {
+	case err := <-cached:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("cached installation client waited for another owner's installation lookup")
+	}
+	close(releaseLookup)
+	assert.Nil(t, <-lookupErr)
+}
+
+/*
+sets the github.com app auth (or the error sourcing it) for the test
+*/
+func useAppAuth(t *testing.T, appAuth *AppAuth, err error) {
+	appOnce = sync.Once{}
+	appOnce.Do(func() {
+		appAuthInstance, appSourcingError = appAuth, err
+	})
+	t.Cleanup(func() {
+		appOnce = sync.Once{}
+		appAuthInstance, appSourcingError = nil, nil
+	})
+}
+
+/*
+creates an app auth whose requests are served by the mocked endpoints
+*/
+func newMockedAppAuth(t *testing.T, options ...ghMock.MockBackendOption) *AppAuth {
+	appAuth, err := NewAppAuth(app_id, encodePKCS1(generatePrivateKey(t)), &Endpoint{HTTPClient: ghMock.NewMockedHTTPClient(options...)})
+	if err != nil {
+		t.Fatalf("unable to create app auth: %s", err)
+	}
+	return appAuth
+}
+
+/*
+mocks the app endpoints, the organization installation is not found while the
+user installation is, tokens created expire after tokenLifetime
+*/
+func getMockedAppAuth(t *testing.T, tokensCreated *int, tokenLifetime time.Duration) *AppAuth {
+	return newMockedAppAuth(t,
+		ghMock.WithRequestMatchHandler(
+			ghMock.GetOrgsInstallationByOrg,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write(ghMock.MustMarshal(github.ErrorResponse{Message: "Not Found"}))
+			}),
+		),
+		ghMock.WithRequestMatch(
+			ghMock.GetUsersInstallationByUsername,
+			github.Installation{ID: github.Int64(installation_id)},
+		),
+		ghMock.WithRequestMatchHandler(
+			ghMock.PostAppInstallationsAccessTokensByInstallationId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				*tokensCreated++
+				w.Write(ghMock.MustMarshal(github.InstallationToken{
+					Token:     github.String("installation-token"),
+					ExpiresAt: &github.Timestamp{Time: time.Now().Add(tokenLifetime)},
+				}))
+			}),
+		),
+	)
+}
+
+func generatePrivateKey(t *testing.T) *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate private key: %s", err)
+	}
+	return privateKey
+}
+
+func encodePKCS1(privateKey *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+}
@@ -2,7 +2,7 @@ package github
 
 import (
 	"context"
-	"sync"
+	"errors"
 	"webhook/logger"
 	"webhook/secrets"
 	"webhook/util"
@@ -12,14 +12,9 @@ import (
 )
 
 var (
-	githubClientInstance *github.Client
-
-	once sync.Once
+	githubClientInstance util.Lazy[*github.Client]
 
 	logInstance *zap.SugaredLogger
-
-	githubPAT     string
-	sourcingError error
 )
 
 const (
@@ -33,19 +28,20 @@ func init() {
 
 func GetGitHubClient(ctx context.Context) (*github.Client, error) {
 
-	// see if we already got a sourcing error
-	if sourcingError != nil {
-		logInstance.Errorln("cannot source github PAT, cannot create new Github client instance", zap.Error(sourcingError))
-		return nil, sourcingError
-	}
-
 	// only source PAT and setup instance once
-	once.Do(func() {
-		sourcePATSecret(ctx)
-		githubClientInstance = github.NewClient(nil).WithAuthToken(githubPAT)
+	client, err := githubClientInstance.Get(func() (*github.Client, error) {
+		githubPAT, err := sourcePATSecret(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return github.NewClient(newRetryingHTTPClient(nil)).WithAuthToken(githubPAT), nil
 	})
+	if err != nil {
+		logInstance.Errorln("cannot source github PAT, cannot create new Github client instance", zap.Error(err))
+		return nil, err
+	}
 
-	return githubClientInstance, nil
+	return client, nil
 }
 
 /*
@@ -66,17 +62,18 @@ func getWebhookPAT(ctx context.Context) (*string, error) {
 }
 
 /*
-Sources Github PAT secret and sets secretPAT it if sourced.
-Returns error and leaves var secretPAT unset if not sourced successfully.
+Sources Github PAT secret and returns it if sourced.
+Returns an error if the secret could not be sourced or has no value.
 */
-func sourcePATSecret(ctx context.Context) {
+func sourcePATSecret(ctx context.Context) (string, error) {
 
 	secretPAT, err := getWebhookPAT(ctx)
-
 	if err != nil {
-		sourcingError = err
-		return
+		return "", err
+	}
+	if secretPAT == nil {
+		return "", errors.New("github PAT secret has no value")
 	}
 
-	githubPAT = *secretPAT
+	return *secretPAT, nil
 }
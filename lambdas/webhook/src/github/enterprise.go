@@ -0,0 +1,217 @@
+package github
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"webhook/secrets"
+	"webhook/util"
+
+	"github.com/google/go-github/v66/github"
+	"go.uber.org/zap"
+)
+
+const (
+	// GitHub Enterprise Server sends this header with every webhook delivery
+	ENTERPRISE_HOST_HEADER    = "X-GitHub-Enterprise-Host"
+	ENTERPRISE_VERSION_HEADER = "X-GitHub-Enterprise-Version"
+
+	// the GitHub Enterprise Server host (ex. github.example.com) events may come from,
+	// the base and upload URLs default to the host's /api/v3/ and /api/uploads/
+	GITHUB_ENTERPRISE_HOST_ENV_VAR_KEY       = "GITHUB_ENTERPRISE_HOST"
+	GITHUB_ENTERPRISE_HOST_DEFAULT           = ""
+	GITHUB_ENTERPRISE_BASE_URL_ENV_VAR_KEY   = "GITHUB_ENTERPRISE_BASE_URL"
+	GITHUB_ENTERPRISE_UPLOAD_URL_ENV_VAR_KEY = "GITHUB_ENTERPRISE_UPLOAD_URL"
+
+	// path of a PEM bundle of the CAs that signed the GitHub Enterprise Server
+	// certificate, added to the system CAs
+	GITHUB_ENTERPRISE_CA_BUNDLE_PATH_ENV_VAR_KEY = "GITHUB_ENTERPRISE_CA_BUNDLE_PATH"
+	GITHUB_ENTERPRISE_CA_BUNDLE_PATH_DEFAULT     = ""
+
+	GITHUB_ENTERPRISE_PAT_SECRET_NAME_ENV_VAR_KEY = "GITHUB_ENTERPRISE_PAT_SECRET_NAME"
+	GITHUB_ENTERPRISE_PAT_SECRET_NAME_DEFAULT     = "GITHUB_ENTERPRISE_PAT_SECRET"
+	GITHUB_ENTERPRISE_APP_SECRET_NAME_ENV_VAR_KEY = "GITHUB_ENTERPRISE_APP_SECRET_NAME"
+	GITHUB_ENTERPRISE_APP_SECRET_NAME_DEFAULT     = ""
+)
+
+var (
+	dotcomEndpoint = &Endpoint{}
+
+	enterpriseEndpointInstance *Endpoint
+	enterpriseOnce             sync.Once
+	enterpriseSourcingError    error
+
+	enterprisePATClientInstance *github.Client
+	enterprisePATOnce           sync.Once
+	enterprisePATSourcingError  error
+
+	enterpriseAppAuthInstance  *AppAuth
+	enterpriseAppOnce          sync.Once
+	enterpriseAppSourcingError error
+)
+
+type enterpriseHostKey struct{}
+
+/*
+Endpoint is the GitHub API a client talks to, github.com when BaseURL
+is empty or a GitHub Enterprise Server instance otherwise
+*/
+type Endpoint struct {
+	Host       string
+	BaseURL    string
+	UploadURL  string
+	HTTPClient *http.Client
+}
+
+/*
+returns a client of the endpoint authenticated with the token, its requests follow the retry policy
+*/
+func (e *Endpoint) NewClient(token string) (*github.Client, error) {
+	client := github.NewClient(newRetryingHTTPClient(e.HTTPClient)).WithAuthToken(token)
+	if e.BaseURL == "" {
+		return client, nil
+	}
+	return client.WithEnterpriseURLs(e.BaseURL, e.UploadURL)
+}
+
+/*
+returns true if the endpoint is a GitHub Enterprise Server instance
+*/
+func (e *Endpoint) IsEnterprise() bool {
+	return e.BaseURL != ""
+}
+
+/*
+returns a copy of ctx carrying the GitHub Enterprise Server host an event came from
+*/
+func WithEnterpriseHost(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, enterpriseHostKey{}, host)
+}
+
+/*
+returns the GitHub Enterprise Server host carried by ctx, empty for github.com events
+*/
+func EnterpriseHost(ctx context.Context) string {
+	host, _ := ctx.Value(enterpriseHostKey{}).(string)
+	return host
+}
+
+/*
+returns the endpoint of the host an event came from, github.com for an empty host.
+Events from a host other than the configured GitHub Enterprise Server are refused
+*/
+func ResolveEndpoint(host string) (*Endpoint, error) {
+	if host == "" || strings.EqualFold(host, "github.com") {
+		return dotcomEndpoint, nil
+	}
+
+	endpoint, err := getEnterpriseEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == nil || !strings.EqualFold(endpoint.Host, host) {
+		return nil, fmt.Errorf("github enterprise host %q is not configured", host)
+	}
+	return endpoint, nil
+}
+
+/*
+returns the configured GitHub Enterprise Server endpoint, or nil if none is configured.
+The endpoint (and its CA bundle) is only set up once
+*/
+func getEnterpriseEndpoint() (*Endpoint, error) {
+	enterpriseOnce.Do(func() {
+		host := util.LookupEnv(GITHUB_ENTERPRISE_HOST_ENV_VAR_KEY, GITHUB_ENTERPRISE_HOST_DEFAULT, false)
+		if host == "" {
+			return
+		}
+
+		endpoint := &Endpoint{
+			Host:      host,
+			BaseURL:   util.LookupEnv(GITHUB_ENTERPRISE_BASE_URL_ENV_VAR_KEY, "", false),
+			UploadURL: util.LookupEnv(GITHUB_ENTERPRISE_UPLOAD_URL_ENV_VAR_KEY, "", false),
+		}
+		if endpoint.BaseURL == "" {
+			endpoint.BaseURL = "https://" + host + "/api/v3/"
+		}
+		if endpoint.UploadURL == "" {
+			endpoint.UploadURL = "https://" + host + "/api/uploads/"
+		}
+
+		caBundlePath := util.LookupEnv(GITHUB_ENTERPRISE_CA_BUNDLE_PATH_ENV_VAR_KEY, GITHUB_ENTERPRISE_CA_BUNDLE_PATH_DEFAULT, false)
+		if caBundlePath != "" {
+			httpClient, err := caBundleHTTPClient(caBundlePath)
+			if err != nil {
+				logInstance.Errorln("error observed while loading github enterprise CA bundle", zap.String("path", caBundlePath), zap.Error(err))
+				enterpriseSourcingError = err
+				return
+			}
+			endpoint.HTTPClient = httpClient
+		}
+
+		logInstance.Infoln("configured github enterprise endpoint", zap.String("host", endpoint.Host), zap.String("base_url", endpoint.BaseURL))
+		enterpriseEndpointInstance = endpoint
+	})
+
+	return enterpriseEndpointInstance, enterpriseSourcingError
+}
+
+/*
+returns an http client trusting the system CAs and the CAs of the PEM bundle
+*/
+func caBundleHTTPClient(path string) (*http.Client, error) {
+	caBundle, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return nil, errors.New("CA bundle has no PEM encoded certificates")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &http.Client{Transport: transport}, nil
+}
+
+/*
+returns the PAT client of the GitHub Enterprise Server endpoint, the
+PAT is sourced from GITHUB_ENTERPRISE_PAT_SECRET_NAME_ENV_VAR_KEY once
+*/
+func getEnterprisePATClient(ctx context.Context, endpoint *Endpoint) (*github.Client, error) {
+	enterprisePATOnce.Do(func() {
+		patSecretName := util.LookupEnv(GITHUB_ENTERPRISE_PAT_SECRET_NAME_ENV_VAR_KEY, GITHUB_ENTERPRISE_PAT_SECRET_NAME_DEFAULT, false)
+		pat, err := secrets.GetSecretValue(ctx, patSecretName)
+		if pat == nil || err != nil {
+			logInstance.Errorln("error while getting github enterprise PAT secret value", zap.Error(err))
+			enterprisePATSourcingError = errors.Join(errors.New("unable to get github enterprise PAT secret value"), err)
+			return
+		}
+
+		enterprisePATClientInstance, enterprisePATSourcingError = endpoint.NewClient(*pat)
+	})
+
+	return enterprisePATClientInstance, enterprisePATSourcingError
+}
+
+/*
+returns the app auth of the GitHub Enterprise Server endpoint, or nil if no app is configured
+*/
+func getEnterpriseAppAuth(ctx context.Context, endpoint *Endpoint) (*AppAuth, error) {
+	enterpriseAppOnce.Do(func() {
+		appSecretName := util.LookupEnv(GITHUB_ENTERPRISE_APP_SECRET_NAME_ENV_VAR_KEY, GITHUB_ENTERPRISE_APP_SECRET_NAME_DEFAULT, false)
+		enterpriseAppAuthInstance, enterpriseAppSourcingError = sourceAppAuth(ctx, appSecretName, endpoint)
+	})
+
+	return enterpriseAppAuthInstance, enterpriseAppSourcingError
+}
@@ -0,0 +1,107 @@
+package github
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	enterprise_host = "github.example.com"
+)
+
+/*
+Test for case where the endpoint is a GitHub Enterprise Server,
+the client should use the endpoint's base and upload URLs
+*/
+func TestEnterpriseEndpointClient(t *testing.T) {
+	// arrange
+	endpoint := &Endpoint{
+		Host:      enterprise_host,
+		BaseURL:   "https://github.example.com/api/v3/",
+		UploadURL: "https://github.example.com/api/uploads/",
+	}
+
+	// act
+	client, err := endpoint.NewClient("token")
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, endpoint.IsEnterprise())
+	assert.Equal(t, "https://github.example.com/api/v3/", client.BaseURL.String())
+	assert.Equal(t, "https://github.example.com/api/uploads/", client.UploadURL.String())
+}
+
+/*
+Test for case where the event has no enterprise host or
+is from github.com, the github.com endpoint should be used
+*/
+func TestResolveDotcomEndpoint(t *testing.T) {
+	// act
+	endpoint, err := ResolveEndpoint(EnterpriseHost(context.TODO()))
+	dotcom, dotcomErr := ResolveEndpoint("github.com")
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, dotcomErr)
+	assert.False(t, endpoint.IsEnterprise())
+	assert.False(t, dotcom.IsEnterprise())
+}
+
+/*
+Test for case where the event is from the configured GitHub Enterprise
+Server host, the enterprise endpoint should be used while other hosts are refused
+*/
+func TestResolveEnterpriseEndpoint(t *testing.T) {
+	// arrange
+	resetEnterpriseEndpoint(t)
+	t.Setenv(GITHUB_ENTERPRISE_HOST_ENV_VAR_KEY, enterprise_host)
+	ctx := WithEnterpriseHost(context.TODO(), "GitHub.Example.com")
+
+	// act
+	endpoint, err := ResolveEndpoint(EnterpriseHost(ctx))
+	_, unknownErr := ResolveEndpoint("github.unknown.example.com")
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, endpoint.IsEnterprise())
+	assert.Equal(t, "https://github.example.com/api/v3/", endpoint.BaseURL)
+	assert.Equal(t, "https://github.example.com/api/uploads/", endpoint.UploadURL)
+	assert.NotNil(t, unknownErr)
+}
+
+/*
+Test for case where the CA bundle has no certificates, the
+enterprise endpoint should not be used
+*/
+func TestInvalidEnterpriseCABundle(t *testing.T) {
+	// arrange
+	resetEnterpriseEndpoint(t)
+	caBundlePath := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caBundlePath, []byte("not a certificate"), 0o600)
+	t.Setenv(GITHUB_ENTERPRISE_HOST_ENV_VAR_KEY, enterprise_host)
+	t.Setenv(GITHUB_ENTERPRISE_CA_BUNDLE_PATH_ENV_VAR_KEY, caBundlePath)
+
+	// act
+	_, err := ResolveEndpoint(enterprise_host)
+
+	// assert
+	assert.NotNil(t, err)
+}
+
+/*
+resets the enterprise endpoint so it's set up from the test's environment
+*/
+func resetEnterpriseEndpoint(t *testing.T) {
+	reset := func() {
+		enterpriseOnce = sync.Once{}
+		enterpriseEndpointInstance = nil
+		enterpriseSourcingError = nil
+	}
+	reset()
+	t.Cleanup(reset)
+}
@@ -0,0 +1,278 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"webhook/util"
+
+	"go.uber.org/zap"
+)
+
+var (
+	retryPolicyInstance *RetryPolicy
+	retryPolicyOnce     sync.Once
+)
+
+const (
+	// attempts made of a GitHub call, including the first one
+	GITHUB_RETRY_MAX_ATTEMPTS_ENV_VAR_KEY = "GITHUB_RETRY_MAX_ATTEMPTS"
+	GITHUB_RETRY_MAX_ATTEMPTS_DEFAULT     = "3"
+
+	// delay before the first retry, doubled (with jitter) for every retry after it
+	GITHUB_RETRY_BASE_DELAY_ENV_VAR_KEY = "GITHUB_RETRY_BASE_DELAY"
+	GITHUB_RETRY_BASE_DELAY_DEFAULT     = "1s"
+
+	GITHUB_RETRY_MAX_DELAY_ENV_VAR_KEY = "GITHUB_RETRY_MAX_DELAY"
+	GITHUB_RETRY_MAX_DELAY_DEFAULT     = "20s"
+
+	// a retry is only waited for if it leaves this much time before the lambda's deadline
+	GITHUB_RETRY_DEADLINE_MARGIN_ENV_VAR_KEY = "GITHUB_RETRY_DEADLINE_MARGIN"
+	GITHUB_RETRY_DEADLINE_MARGIN_DEFAULT     = "2s"
+
+	// GitHub asks to wait at least a minute after a secondary
+	// rate limit response that doesn't say how long to wait
+	SECONDARY_RATE_LIMIT_DELAY = time.Minute
+
+	RETRY_AFTER_HEADER          = "Retry-After"
+	RATE_LIMIT_REMAINING_HEADER = "X-RateLimit-Remaining"
+	RATE_LIMIT_RESET_HEADER     = "X-RateLimit-Reset"
+)
+
+/*
+RetryPolicy is the retry policy shared by every GitHub call, retries back off
+exponentially with jitter (or wait as long as a rate limit response asks) and
+are given up on once the attempts run out or the lambda's deadline approaches
+*/
+type RetryPolicy struct {
+	MaxAttempts    int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	DeadlineMargin time.Duration
+
+	now func() time.Time
+}
+
+/*
+Returns the retry policy configured through the GITHUB_RETRY_* environment
+variables, invalid values are logged and replaced by their defaults
+*/
+func GetRetryPolicy() *RetryPolicy {
+	retryPolicyOnce.Do(func() {
+		retryPolicyInstance = &RetryPolicy{
+			MaxAttempts:    retryAttemptsFromEnv(GITHUB_RETRY_MAX_ATTEMPTS_ENV_VAR_KEY, GITHUB_RETRY_MAX_ATTEMPTS_DEFAULT),
+			BaseDelay:      retryDurationFromEnv(GITHUB_RETRY_BASE_DELAY_ENV_VAR_KEY, GITHUB_RETRY_BASE_DELAY_DEFAULT),
+			MaxDelay:       retryDurationFromEnv(GITHUB_RETRY_MAX_DELAY_ENV_VAR_KEY, GITHUB_RETRY_MAX_DELAY_DEFAULT),
+			DeadlineMargin: retryDurationFromEnv(GITHUB_RETRY_DEADLINE_MARGIN_ENV_VAR_KEY, GITHUB_RETRY_DEADLINE_MARGIN_DEFAULT),
+			now:            time.Now,
+		}
+	})
+
+	return retryPolicyInstance
+}
+
+func retryAttemptsFromEnv(key string, defaultValue string) int {
+	attempts, err := strconv.Atoi(util.LookupEnv(key, defaultValue, false))
+	if err != nil || attempts <= 0 {
+		logInstance.Errorln("invalid github retry attempts, using the default", zap.String("env_var", key), zap.Error(err))
+		attempts, _ = strconv.Atoi(defaultValue)
+	}
+	return attempts
+}
+
+func retryDurationFromEnv(key string, defaultValue string) time.Duration {
+	duration, err := time.ParseDuration(util.LookupEnv(key, defaultValue, false))
+	if err != nil || duration < 0 {
+		logInstance.Errorln("invalid github retry duration, using the default", zap.String("env_var", key), zap.Error(err))
+		duration, _ = time.ParseDuration(defaultValue)
+	}
+	return duration
+}
+
+/*
+returns the delay before the retry following the attempt, the base delay doubled
+for every attempt before it (up to the max delay) with the upper half jittered
+*/
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if attempt < 32 && p.BaseDelay<<(attempt-1) < p.MaxDelay {
+		delay = p.BaseDelay << (attempt - 1)
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+/*
+waits for the delay before the retry following the attempt, an error is returned
+instead if the attempts have run out, the retry would land too close to the
+deadline of ctx or ctx is done while waiting
+*/
+func (p *RetryPolicy) Wait(ctx context.Context, attempt int, delay time.Duration) error {
+	if attempt >= p.MaxAttempts {
+		return fmt.Errorf("giving up after %d attempts", attempt)
+	}
+	if deadline, ok := ctx.Deadline(); ok && p.now().Add(delay+p.DeadlineMargin).After(deadline) {
+		return fmt.Errorf("not retrying, a retry in %s would be too close to the deadline", delay)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+/*
+returns the delay before retrying the request and whether it should be retried at all.
+Rate limited requests were not processed by GitHub so they are always retried, server
+errors and network errors only for methods that are safe to repeat
+*/
+func (p *RetryPolicy) retryDelay(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false
+	}
+
+	if err != nil {
+		if req.Context().Err() != nil {
+			return 0, false
+		}
+		return p.Backoff(attempt), idempotent(req.Method)
+	}
+
+	if delay, limited := p.rateLimitDelay(resp); limited {
+		return delay, true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return p.Backoff(attempt), idempotent(req.Method)
+	}
+	return 0, false
+}
+
+/*
+returns how long a rate limited response asks to wait, a primary rate limit
+says when it resets while a secondary rate limit may say how long to wait
+*/
+func (p *RetryPolicy) rateLimitDelay(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if retryAfter := resp.Header.Get(RETRY_AFTER_HEADER); retryAfter != "" {
+		if seconds, err := strconv.ParseInt(retryAfter, 10, 64); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	if resp.Header.Get(RATE_LIMIT_REMAINING_HEADER) == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get(RATE_LIMIT_RESET_HEADER), 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(p.now()), 0), true
+		}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || secondaryRateLimited(resp) {
+		return SECONDARY_RATE_LIMIT_DELAY, true
+	}
+	return 0, false
+}
+
+/*
+returns true if the body of a 403 response is a secondary rate limit, the body is kept readable
+*/
+func secondaryRateLimited(resp *http.Response) bool {
+	if resp.Body == nil {
+		return false
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return err == nil && strings.Contains(strings.ToLower(string(body)), "secondary rate limit")
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+/*
+retryTransport retries the requests of a GitHub client following the retry policy
+*/
+type retryTransport struct {
+	base   http.RoundTripper
+	policy *RetryPolicy
+}
+
+/*
+returns a copy of the http client (or of the default client when nil) whose requests are retried
+*/
+func newRetryingHTTPClient(httpClient *http.Client) *http.Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	retrying := *httpClient
+	retrying.Transport = &retryTransport{base: base, policy: GetRetryPolicy()}
+	return &retrying
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	funcLogger := logInstance.With(zap.String("method", req.Method), zap.String("path", req.URL.Path))
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+
+		delay, retry := t.policy.retryDelay(req, resp, err, attempt)
+		if !retry {
+			return resp, err
+		}
+
+		attemptLogger := funcLogger.With(zap.Int("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+		if resp != nil {
+			attemptLogger = attemptLogger.With(zap.Int("status_code", resp.StatusCode))
+		}
+
+		if waitErr := t.policy.Wait(ctx, attempt, delay); waitErr != nil {
+			attemptLogger.Warnln("not retrying github request", zap.NamedError("reason", waitErr))
+			return resp, err
+		}
+		attemptLogger.Warnln("retrying github request")
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, errors.Join(errors.New("unable to replay github request body"), bodyErr)
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package github
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Test for case where a GET fails with a server error before
+succeeding, the request should be retried until it succeeds
+*/
+func TestRetryServerError(t *testing.T) {
+	// arrange
+	transport := &stubTransport{statusCodes: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}}
+	client := retryingClient(transport, 3)
+
+	// act
+	resp, err := client.Get("https://api.github.com/repos/octo-org/octo-repo")
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 3, len(transport.bodies))
+}
+
+/*
+Test for case where a POST fails with a server error, the request
+should not be retried as GitHub may have processed it
+*/
+func TestNoRetryServerErrorPost(t *testing.T) {
+	// arrange
+	transport := &stubTransport{statusCodes: []int{http.StatusBadGateway, http.StatusOK}}
+	client := retryingClient(transport, 3)
+
+	// act
+	resp, err := client.Post("https://api.github.com/repos/octo-org/octo-repo/actions/runs/1/pending_deployments", "application/json", strings.NewReader(`{"state":"approved"}`))
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, 1, len(transport.bodies))
+}
+
+/*
+Test for case where a POST is secondary rate limited, the request
+should be retried after the Retry-After delay with its body replayed
+*/
+func TestRetryRateLimitedPost(t *testing.T) {
+	// arrange
+	transport := &stubTransport{
+		statusCodes: []int{http.StatusForbidden, http.StatusOK},
+		headers:     []http.Header{{RETRY_AFTER_HEADER: []string{"0"}}},
+	}
+	client := retryingClient(transport, 3)
+
+	// act
+	resp, err := client.Post("https://api.github.com/repos/octo-org/octo-repo/actions/runs/1/pending_deployments", "application/json", strings.NewReader(`{"state":"approved"}`))
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{`{"state":"approved"}`, `{"state":"approved"}`}, transport.bodies)
+}
+
+/*
+Test for case where the primary rate limit is exhausted,
+the delay should last until the rate limit resets
+*/
+func TestPrimaryRateLimitDelay(t *testing.T) {
+	// arrange
+	now := time.Unix(1700000000, 0)
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Second, now: func() time.Time { return now }}
+	resp := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+	resp.Header.Set(RATE_LIMIT_REMAINING_HEADER, "0")
+	resp.Header.Set(RATE_LIMIT_RESET_HEADER, strconv.FormatInt(now.Add(42*time.Second).Unix(), 10))
+
+	// act
+	delay, limited := policy.rateLimitDelay(resp)
+
+	// assert
+	assert.True(t, limited)
+	assert.Equal(t, 42*time.Second, delay)
+}
+
+/*
+Test for case where the retry would land too close to the lambda's
+deadline, the failed response should be returned without waiting
+*/
+func TestNoRetryNearDeadline(t *testing.T) {
+	// arrange
+	transport := &stubTransport{statusCodes: []int{http.StatusBadGateway, http.StatusOK}}
+	client := retryingClient(transport, 3)
+	client.Transport.(*retryTransport).policy.BaseDelay = time.Minute
+	client.Transport.(*retryTransport).policy.MaxDelay = time.Minute
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.github.com/repos/octo-org/octo-repo", nil)
+
+	// act
+	start := time.Now()
+	resp, err := client.Do(req)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, 1, len(transport.bodies))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+/*
+Test for case where retries back off, every delay should be
+within the jittered upper half of the doubled delay, up to the max delay
+*/
+func TestBackoff(t *testing.T) {
+	// arrange
+	policy := &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	// act & assert
+	for attempt, upper := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 40: 5 * time.Second} {
+		delay := policy.Backoff(attempt)
+		assert.GreaterOrEqual(t, delay, upper/2)
+		assert.LessOrEqual(t, delay, upper)
+	}
+}
+
+/*
+returns a client retrying through the stub transport with delays short enough for tests
+*/
+func retryingClient(transport *stubTransport, maxAttempts int) *http.Client {
+	return &http.Client{Transport: &retryTransport{
+		base:   transport,
+		policy: &RetryPolicy{MaxAttempts: maxAttempts, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, now: time.Now},
+	}}
+}
+
+/*
+stubTransport answers each request with the next status code (and
+headers), recording the body of every request it receives
+*/
+type stubTransport struct {
+	statusCodes []int
+	headers     []http.Header
+	bodies      []string
+}
+
+func (s *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempt := len(s.bodies)
+
+	body := ""
+	if req.Body != nil {
+		content, _ := io.ReadAll(req.Body)
+		body = string(content)
+	}
+	s.bodies = append(s.bodies, body)
+
+	header := http.Header{}
+	if attempt < len(s.headers) {
+		header = s.headers[attempt]
+	}
+	return &http.Response{
+		StatusCode: s.statusCodes[attempt],
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
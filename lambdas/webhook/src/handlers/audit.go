@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"time"
+	"webhook/access"
+	"webhook/audit"
+
+	"go.uber.org/zap"
+)
+
+const (
+	WORKFLOW_RUN_AUDIT_SOURCE               = "workflow_run"
+	DEPLOYMENT_PROTECTION_RULE_AUDIT_SOURCE = "deployment_protection_rule"
+	RECONCILER_AUDIT_SOURCE                 = "reconciler"
+	ISSUE_COMMENT_AUDIT_SOURCE              = "issue_comment"
+)
+
+/*
+records the decision made for the environment on the evaluation, the records
+are written to the audit log together once the event has been evaluated
+*/
+func (eval *evaluation) recordDecision(environment string, decision accessDecision, outcome string, reason string, statusCode int, reviewErr error) {
+	record := audit.Record{
+		Key:          access.GrantKey(access.ScopedRepository(eval.owner, eval.repository), environment),
+		RecordedAt:   time.Now().UTC().Format(audit.RECORDED_AT_LAYOUT),
+		Source:       eval.source,
+		Requester:    eval.requester,
+		Owner:        eval.owner,
+		Repository:   eval.repository,
+		Environment:  environment,
+		RunID:        eval.runID,
+		Approver:     eval.approver,
+		GrantKey:     decision.key,
+		GrantSubject: decision.subject,
+		Decision:     outcome,
+		Reason:       reason,
+		DryRun:       eval.dryRun,
+		StatusCode:   statusCode,
+		TraceID:      eval.traceID,
+	}
+	if reviewErr != nil {
+		record.Error = reviewErr.Error()
+	}
+
+	eval.records = append(eval.records, record)
+}
+
+/*
+writes the evaluation's records to the audit log. GitHub has already been answered by
+then, so a failed write is logged rather than failing (and redelivering) the event
+*/
+func (eval *evaluation) writeAudit(ctx context.Context) {
+	if eval.auditor == nil || len(eval.records) == 0 {
+		return
+	}
+
+	deliveryID := audit.DeliveryID(ctx)
+	for i := range eval.records {
+		eval.records[i].DeliveryID = deliveryID
+	}
+
+	if err := eval.auditor.Write(ctx, eval.records); err != nil {
+		eval.logger.Errorln("error observed while writing audit records", zap.Int("record_count", len(eval.records)), zap.Error(err))
+		return
+	}
+	eval.records = nil
+}
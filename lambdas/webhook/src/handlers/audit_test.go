@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+	"webhook/access"
+	"webhook/audit"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	audit_delivery_id = "72d3162e-cc78-11e3-81ab-4c9367dc0958"
+)
+
+/*
+Test for case where the requester has access, the approval should be
+audited with the grant it was made on and GitHub's response status
+*/
+func TestAuditApproval(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, repo_name, env_name)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleWorkflowRunEvent(audit.WithDeliveryID(context.TODO(), audit_delivery_id), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	records := auditLog.Records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, owner_name+"/"+repo_name+"#"+env_name, records[0].Key)
+	assert.Equal(t, audit_delivery_id, records[0].DeliveryID)
+	assert.Equal(t, WORKFLOW_RUN_AUDIT_SOURCE, records[0].Source)
+	assert.Equal(t, requester_name, records[0].Requester)
+	assert.Equal(t, run_id, records[0].RunID)
+	assert.Equal(t, access.GrantKey(repo_name, env_name), records[0].GrantKey)
+	assert.Equal(t, audit.APPROVED_DECISION, records[0].Decision)
+	assert.Equal(t, http.StatusOK, records[0].StatusCode)
+	assert.False(t, records[0].DryRun)
+}
+
+/*
+Test for case where the requester has no access, the deployment being
+left pending should be audited with the reason and without a status
+*/
+func TestAuditNoAccess(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = access.NewMemoryStore()
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	records := auditLog.Records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, audit.PENDING_DECISION, records[0].Decision)
+	assert.Contains(t, records[0].Reason, requester_name)
+	assert.Empty(t, records[0].GrantKey)
+	assert.Zero(t, records[0].StatusCode)
+}
+
+/*
+Test for case where the reconciler does a dry run, the approval
+should be audited as a dry run without GitHub having been called
+*/
+func TestAuditReconcilerDryRun(t *testing.T) {
+	// arrange
+	reviewed := 0
+	ghClient = getMockedReconcileGhClient(requester_name, &reviewed)
+	accessStore = storeWithGrant(requester_name, repo_name, env_name)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := ReconcileWaitingRuns(context.TODO(), true, []string{owner_name + "/" + repo_name}, true)
+
+	// assert
+	assert.Nil(t, err)
+	records := auditLog.Records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, RECONCILER_AUDIT_SOURCE, records[0].Source)
+	assert.Equal(t, audit.APPROVED_DECISION, records[0].Decision)
+	assert.True(t, records[0].DryRun)
+	assert.Zero(t, records[0].StatusCode)
+}
+
+/*
+Test for case where records are recorded on whole seconds and between them,
+sorting their RecordedAt lexically (as the audit table does) should keep the
+order they were recorded in
+*/
+func TestAuditRecordedAtOrder(t *testing.T) {
+	// arrange
+	recorded := time.Date(2024, 11, 5, 14, 3, 7, 0, time.UTC)
+	times := []time.Time{
+		recorded,
+		recorded.Add(100 * time.Millisecond),
+		recorded.Add(500 * time.Millisecond),
+		recorded.Add(500*time.Millisecond + time.Nanosecond),
+		recorded.Add(time.Second),
+	}
+	eval := newEvaluation(requester_name, owner_name, repo_name, run_id)
+	eval.recordDecision(env_name, accessDecision{}, audit.APPROVED_DECISION, "", 0, nil)
+
+	// act
+	var recordedAts []string
+	for _, recordedAt := range times {
+		recordedAts = append(recordedAts, recordedAt.UTC().Format(audit.RECORDED_AT_LAYOUT))
+	}
+	sorted := slices.Clone(recordedAts)
+	slices.Sort(sorted)
+	_, parseErr := time.Parse(audit.RECORDED_AT_LAYOUT, eval.records[0].RecordedAt)
+
+	// assert
+	assert.Equal(t, recordedAts, sorted, "lexical order should match time order")
+	assert.Len(t, slices.Compact(slices.Clone(recordedAts)), len(times))
+	for _, recordedAt := range recordedAts {
+		assert.Len(t, recordedAt, len(recordedAts[0]), "RecordedAt should be fixed width")
+	}
+	assert.Nil(t, parseErr, "recorded decision should use the RecordedAt layout")
+	assert.Len(t, eval.records[0].RecordedAt, len(recordedAts[0]))
+}
+
+/*
+sets the shared auditor to an in-memory auditor for the test
+*/
+func useMemoryAuditor(t *testing.T) *audit.MemoryAuditor {
+	auditLog := audit.NewMemoryAuditor()
+	auditor = auditLog
+	t.Cleanup(func() { auditor = nil })
+	return auditLog
+}
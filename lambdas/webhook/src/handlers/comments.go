@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"webhook/util"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// text/template comments posted with approvals and rejections, see commentData for the fields
+	APPROVED_COMMENT_TEMPLATE_ENV_VAR_KEY = "APPROVED_COMMENT_TEMPLATE"
+	APPROVED_COMMENT_TEMPLATE_DEFAULT     = "Approved via Go GitHub Webhook Lambda! 🚀 {{.Requester}} has {{.Level}} access to {{.Environment}} through the {{.Grant}} grant.{{if .Approvers}} Approved by {{.Approvers}}.{{end}}"
+	REJECTED_COMMENT_TEMPLATE_ENV_VAR_KEY = "REJECTED_COMMENT_TEMPLATE"
+	REJECTED_COMMENT_TEMPLATE_DEFAULT     = "Rejected via Go GitHub Webhook Lambda, {{.Reason}}."
+)
+
+var (
+	approvedCommentTemplate string
+	rejectedCommentTemplate string
+)
+
+func init() {
+	approvedCommentTemplate = util.LookupEnv(APPROVED_COMMENT_TEMPLATE_ENV_VAR_KEY, APPROVED_COMMENT_TEMPLATE_DEFAULT, false)
+	rejectedCommentTemplate = util.LookupEnv(REJECTED_COMMENT_TEMPLATE_ENV_VAR_KEY, REJECTED_COMMENT_TEMPLATE_DEFAULT, false)
+}
+
+/*
+*
+the fields available to comment templates, ex. {{.Requester}}.
+Level and Grant are those of the grant that decided access (if any),
+Reason explains a rejection and Approvers are the other grant holders
+that approved a deployment needing their approval (comma separated)
+*/
+type commentData struct {
+	Requester   string
+	Owner       string
+	Repository  string
+	Environment string
+	Level       string
+	Grant       string
+	Reason      string
+	Approvers   string
+	RunID       int64
+	TraceID     string
+}
+
+/*
+*
+renders the comment an approval is posted with, the environment's policy
+template is used over the configured template if it has one. approvers are
+the grant holders whose approval was required, if any
+*/
+func approvalComment(eval *evaluation, environment string, decision accessDecision, policy policyDecision, approvers []string) string {
+	data := newCommentData(eval, environment, decision)
+	data.Approvers = strings.Join(approvers, ", ")
+	return renderComment(eval, data, policy.approvedComment, approvedCommentTemplate, APPROVED_COMMENT_TEMPLATE_DEFAULT)
+}
+
+/*
+*
+renders the comment an approval made by a grant holder's /approve comment is posted
+with, decision is the grant holder's access so they are named as the requester
+*/
+func commandApprovalComment(eval *evaluation, environment string, decision accessDecision, policy policyDecision) string {
+	data := newCommentData(eval, environment, decision)
+	data.Requester = eval.approver
+	return renderComment(eval, data, policy.approvedComment, approvedCommentTemplate, APPROVED_COMMENT_TEMPLATE_DEFAULT)
+}
+
+/*
+*
+renders the comment a rejection is posted with, the environment's policy
+template is used over the configured template if it has one
+*/
+func rejectionComment(eval *evaluation, environment string, decision accessDecision, policy policyDecision, reason string) string {
+	data := newCommentData(eval, environment, decision)
+	data.Reason = reason
+	return renderComment(eval, data, policy.rejectedComment, rejectedCommentTemplate, REJECTED_COMMENT_TEMPLATE_DEFAULT)
+}
+
+/*
+*
+explains why a requester has no access to an environment, naming the
+grant that was missing or the deny grant that applied
+*/
+func noAccessReason(requester string, decision accessDecision) string {
+	if decision.denied {
+		return fmt.Sprintf("%s is denied access to this environment by the %s grant %s", requester, decision.level, decision.key)
+	}
+	return fmt.Sprintf("%s does not have access to this environment (%s)", requester, decision.reason)
+}
+
+func newCommentData(eval *evaluation, environment string, decision accessDecision) commentData {
+	return commentData{
+		Requester:   eval.requester,
+		Owner:       eval.owner,
+		Repository:  eval.repository,
+		Environment: environment,
+		Level:       decision.level,
+		Grant:       decision.key,
+		RunID:       eval.runID,
+		TraceID:     eval.traceID,
+	}
+}
+
+/*
+*
+renders the first of the templates that is set and renders without error,
+a broken template is logged and the next one is tried so a typo in a
+template never stops a review from being posted
+*/
+func renderComment(eval *evaluation, data commentData, templates ...string) string {
+	for _, text := range templates {
+		if text == "" {
+			continue
+		}
+
+		commentTemplate, err := template.New("comment").Parse(text)
+		if err != nil {
+			eval.logger.Warnln("error observed while parsing comment template, falling back", zap.String("template", text), zap.Error(err))
+			continue
+		}
+
+		var comment strings.Builder
+		if err := commentTemplate.Execute(&comment, data); err != nil {
+			eval.logger.Warnln("error observed while rendering comment template, falling back", zap.String("template", text), zap.Error(err))
+			continue
+		}
+		return comment.String()
+	}
+
+	return ""
+}
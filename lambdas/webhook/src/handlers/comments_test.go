@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"webhook/access"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Test for case where user has access, the default comment
+should record the grant the approval happened through
+*/
+func TestDefaultApprovedComment(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	accessStore = storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), access.WILDCARD)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, "Approved via Go GitHub Webhook Lambda! 🚀 github-requester has repo access to test-env through the github-owner/test-repo#* grant.", review.Comment)
+}
+
+/*
+Test for case where the environment's policy has its own approved comment
+*/
+func TestPolicyApprovedComment(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	store := storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	store.PutPolicy(access.Policy{
+		Key:             access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name),
+		ApprovedComment: "{{.Requester}} approved for {{.Owner}}/{{.Repository}} {{.Environment}} run {{.RunID}} ({{.Level}})",
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, fmt.Sprintf("%s approved for %s/%s %s run %d (exact)", requester_name, owner_name, repo_name, env_name, run_id), review.Comment)
+}
+
+/*
+Test for case where the environment's policy has a broken
+rejected comment, the configured comment should be used instead
+*/
+func TestBrokenCommentTemplateFallsBack(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	store := access.NewMemoryStore()
+	store.PutPolicy(access.Policy{
+		Key:             access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name),
+		OnNoAccess:      access.ACTION_REJECT,
+		RejectedComment: "{{.Approver}} rejected",
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Contains(t, review.Comment, "Rejected via Go GitHub Webhook Lambda")
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/google/go-github/v66/github"
+	"go.uber.org/zap"
+)
+
+const (
+	PROTECTION_RULE_APPROVED_STATE = "approved"
+	PROTECTION_RULE_REJECTED_STATE = "rejected"
+)
+
+/*
+Handles deployment_protection_rule events sent to the lambda when it is
+configured as a custom deployment protection rule on an environment.
+The requester is checked against the access table the same way workflow run
+events are, and GitHub is answered through the deployment callback URL with
+either an approval or a rejection, a protection rule is never left pending.
+*/
+func HandleDeploymentProtectionRuleEvent(ctx context.Context, mocking bool, event *github.DeploymentProtectionRuleEvent) error {
+	funcLogger := logInstance.With()
+
+	// if not mocking, set up clients. when mocking clients will be stubbed clients
+	if !mocking {
+		clientSetupErr := setupClients(ctx)
+		if clientSetupErr != nil {
+			funcLogger.Errorln("error while setting up clients")
+			return clientSetupErr
+		}
+	}
+
+	_, subSegment := xray.BeginSubsegment(ctx, "HandleDeploymentProtectionRuleEvent")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = logInstance.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	// we only handle request events
+	if event.GetAction() != REQUESTED_ACTION {
+		funcLogger.Debug("event was not for a request", zap.String("action", event.GetAction()))
+		return nil
+	}
+
+	var requester, repository, environment, callbackURL string
+	if event.GetSender() != nil && event.GetSender().GetLogin() != "" {
+		requester = event.GetSender().GetLogin()
+	} else {
+		err := fmt.Errorf("sender or sender login from event payload is nil or empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+	if event.GetRepo() != nil && event.GetRepo().GetName() != "" {
+		repository = event.GetRepo().GetName()
+	} else {
+		err := fmt.Errorf("repo or repo name from event payload is nil or empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+	if event.GetEnvironment() != "" {
+		environment = event.GetEnvironment()
+	} else {
+		err := fmt.Errorf("environment from event payload is empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+	if event.GetDeploymentCallbackURL() != "" {
+		callbackURL = event.GetDeploymentCallbackURL()
+	} else {
+		err := fmt.Errorf("deployment callback URL from event payload is empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+
+	var owner string
+	if event.GetRepo().GetOwner() != nil && event.GetRepo().GetOwner().GetLogin() != "" {
+		owner = event.GetRepo().GetOwner().GetLogin()
+	} else {
+		err := fmt.Errorf("repo owner or repo owner login from event payload is nil or empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+
+	// the run ID is not part of the payload, the callback URL is used to answer GitHub instead
+	eval := newEvaluation(requester, owner, repository, 0)
+	eval.source = DEPLOYMENT_PROTECTION_RULE_AUDIT_SOURCE
+	if subSegment != nil {
+		eval.withTraceID(subSegment.TraceID)
+	}
+	funcLogger = eval.logger.With(zap.String("environment", environment))
+
+	if err := eval.useInstallationClient(ctx, mocking, event.GetInstallation().GetID()); err != nil {
+		funcLogger.Errorln("error while getting github client to handle deployment protection rule event", zap.Error(err))
+		return err
+	}
+	funcLogger.Infof("Processing event: %T", event)
+
+	requesterPerms, err := requesterHasPermission(ctx, eval, []string{environment})
+	if err != nil {
+		funcLogger.Errorln("error observed while checking if requester has permission", zap.Error(err))
+		return err
+	}
+
+	policyDecisions, err := deploymentPolicies(ctx, eval, []string{environment})
+	if err != nil {
+		funcLogger.Errorln("error observed while checking deployment policies", zap.Error(err))
+		return err
+	}
+	decision, policy := requesterPerms[environment], policyDecisions[environment]
+
+	review := github.ReviewCustomDeploymentProtectionRuleRequest{
+		EnvironmentName: environment,
+		State:           PROTECTION_RULE_REJECTED_STATE,
+	}
+	// GitHub only sends a protection rule once and the reconciler only sweeps pending
+	// deployments, so a rule left pending would wait out GitHub's timeout. Rules are
+	// always answered, a blocked deployment or one needing a quorum is rejected
+	var reason string
+	switch {
+	case !decision.allowed:
+		funcLogger.Info("requester does not have permission, will attempt to reject deployment protection rule")
+		reason = noAccessReason(requester, decision)
+		review.Comment = rejectionComment(eval, environment, decision, policy, reason)
+	case policy.blocked:
+		funcLogger.Info("deployment is blocked by policy, will attempt to reject deployment protection rule", zap.String("reason", policy.reason))
+		reason = policy.reason
+		review.Comment = rejectionComment(eval, environment, decision, policy, reason)
+	case policy.requiredApprovals > 0:
+		// the approvals of other grant holders are only collected for a run's pending deployments
+		reason = fmt.Sprintf("deployments require the approval of %d other grant holders, which deployment protection rules do not collect", policy.requiredApprovals)
+		funcLogger.Info("deployment requires the approval of other grant holders, will attempt to reject deployment protection rule")
+		review.Comment = rejectionComment(eval, environment, decision, policy, reason)
+	default:
+		funcLogger.Info("requester has permission, will attempt to approve deployment protection rule")
+		reason = decision.reason
+		review.State = PROTECTION_RULE_APPROVED_STATE
+		review.Comment = approvalComment(eval, environment, decision, policy, nil)
+	}
+
+	statusCode, err := reviewDeploymentProtectionRule(ctx, eval, callbackURL, &review)
+	eval.recordDecision(environment, decision, review.State, reason, statusCode, err)
+	eval.writeAudit(ctx)
+	return err
+}
+
+/*
+*
+answers the deployment protection rule by posting the review to the
+callback URL GitHub provided in the event, the status code of GitHub's response is returned
+*/
+func reviewDeploymentProtectionRule(ctx context.Context, eval *evaluation, callbackURL string, review *github.ReviewCustomDeploymentProtectionRuleRequest) (int, error) {
+	funcLogger := eval.logger.With(zap.String("environment", review.EnvironmentName), zap.String("state", review.State))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "reviewDeploymentProtectionRule")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	if eval.dryRun {
+		funcLogger.Infoln("dry run, not reviewing deployment protection rule", zap.String("comment", review.Comment))
+		return 0, nil
+	}
+
+	req, err := eval.ghClient.NewRequest(http.MethodPost, callbackURL, review)
+	if err != nil {
+		funcLogger.Errorln("error observed while building deployment protection rule review request", zap.Error(err))
+		return 0, err
+	}
+
+	reviewResp, reviewErr := eval.ghClient.Do(ctx, req, nil)
+	statusCode := 0
+	if reviewResp != nil {
+		statusCode = reviewResp.StatusCode
+	}
+	if reviewErr != nil {
+		funcLogger.Errorln("error observed while reviewing deployment protection rule", zap.Error(reviewErr))
+		return statusCode, reviewErr
+	}
+	if statusCode != http.StatusNoContent && statusCode != http.StatusOK {
+		errMsg := "incorrect status code observed while reviewing deployment protection rule"
+		funcLogger.Errorln(errMsg, zap.Int("status_code", statusCode))
+		return statusCode, errors.New(errMsg)
+	}
+
+	funcLogger.Infoln("reviewed deployment protection rule")
+
+	return statusCode, nil
+}
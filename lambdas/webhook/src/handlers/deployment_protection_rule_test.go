@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"webhook/access"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+)
+
+/*
+Test for case where requester has access, the
+protection rule should be approved
+*/
+func TestProtectionRuleApproved(t *testing.T) {
+	// arrange
+	accessStore = storeWithGrant(requester_name, repo_name, env_name)
+
+	var review github.ReviewCustomDeploymentProtectionRuleRequest
+	ghClient = getMockedProtectionRuleGhClient(&review)
+	event := createdDeploymentProtectionRuleEvent(repo_name, owner_name, requester_name, env_name, run_id)
+
+	// act
+	err := HandleDeploymentProtectionRuleEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, PROTECTION_RULE_APPROVED_STATE, review.State)
+	assert.Equal(t, env_name, review.EnvironmentName)
+}
+
+/*
+Test for case where requester has no access, the
+protection rule should be rejected
+*/
+func TestProtectionRuleRejected(t *testing.T) {
+	// arrange
+	accessStore = access.NewMemoryStore()
+
+	var review github.ReviewCustomDeploymentProtectionRuleRequest
+	ghClient = getMockedProtectionRuleGhClient(&review)
+	event := createdDeploymentProtectionRuleEvent(repo_name, owner_name, requester_name, env_name, run_id)
+
+	// act
+	err := HandleDeploymentProtectionRuleEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, PROTECTION_RULE_REJECTED_STATE, review.State)
+	assert.Equal(t, env_name, review.EnvironmentName)
+	assert.Contains(t, review.Comment, access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name))
+}
+
+/*
+Test for case where requester has access while dry run
+is enabled, the protection rule should not be reviewed
+*/
+func TestProtectionRuleDryRun(t *testing.T) {
+	// arrange
+	dryRunEnabled = true
+	t.Cleanup(func() { dryRunEnabled = false })
+	accessStore = storeWithGrant(requester_name, repo_name, env_name)
+
+	var review github.ReviewCustomDeploymentProtectionRuleRequest
+	ghClient = getMockedProtectionRuleGhClient(&review)
+	event := createdDeploymentProtectionRuleEvent(repo_name, owner_name, requester_name, env_name, run_id)
+
+	// act
+	err := HandleDeploymentProtectionRuleEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Empty(t, review.State, "protection rule should not have been reviewed during a dry run")
+}
+
+/*
+Test for case where requester has access but the environment requires the
+approval of other grant holders, the protection rule should be rejected
+*/
+func TestProtectionRuleQuorumRejected(t *testing.T) {
+	// arrange
+	accessStore = storeWithQuorumPolicy(1, requester_name)
+
+	var review github.ReviewCustomDeploymentProtectionRuleRequest
+	ghClient = getMockedProtectionRuleGhClient(&review)
+	event := createdDeploymentProtectionRuleEvent(repo_name, owner_name, requester_name, env_name, run_id)
+
+	// act
+	err := HandleDeploymentProtectionRuleEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, PROTECTION_RULE_REJECTED_STATE, review.State)
+	assert.Contains(t, review.Comment, "approval of 1 other grant holders")
+}
+
+/*
+mocks the deployment callback URL, the posted review is decoded into review
+*/
+func getMockedProtectionRuleGhClient(review *github.ReviewCustomDeploymentProtectionRuleRequest) *github.Client {
+	mockedHTTPClient := ghMock.NewMockedHTTPClient(
+		ghMock.WithRequestMatchHandler(
+			ghMock.PostReposActionsRunsDeploymentProtectionRuleByOwnerByRepoByRunId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}),
+		),
+	)
+
+	return github.NewClient(mockedHTTPClient)
+}
+
+func createdDeploymentProtectionRuleEvent(repoName, ownerName, requesterName, envName string, runID int64) *github.DeploymentProtectionRuleEvent {
+	action := REQUESTED_ACTION
+	callbackURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/actions/runs/%d/deployment_protection_rule", ownerName, repoName, runID)
+
+	return &github.DeploymentProtectionRuleEvent{
+		Action:                &action,
+		Environment:           &envName,
+		DeploymentCallbackURL: &callbackURL,
+		Repo:                  &github.Repository{Name: &repoName, Owner: &github.User{Login: &ownerName}},
+		Sender:                &github.User{Login: &requesterName},
+	}
+}
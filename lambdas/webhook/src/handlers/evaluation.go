@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"webhook/access"
+	"webhook/approval"
+	"webhook/audit"
+	gh "webhook/github"
+
+	"github.com/google/go-github/v66/github"
+	"go.uber.org/zap"
+)
+
+/*
+evaluation holds everything known about the single event being evaluated.
+A new evaluation is created for every event and passed through the permission
+check, pending deployment and approval functions, so a warm lambda processing
+several events (even concurrently) never shares requester state or log fields
+between them.
+*/
+type evaluation struct {
+	requester  string
+	owner      string
+	repository string
+	runID      int64
+	traceID    string
+
+	// pending deployments are only logged and audited, not reviewed, during a dry run
+	dryRun bool
+
+	// the grant holder whose /approve or /reject comment is being evaluated, empty for other events
+	approver string
+
+	// what the event came from, the decisions made for it are recorded in the audit log
+	source  string
+	records []audit.Record
+
+	ghClient  *github.Client
+	store     access.AccessStore
+	auditor   audit.Auditor
+	approvals approval.Store
+
+	logger *zap.SugaredLogger
+}
+
+/*
+creates a new evaluation for the requester and repository, the evaluation's
+logger is scoped to the requester and repository of this event only
+*/
+func newEvaluation(requester string, owner string, repository string, runID int64) *evaluation {
+	return &evaluation{
+		requester:  requester,
+		owner:      owner,
+		repository: repository,
+		runID:      runID,
+		dryRun:     dryRunEnabled,
+		source:     WORKFLOW_RUN_AUDIT_SOURCE,
+		ghClient:   getGhClient(),
+		store:      getAccessStore(),
+		auditor:    getAuditor(),
+		approvals:  getApprovalStore(),
+		logger: logInstance.With(
+			zap.String("requester", requester),
+			zap.String("owner", owner),
+			zap.String("repository", repository),
+			zap.Int64("runID", runID),
+		),
+	}
+}
+
+/*
+records the trace ID of the event on the evaluation and its logger
+*/
+func (eval *evaluation) withTraceID(traceID string) {
+	eval.traceID = traceID
+	eval.logger = eval.logger.With(zap.String("traceID", traceID))
+}
+
+/*
+replaces the evaluation's github client with the client of the event's app installation
+(or the PAT client when no app is configured), mocked events keep the stubbed client
+*/
+func (eval *evaluation) useInstallationClient(ctx context.Context, mocking bool, installationID int64) error {
+	if mocking {
+		return nil
+	}
+
+	client, err := gh.GetInstallationClient(ctx, installationID, eval.owner)
+	if err != nil {
+		return err
+	}
+
+	eval.ghClient = client
+	return nil
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"webhook/access"
+	"webhook/audit"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+)
+
+/*
+Test for case where a warm lambda handles the runs of two requesters at the same time,
+only the requester with a grant should be approved and neither run's decision or log
+fields should leak into the other's (run with -race)
+*/
+func TestConcurrentEvaluations(t *testing.T) {
+	// arrange
+	logs := useObservedLogger(t)
+
+	allowedRequester, allowedRunID := "allowed-requester", int64(1001)
+	deniedRequester, deniedRunID := "denied-requester", int64(2002)
+	runRequesters := map[int64]string{allowedRunID: allowedRequester, deniedRunID: deniedRequester}
+
+	reviewed := map[int64]string{}
+	ghClient = getMockedConcurrentGhClient(env_name, reviewed)
+	store := storeWithGrant(allowedRequester, access.ScopedRepository(owner_name, repo_name), env_name)
+	store.Deny(deniedRequester, access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name))
+	accessStore = store
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i, runID := range []int64{allowedRunID, deniedRunID} {
+		wg.Add(1)
+		go func(i int, runID int64) {
+			defer wg.Done()
+			event := createdWorkflowRunEvent(repo_name, owner_name, runRequesters[runID], runID)
+			errs[i] = HandleWorkflowRunEvent(context.TODO(), true, event)
+		}(i, runID)
+	}
+	wg.Wait()
+
+	// assert
+	assert.Nil(t, errs[0])
+	assert.Nil(t, errs[1])
+	assert.Equal(t, map[int64]string{allowedRunID: PENDING_DEPLOYMENT_APPROVED_STATE}, reviewed)
+
+	records := auditLog.Records()
+	assert.Len(t, records, 2)
+	for _, record := range records {
+		assert.Equal(t, runRequesters[record.RunID], record.Requester)
+		if record.RunID == allowedRunID {
+			assert.Equal(t, audit.APPROVED_DECISION, record.Decision)
+		} else {
+			assert.Equal(t, audit.PENDING_DECISION, record.Decision)
+		}
+	}
+
+	scopedLogs := 0
+	for _, entry := range logs.All() {
+		fields := entry.ContextMap()
+		runID, scoped := fields["runID"]
+		if !scoped {
+			continue
+		}
+		scopedLogs++
+		assert.Equal(t, runRequesters[runID.(int64)], fields["requester"], "log %q has another run's requester", entry.Message)
+	}
+	assert.NotZero(t, scopedLogs)
+}
+
+/*
+sets the package logger to one observed by the test
+*/
+func useObservedLogger(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+	previous := logInstance
+	logInstance = zap.New(core).Sugar()
+	t.Cleanup(func() { logInstance = previous })
+	return logs
+}
+
+/*
+mocks the pending deployments endpoints for any run, reviewed maps the
+run ID of every pending deployment review posted to the state posted
+*/
+func getMockedConcurrentGhClient(envName string, reviewed map[int64]string) *github.Client {
+	var mutex sync.Mutex
+
+	deploymentURL := "example.com"
+	approvedDeployments := []*github.Deployment{{
+		URL: &deploymentURL,
+	}}
+
+	// path is /repos/<owner>/<repo>/actions/runs/<run>/pending_deployments
+	pathRunID := func(r *http.Request) int64 {
+		runID, _ := strconv.ParseInt(path.Base(path.Dir(r.URL.Path)), 10, 64)
+		return runID
+	}
+
+	mockedHTTPClient := ghMock.NewMockedHTTPClient(
+		ghMock.WithRequestMatchHandler(
+			ghMock.GetReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				envID := pathRunID(r)
+				w.Write(ghMock.MustMarshal([]*github.PendingDeployment{{
+					Environment: &github.PendingDeploymentEnvironment{ID: &envID, Name: &envName},
+				}}))
+			}),
+		),
+		ghMock.WithRequestMatchHandler(
+			ghMock.PostReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req github.PendingDeploymentsRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				mutex.Lock()
+				reviewed[pathRunID(r)] = req.State
+				mutex.Unlock()
+				w.Write(ghMock.MustMarshal(approvedDeployments))
+			}),
+		),
+	)
+
+	return github.NewClient(mockedHTTPClient)
+}
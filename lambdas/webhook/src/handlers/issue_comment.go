@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"webhook/audit"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/google/go-github/v66/github"
+	"go.uber.org/zap"
+)
+
+const (
+	CREATED_ACTION = "created"
+
+	// approves the deployments of the pull request's waiting runs on behalf of the commenter,
+	// to the environment named after the command or to every pending environment
+	APPROVE_COMMAND = "/approve"
+	// rejects the deployments of the pull request's waiting runs to the environment
+	// named after the command, the rest of the line is the reason for the rejection
+	REJECT_COMMAND = "/reject"
+)
+
+/*
+*
+a command commented on a pull request, environment is empty when the command
+applies to every pending environment and reason explains a rejection
+*/
+type commentCommand struct {
+	name        string
+	environment string
+	reason      string
+}
+
+/*
+Handles issue_comment events, a grant holder comments /approve <env> or /reject <env> <reason>
+on the pull request that triggered a waiting run to approve or reject its deployments. The
+commenter, not the run's requester, is checked against the grants. Deployments to environments
+whose policy requires the approval of grant holders other than the requester are only approved
+once enough of them have commented /approve.
+*/
+func HandleIssueCommentEvent(ctx context.Context, mocking bool, event *github.IssueCommentEvent) error {
+	funcLogger := logInstance.With()
+
+	// if not mocking, set up clients. when mocking clients will be stubbed clients
+	if !mocking {
+		clientSetupErr := setupClients(ctx)
+		if clientSetupErr != nil {
+			funcLogger.Errorln("error while setting up clients")
+			return clientSetupErr
+		}
+	}
+
+	_, subSegment := xray.BeginSubsegment(ctx, "HandleIssueCommentEvent")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = logInstance.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	// we only handle new comments on pull requests
+	if event.GetAction() != CREATED_ACTION {
+		funcLogger.Debug("event was not for a new comment", zap.String("action", event.GetAction()))
+		return nil
+	}
+	if !event.GetIssue().IsPullRequest() {
+		funcLogger.Debug("comment was not on a pull request")
+		return nil
+	}
+	command := parseCommand(event.GetComment().GetBody())
+	if command.name != APPROVE_COMMAND && command.name != REJECT_COMMAND {
+		funcLogger.Debug("comment was not an approval or rejection command", zap.String("command", command.name))
+		return nil
+	}
+	if command.name == REJECT_COMMAND && command.environment == "" {
+		funcLogger.Info("rejection command did not name an environment, ignoring it")
+		return nil
+	}
+
+	var commenter, owner, repository string
+	if event.GetComment().GetUser().GetLogin() != "" {
+		commenter = event.GetComment().GetUser().GetLogin()
+	} else {
+		err := fmt.Errorf("comment user or comment user login from event payload is nil or empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+	if event.GetRepo() != nil && event.GetRepo().GetName() != "" {
+		repository = event.GetRepo().GetName()
+	} else {
+		err := fmt.Errorf("repo or repo name from event payload is nil or empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+	if event.GetRepo().GetOwner() != nil && event.GetRepo().GetOwner().GetLogin() != "" {
+		owner = event.GetRepo().GetOwner().GetLogin()
+	} else {
+		err := fmt.Errorf("repo owner or repo owner login from event payload is nil or empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+	pullNumber := event.GetIssue().GetNumber()
+	if pullNumber == 0 {
+		err := fmt.Errorf("issue number from event payload is empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+	commentedAt := event.GetComment().GetCreatedAt().Time
+	if commentedAt.IsZero() {
+		err := fmt.Errorf("comment created_at from event payload is nil or empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+
+	// the runs of the pull request are only known once its head commit is
+	repoEval := newEvaluation("", owner, repository, 0)
+	repoEval.approver = commenter
+	repoEval.source = ISSUE_COMMENT_AUDIT_SOURCE
+	if subSegment != nil {
+		repoEval.withTraceID(subSegment.TraceID)
+	}
+	funcLogger = repoEval.logger.With(zap.String("approver", commenter), zap.Int("pull_number", pullNumber), zap.String("command", command.name), zap.String("environment", command.environment))
+
+	if err := repoEval.useInstallationClient(ctx, mocking, event.GetInstallation().GetID()); err != nil {
+		funcLogger.Errorln("error while getting github client to handle issue comment event", zap.Error(err))
+		return err
+	}
+	funcLogger.Infof("Processing event: %T", event)
+
+	runs, err := listPullRequestWaitingRuns(ctx, repoEval, pullNumber, commentedAt)
+	if err != nil {
+		funcLogger.Errorln("error observed while listing waiting runs of pull request", zap.Error(err))
+		return err
+	}
+	if len(runs) == 0 {
+		funcLogger.Infoln("pull request has no waiting runs to review")
+		return nil
+	}
+
+	// a run that fails doesn't stop the others from being reviewed
+	var errs []error
+	for _, run := range runs {
+		if err := reviewRunByComment(ctx, repoEval, run, command); err != nil {
+			funcLogger.Errorln("error observed while reviewing waiting run", zap.Int64("runID", run.GetID()), zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+*
+returns the command of a comment, its first line if that starts with a /, followed by the
+environment it applies to and the reason for it. A comment that isn't a command returns
+an empty command name
+*/
+func parseCommand(body string) commentCommand {
+	line, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return commentCommand{}
+	}
+
+	command := commentCommand{name: strings.ToLower(fields[0])}
+	if len(fields) > 1 {
+		command.environment = fields[1]
+	}
+	if len(fields) > 2 {
+		command.reason = strings.Join(fields[2:], " ")
+	}
+	return command
+}
+
+/*
+*
+lists the waiting runs of the pull request's head commit that were created before the comment. The
+head is read when the comment is processed, so runs of commits pushed after the comment was made
+(which the commenter hasn't seen) are left for a comment of their own
+*/
+func listPullRequestWaitingRuns(ctx context.Context, eval *evaluation, pullNumber int, commentedAt time.Time) ([]*github.WorkflowRun, error) {
+	funcLogger := eval.logger.With(zap.Int("pull_number", pullNumber))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "listPullRequestWaitingRuns")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	pullRequest, _, err := eval.ghClient.PullRequests.Get(ctx, eval.owner, eval.repository, pullNumber)
+	if err != nil {
+		funcLogger.Errorln("error observed while getting pull request", zap.Error(err))
+		return nil, err
+	}
+
+	headSHA := pullRequest.GetHead().GetSHA()
+	if headSHA == "" {
+		err := fmt.Errorf("pull request %d has no head commit", pullNumber)
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return nil, err
+	}
+
+	runs, err := listWaitingRuns(ctx, eval, headSHA)
+	if err != nil {
+		funcLogger.Errorln("error observed while listing waiting runs", zap.String("head_sha", headSHA), zap.Error(err))
+		return nil, err
+	}
+
+	var commentedRuns []*github.WorkflowRun
+	for _, run := range runs {
+		if run.GetCreatedAt().Time.After(commentedAt) {
+			funcLogger.Infoln("waiting run was created after the comment, skipping it", zap.Int64("runID", run.GetID()), zap.String("head_sha", headSHA))
+			continue
+		}
+		commentedRuns = append(commentedRuns, run)
+	}
+	return commentedRuns, nil
+}
+
+/*
+*
+approves or rejects the run's pending deployments the command applies to on behalf of
+the commenter, who must have access to each environment. Deployments to environments
+requiring the approval of other grant holders count the commenter's approval instead,
+and are only approved once enough grant holders have approved
+*/
+func reviewRunByComment(ctx context.Context, repoEval *evaluation, run *github.WorkflowRun, command commentCommand) error {
+	commenter := repoEval.approver
+
+	requester := runRequester(run)
+	if requester == "" {
+		err := fmt.Errorf("waiting run %d has no actor", run.GetID())
+		repoEval.logger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+
+	eval := newEvaluation(requester, repoEval.owner, repoEval.repository, run.GetID())
+	eval.ghClient = repoEval.ghClient
+	eval.approver = commenter
+	eval.source = ISSUE_COMMENT_AUDIT_SOURCE
+	if repoEval.traceID != "" {
+		eval.withTraceID(repoEval.traceID)
+	}
+	eval.logger = eval.logger.With(zap.String("approver", commenter), zap.String("command", command.name))
+	funcLogger := eval.logger.With()
+
+	// the decisions made so far are recorded even if reviewing a later deployment fails
+	defer eval.writeAudit(ctx)
+
+	pendingDeployments, err := getPendingDeployments(ctx, eval)
+	if err != nil {
+		funcLogger.Errorln("error while fetching pending deployments of waiting run")
+		return err
+	}
+
+	// the command applies to every pending deployment unless it names an environment
+	var commandDeployments []*github.PendingDeployment
+	var environments []string
+	for _, pendingDeployment := range pendingDeployments {
+		environment := pendingDeployment.GetEnvironment().GetName()
+		if environment == "" || (command.environment != "" && !strings.EqualFold(environment, command.environment)) {
+			continue
+		}
+		commandDeployments = append(commandDeployments, pendingDeployment)
+		environments = append(environments, environment)
+	}
+	if len(commandDeployments) == 0 {
+		funcLogger.Infoln("waiting run has no pending deployments the command applies to", zap.String("environment", command.environment))
+		return nil
+	}
+
+	policyDecisions, err := deploymentPolicies(ctx, eval, environments)
+	if err != nil {
+		funcLogger.Errorln("error observed while checking deployment policies", zap.Error(err))
+		return err
+	}
+
+	commenterPerms, err := loginHasPermission(ctx, eval, commenter, environments)
+	if err != nil {
+		funcLogger.Errorln("error observed while checking if commenter has permission", zap.Error(err))
+		return err
+	}
+
+	// the requester's own access is only needed when approving for them towards a quorum
+	var quorumEnvironments []string
+	for _, environment := range environments {
+		if policyDecisions[environment].requiredApprovals > 0 {
+			quorumEnvironments = append(quorumEnvironments, environment)
+		}
+	}
+	requesterPerms := map[string]accessDecision{}
+	if command.name == APPROVE_COMMAND && len(quorumEnvironments) > 0 {
+		requesterPerms, err = requesterHasPermission(ctx, eval, quorumEnvironments)
+		if err != nil {
+			funcLogger.Errorln("error observed while checking if requester has permission", zap.Error(err))
+			return err
+		}
+	}
+
+	for _, pendingDeployment := range commandDeployments {
+		environment := pendingDeployment.GetEnvironment().GetName()
+		policy := policyDecisions[environment]
+
+		// the command of a commenter without access to the environment is ignored
+		if !commenterPerms[environment].allowed {
+			reason := noAccessReason(commenter, commenterPerms[environment])
+			funcLogger.Info("commenter does not have permission, ignoring their command", zap.String("environment", environment), zap.String("reason", reason))
+			eval.recordDecision(environment, commenterPerms[environment], audit.PENDING_DECISION, reason, 0, nil)
+			continue
+		}
+
+		var err error
+		switch {
+		case command.name == REJECT_COMMAND:
+			err = rejectByComment(ctx, eval, pendingDeployment, environment, commenterPerms[environment], policy, command.reason)
+		case policy.requiredApprovals > 0:
+			err = approveQuorumByComment(ctx, eval, pendingDeployment, environment, commenterPerms[environment], requesterPerms[environment], policy)
+		default:
+			err = approveByComment(ctx, eval, pendingDeployment, environment, commenterPerms[environment], policy)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+*
+approves the pending deployment on behalf of the commenter, whose access to the environment
+is decision. A deployment blocked by a window or freeze is left pending, a comment can't
+approve a deployment the policy doesn't allow yet
+*/
+func approveByComment(ctx context.Context, eval *evaluation, pendingDeployment *github.PendingDeployment, environment string, decision accessDecision, policy policyDecision) error {
+	funcLogger := eval.logger.With(zap.String("environment", environment))
+
+	if policy.blocked {
+		funcLogger.Info("deployment is blocked by policy, leaving it pending", zap.String("reason", policy.reason))
+		eval.recordDecision(environment, decision, audit.PENDING_DECISION, policy.reason, 0, nil)
+		return nil
+	}
+
+	funcLogger.Info("commenter has permission, will attempt to approve pending deployment")
+
+	reason := fmt.Sprintf("%s, approved by %s", decision.reason, eval.approver)
+	statusCode, err := approvePendingDeployment(ctx, eval, pendingDeployment, commandApprovalComment(eval, environment, decision, policy))
+	eval.recordDecision(environment, decision, audit.APPROVED_DECISION, reason, statusCode, err)
+	if err != nil {
+		funcLogger.Error("error observed while trying to approve pending deployment", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+/*
+*
+records the commenter's approval of a pending deployment requiring the approval of other
+grant holders, approving it once enough have approved. The commenter must not be the run's
+requester and the requester must still have access to the environment themselves
+*/
+func approveQuorumByComment(ctx context.Context, eval *evaluation, pendingDeployment *github.PendingDeployment, environment string, approverAccess accessDecision, requesterAccess accessDecision, policy policyDecision) error {
+	funcLogger := eval.logger.With(zap.String("environment", environment))
+
+	if strings.EqualFold(eval.approver, eval.requester) {
+		reason := fmt.Sprintf("%s requested the deployment and cannot approve it", eval.approver)
+		funcLogger.Infoln("requester cannot approve their own deployment")
+		eval.recordDecision(environment, approverAccess, audit.PENDING_DECISION, reason, 0, nil)
+		return nil
+	}
+	if !requesterAccess.allowed {
+		reason := noAccessReason(eval.requester, requesterAccess)
+		funcLogger.Info("requester does not have permission, ignoring approval", zap.String("reason", reason))
+		eval.recordDecision(environment, requesterAccess, audit.PENDING_DECISION, reason, 0, nil)
+		return nil
+	}
+
+	quorum, err := recordQuorumApproval(ctx, eval, environment)
+	if err != nil {
+		funcLogger.Error("error observed while trying to record approval", zap.Error(err))
+		return err
+	}
+	if quorum == nil {
+		// the run was re-run by someone else in between
+		reason := fmt.Sprintf("no approval is open for %s's deployment", eval.requester)
+		funcLogger.Info("no quorum is open for the requester, ignoring approval")
+		eval.recordDecision(environment, approverAccess, audit.PENDING_DECISION, reason, 0, nil)
+		return nil
+	}
+
+	if len(quorum.Approvers) < policy.requiredApprovals {
+		reason := awaitingApprovalsReason(quorum.Approvers, policy.requiredApprovals)
+		funcLogger.Info("recorded approval, deployment requires the approval of more grant holders", zap.String("reason", reason))
+		eval.recordDecision(environment, approverAccess, audit.PENDING_DECISION, reason, 0, nil)
+		return nil
+	}
+
+	// the quorum stays open, so the reconciler approves the deployment once it is no longer blocked
+	if policy.blocked {
+		funcLogger.Info("deployment has been approved by enough grant holders but is blocked by policy, leaving it pending", zap.String("reason", policy.reason))
+		eval.recordDecision(environment, approverAccess, audit.PENDING_DECISION, policy.reason, 0, nil)
+		return nil
+	}
+
+	return approveQuorum(ctx, eval, pendingDeployment, environment, requesterAccess, policy, quorum.Approvers)
+}
+
+/*
+*
+rejects the pending deployment on behalf of the commenter, whose access to the environment is
+decision, with the reason they gave. The approvals collected for the deployment are discarded
+*/
+func rejectByComment(ctx context.Context, eval *evaluation, pendingDeployment *github.PendingDeployment, environment string, decision accessDecision, policy policyDecision, commentReason string) error {
+	funcLogger := eval.logger.With(zap.String("environment", environment))
+	funcLogger.Info("commenter has permission, will attempt to reject pending deployment")
+
+	reason := fmt.Sprintf("%s rejected the deployment", eval.approver)
+	if commentReason != "" {
+		reason = fmt.Sprintf("%s: %s", reason, commentReason)
+	}
+
+	statusCode, err := reviewPendingDeployment(ctx, eval, pendingDeployment, PENDING_DEPLOYMENT_REJECTED_STATE, rejectionComment(eval, environment, decision, policy, reason))
+	eval.recordDecision(environment, decision, audit.REJECTED_DECISION, reason, statusCode, err)
+	if err != nil {
+		funcLogger.Error("error observed while trying to reject pending deployment", zap.Error(err))
+		return err
+	}
+
+	// a re-run of the run needs to be approved again
+	if policy.requiredApprovals > 0 && eval.approvals != nil {
+		closeQuorum(ctx, eval, environment)
+	}
+	return nil
+}
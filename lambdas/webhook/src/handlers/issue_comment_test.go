@@ -0,0 +1,572 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"webhook/access"
+	"webhook/approval"
+	"webhook/audit"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+)
+
+const (
+	approver_name       = "github-approver"
+	other_approver_name = "github-other-approver"
+	pull_number         = 42
+	head_sha            = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+)
+
+var (
+	// when the comments of the tests were made
+	commented_at = time.Date(2024, 11, 5, 14, 3, 7, 0, time.UTC)
+)
+
+/*
+Test for case where the requester has access but the policy requires the
+approval of another grant holder, the deployment should be left pending
+*/
+func TestQuorumLeavesPending(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	accessStore = storeWithQuorumPolicy(1, requester_name, approver_name)
+	useMemoryApprovalStore(t)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, review, "deployment should have been left pending")
+	records := auditLog.Records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, audit.PENDING_DECISION, records[0].Decision)
+	assert.Equal(t, "awaiting the approval of 1 other grant holders", records[0].Reason)
+}
+
+/*
+Test for case where the org policy requires the approval of another grant holder
+but the environment's policy sets 0, the deployment should be approved straight away
+*/
+func TestEnvPolicyLowersQuorum(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	store := storeWithQuorumPolicy(0, requester_name)
+	orgRequiredApprovals := 1
+	store.PutPolicy(access.Policy{
+		Key:               access.GrantKey(access.ScopedRepository(owner_name, access.WILDCARD), access.WILDCARD),
+		RequiredApprovals: &orgRequiredApprovals,
+	})
+	accessStore = store
+	useMemoryApprovalStore(t)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_APPROVED_STATE, review.State)
+}
+
+/*
+Test for case where another grant holder comments /approve, the
+deployment should be approved with the approver in the comment
+*/
+func TestCommentApprovalReachesQuorum(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	accessStore = storeWithQuorumPolicy(1, requester_name, approver_name)
+	useMemoryApprovalStore(t)
+	auditLog := useMemoryAuditor(t)
+	HandleWorkflowRunEvent(context.TODO(), true, createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id))
+
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/approve"))
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_APPROVED_STATE, review.State)
+	assert.Contains(t, review.Comment, "Approved by "+approver_name)
+	records := auditLog.Records()
+	assert.Len(t, records, 2)
+	assert.Equal(t, ISSUE_COMMENT_AUDIT_SOURCE, records[1].Source)
+	assert.Equal(t, approver_name, records[1].Approver)
+	assert.Equal(t, requester_name, records[1].Requester)
+	assert.Equal(t, audit.APPROVED_DECISION, records[1].Decision)
+}
+
+/*
+Test for case where two approvals are required, the deployment
+should only be approved once the second grant holder approves
+*/
+func TestCommentApprovalAwaitingMore(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	accessStore = storeWithQuorumPolicy(2, requester_name, approver_name, other_approver_name)
+	useMemoryApprovalStore(t)
+	auditLog := useMemoryAuditor(t)
+
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+	HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/approve"))
+	firstReview := review
+
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(other_approver_name, "/approve"))
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, firstReview, "deployment should have been left pending after the first approval")
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_APPROVED_STATE, review.State)
+	records := auditLog.Records()
+	assert.Len(t, records, 2)
+	assert.Equal(t, audit.PENDING_DECISION, records[0].Decision)
+	assert.Contains(t, records[0].Reason, "awaiting the approval of 1 more grant holders")
+	assert.Equal(t, audit.APPROVED_DECISION, records[1].Decision)
+}
+
+/*
+Test for case where the requester comments /approve on their own
+deployment, their approval should not count towards the quorum
+*/
+func TestCommentApprovalByRequester(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+	accessStore = storeWithQuorumPolicy(1, requester_name, approver_name)
+	useMemoryApprovalStore(t)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(requester_name, "/approve"))
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, review, "deployment should have been left pending")
+	records := auditLog.Records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, audit.PENDING_DECISION, records[0].Decision)
+	assert.Contains(t, records[0].Reason, "cannot approve it")
+}
+
+/*
+Test for case where the commenter has no grant for the
+environment, their approval should not count towards the quorum
+*/
+func TestCommentApprovalWithoutAccess(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+	accessStore = storeWithQuorumPolicy(1, requester_name)
+	useMemoryApprovalStore(t)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/approve"))
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, review, "deployment should have been left pending")
+	records := auditLog.Records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, audit.PENDING_DECISION, records[0].Decision)
+	assert.Contains(t, records[0].Reason, approver_name+" does not have access")
+}
+
+/*
+Test for case where the comment is not a command, no run should be looked up
+*/
+func TestCommentNotACommand(t *testing.T) {
+	// arrange
+	ghClient = github.NewClient(ghMock.NewMockedHTTPClient())
+	accessStore = storeWithQuorumPolicy(1, requester_name, approver_name)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "looks good to me\n/approve"))
+
+	// assert
+	assert.Nil(t, err)
+}
+
+/*
+Test for case where a grant holder comments /approve <env> on an environment
+without a quorum, the deployment should be approved on their grant even
+though the requester has no access
+*/
+func TestCommentApproveEnvironment(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+	accessStore = storeWithGrant(approver_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/approve "+env_name))
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_APPROVED_STATE, review.State)
+	assert.Contains(t, review.Comment, approver_name+" has exact access")
+	records := auditLog.Records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, audit.APPROVED_DECISION, records[0].Decision)
+	assert.Equal(t, approver_name, records[0].Approver)
+	assert.Equal(t, requester_name, records[0].Requester)
+}
+
+/*
+Test for case where the /approve comment names another environment,
+the run's pending deployment should not be reviewed
+*/
+func TestCommentApproveOtherEnvironment(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+	accessStore = storeWithGrant(approver_name, access.ScopedRepository(owner_name, repo_name), access.WILDCARD)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/approve production"))
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, review, "deployment should not have been reviewed")
+	assert.Empty(t, auditLog.Records())
+}
+
+/*
+Test for case where a grant holder comments /reject <env> <reason>,
+the deployment should be rejected with their reason
+*/
+func TestCommentReject(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+	accessStore = storeWithGrant(approver_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/reject "+env_name+" tests are failing"))
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_REJECTED_STATE, review.State)
+	assert.Contains(t, review.Comment, approver_name+" rejected the deployment: tests are failing")
+	records := auditLog.Records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, audit.REJECTED_DECISION, records[0].Decision)
+	assert.Equal(t, approver_name, records[0].Approver)
+}
+
+/*
+Test for case where the commenter of /reject has no grant
+for the environment, the deployment should be left pending
+*/
+func TestCommentRejectWithoutAccess(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+	accessStore = storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/reject "+env_name+" not yet"))
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, review, "deployment should have been left pending")
+	records := auditLog.Records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, audit.PENDING_DECISION, records[0].Decision)
+	assert.Contains(t, records[0].Reason, approver_name+" does not have access")
+}
+
+/*
+Test for case where a deployment awaiting more approvals is rejected,
+the approvals collected so far should be discarded
+*/
+func TestCommentRejectClosesQuorum(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	accessStore = storeWithQuorumPolicy(2, requester_name, approver_name, other_approver_name)
+	approvals := useMemoryApprovalStore(t)
+	useMemoryAuditor(t)
+
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+	HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/approve"))
+
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(other_approver_name, "/reject "+env_name))
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_REJECTED_STATE, review.State)
+	quorum, _ := approvals.Open(context.TODO(), approval.Quorum{
+		Key:       quorumKey(newEvaluation(requester_name, owner_name, repo_name, run_id), env_name),
+		RunID:     run_id,
+		Requester: requester_name,
+	})
+	assert.Empty(t, quorum.Approvers, "approvals should have been discarded")
+}
+
+/*
+Test for case where another grant holder comments /approve while dry run is enabled,
+the approval should be audited but neither the deployment nor the approval store touched
+*/
+func TestDryRunQuorum(t *testing.T) {
+	// arrange
+	dryRunEnabled = true
+	t.Cleanup(func() { dryRunEnabled = false })
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	accessStore = storeWithQuorumPolicy(1, requester_name, approver_name)
+	approvals := useMemoryApprovalStore(t)
+	auditLog := useMemoryAuditor(t)
+	HandleWorkflowRunEvent(context.TODO(), true, createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id))
+
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/approve"))
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, review, "deployment should not have been reviewed during a dry run")
+	records := auditLog.Records()
+	assert.Len(t, records, 2)
+	assert.Equal(t, audit.PENDING_DECISION, records[0].Decision)
+	assert.Equal(t, audit.APPROVED_DECISION, records[1].Decision)
+	assert.Contains(t, records[1].Reason, "approved by "+approver_name)
+	assert.True(t, records[1].DryRun)
+	quorum, _ := approvals.Get(context.TODO(), quorumKey(newEvaluation(requester_name, owner_name, repo_name, run_id), env_name), run_id, requester_name)
+	assert.Nil(t, quorum, "quorum should not have been opened during a dry run")
+}
+
+/*
+Test for case where a deployment awaiting more approvals is rejected while dry
+run is enabled, the approvals collected so far should be kept
+*/
+func TestDryRunRejectKeepsQuorum(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	accessStore = storeWithQuorumPolicy(2, requester_name, approver_name, other_approver_name)
+	approvals := useMemoryApprovalStore(t)
+	useMemoryAuditor(t)
+
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+	HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/approve"))
+
+	dryRunEnabled = true
+	t.Cleanup(func() { dryRunEnabled = false })
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(other_approver_name, "/reject "+env_name))
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, review, "deployment should not have been reviewed during a dry run")
+	quorum, _ := approvals.Get(context.TODO(), quorumKey(newEvaluation(requester_name, owner_name, repo_name, run_id), env_name), run_id, requester_name)
+	assert.NotNil(t, quorum, "quorum should have been kept during a dry run")
+	assert.Equal(t, []string{approver_name}, quorum.Approvers)
+}
+
+/*
+Test for case where a commit is pushed to the pull request after the /approve
+comment but before it is processed, the run of the new head commit was created
+after the comment so it should not be approved
+*/
+func TestCommentApprovalHeadMoved(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedCommentGhClientWithRunAt(requester_name, commented_at.Add(time.Minute), &review)
+	accessStore = storeWithGrant(approver_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/approve"))
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, review, "run created after the comment should not have been reviewed")
+	assert.Empty(t, auditLog.Records())
+}
+
+/*
+Test for case where the comment has no created_at, the
+runs it applies to can't be told apart so none should be reviewed
+*/
+func TestCommentWithoutCreatedAt(t *testing.T) {
+	// arrange
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedCommentGhClient(requester_name, &review)
+	accessStore = storeWithGrant(approver_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	event := createdIssueCommentEvent(approver_name, "/approve")
+	event.Comment.CreatedAt = nil
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, event)
+
+	// assert
+	assert.NotNil(t, err)
+	assert.Nil(t, review, "deployment should not have been reviewed")
+}
+
+/*
+Test for case where /reject doesn't name an environment, no run should be looked up
+*/
+func TestCommentRejectWithoutEnvironment(t *testing.T) {
+	// arrange
+	ghClient = github.NewClient(ghMock.NewMockedHTTPClient())
+	accessStore = storeWithGrant(approver_name, access.ScopedRepository(owner_name, repo_name), env_name)
+
+	// act
+	err := HandleIssueCommentEvent(context.TODO(), true, createdIssueCommentEvent(approver_name, "/reject"))
+
+	// assert
+	assert.Nil(t, err)
+}
+
+/*
+Test for case where a command names an environment and a reason,
+the reason should be the rest of the command's line
+*/
+func TestParseCommand(t *testing.T) {
+	// act
+	command := parseCommand("  /REJECT staging  the migration   is broken\nmore context")
+
+	// assert
+	assert.Equal(t, REJECT_COMMAND, command.name)
+	assert.Equal(t, "staging", command.environment)
+	assert.Equal(t, "the migration is broken", command.reason)
+}
+
+/*
+returns an in-memory access store where the logins have the <owner>/<repo>#<env>
+grant and the environment's policy requires the approval of other grant holders
+*/
+func storeWithQuorumPolicy(requiredApprovals int, logins ...string) *access.MemoryStore {
+	store := access.NewMemoryStore()
+	for _, login := range logins {
+		store.Add(login, access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name))
+	}
+	store.PutPolicy(access.Policy{
+		Key:               access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name),
+		RequiredApprovals: &requiredApprovals,
+	})
+	return store
+}
+
+/*
+sets the shared approval store to an in-memory store for the test
+*/
+func useMemoryApprovalStore(t *testing.T) *approval.MemoryStore {
+	store := approval.NewMemoryStore(time.Hour)
+	approvalStore = store
+	t.Cleanup(func() { approvalStore = nil })
+	return store
+}
+
+/*
+mocks a pull request with one waiting run triggered by actor before the comment,
+review is set to the review posted for the run's pending deployment
+*/
+func getMockedCommentGhClient(actor string, review **github.PendingDeploymentsRequest) *github.Client {
+	return getMockedCommentGhClientWithRunAt(actor, commented_at.Add(-time.Minute), review)
+}
+
+/*
+mocks a pull request with one waiting run triggered by actor and created at runCreatedAt,
+review is set to the review posted for the run's pending deployment
+*/
+func getMockedCommentGhClientWithRunAt(actor string, runCreatedAt time.Time, review **github.PendingDeploymentsRequest) *github.Client {
+	runID := run_id
+	envName := env_name
+	deploymentURL := "example.com"
+
+	mockedHTTPClient := ghMock.NewMockedHTTPClient(
+		ghMock.WithRequestMatch(
+			ghMock.GetReposPullsByOwnerByRepoByPullNumber,
+			github.PullRequest{Number: github.Int(pull_number), Head: &github.PullRequestBranch{SHA: github.String(head_sha)}},
+		),
+		ghMock.WithRequestMatchHandler(
+			ghMock.GetReposActionsRunsByOwnerByRepo,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("head_sha") != head_sha {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Write(ghMock.MustMarshal(github.WorkflowRuns{
+					TotalCount: github.Int(1),
+					WorkflowRuns: []*github.WorkflowRun{{
+						ID:              github.Int64(run_id),
+						Status:          github.String(WAITING_RUN_STATUS),
+						CreatedAt:       &github.Timestamp{Time: runCreatedAt},
+						TriggeringActor: &github.User{Login: github.String(actor)},
+					}},
+				}))
+			}),
+		),
+		ghMock.WithRequestMatch(
+			ghMock.GetReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			[]*github.PendingDeployment{{Environment: &github.PendingDeploymentEnvironment{ID: &runID, Name: &envName}}},
+		),
+		ghMock.WithRequestMatchHandler(
+			ghMock.PostReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var posted github.PendingDeploymentsRequest
+				if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				*review = &posted
+				w.Write(ghMock.MustMarshal([]*github.Deployment{{URL: &deploymentURL}}))
+			}),
+		),
+	)
+
+	return github.NewClient(mockedHTTPClient)
+}
+
+func createdIssueCommentEvent(commenter string, body string) *github.IssueCommentEvent {
+	action := CREATED_ACTION
+	repoName := repo_name
+	ownerName := owner_name
+
+	return &github.IssueCommentEvent{
+		Action: &action,
+		Repo:   &github.Repository{Name: &repoName, Owner: &github.User{Login: &ownerName}},
+		Issue: &github.Issue{
+			Number:           github.Int(pull_number),
+			PullRequestLinks: &github.PullRequestLinks{URL: github.String("https://api.github.com/repos/github-owner/test-repo/pulls/42")},
+		},
+		Comment: &github.IssueComment{Body: &body, User: &github.User{Login: &commenter}, CreatedAt: &github.Timestamp{Time: commented_at}},
+		Sender:  &github.User{Login: &commenter},
+	}
+}
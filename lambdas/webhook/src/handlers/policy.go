@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"webhook/access"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.uber.org/zap"
+)
+
+/*
+*
+the outcome of checking an environment's deployment policies, reason
+explains why the deployment was blocked and reject is true if a blocked
+deployment should be rejected rather than left pending. rejectNoAccess
+is true if a deployment the requester has no access to should be rejected.
+approvedComment and rejectedComment are the environment's comment templates, if any.
+requiredApprovals is the number of other grant holders that must approve the deployment
+*/
+type policyDecision struct {
+	blocked           bool
+	reject            bool
+	rejectNoAccess    bool
+	reason            string
+	approvedComment   string
+	rejectedComment   string
+	requiredApprovals int
+}
+
+/*
+*
+checks the deployment windows and freezes of every environment at the current time.
+Freezes of every level apply, while the window, the on_blocked and on_no_access
+settings, the comments and the required approvals are those of the most specific
+policy that sets them
+*
+*/
+func checkDeploymentPolicies(ctx context.Context, eval *evaluation, owner string, repository string, environments []string) (map[string]policyDecision, error) {
+	funcLogger := eval.logger.With(zap.Strings("environments", environments))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "checkDeploymentPolicies")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	var keys []string
+	for _, environment := range environments {
+		for _, level := range accessLevels(owner, repository, environment) {
+			keys = append(keys, level.key)
+		}
+	}
+
+	policies, err := eval.store.GetPolicies(ctx, keys)
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to get deployment policies from access store", zap.Error(err))
+		return nil, err
+	}
+
+	policiesByKey := make(map[string]access.Policy, len(policies))
+	for _, policy := range policies {
+		policiesByKey[policy.Key] = policy
+	}
+
+	now := time.Now()
+	decisions := make(map[string]policyDecision, len(environments))
+	for _, environment := range environments {
+		var envPolicies []access.Policy
+		for _, level := range accessLevels(owner, repository, environment) {
+			if policy, exists := policiesByKey[level.key]; exists {
+				envPolicies = append(envPolicies, policy)
+			}
+		}
+
+		decision := decidePolicy(envPolicies, now)
+		decisions[environment] = decision
+
+		if decision.blocked {
+			funcLogger.Infoln("deployment was blocked by policy", zap.String("environment", environment), zap.String("reason", decision.reason), zap.Bool("reject", decision.reject))
+		}
+	}
+
+	return decisions, nil
+}
+
+/*
+*
+decides whether the policies, ordered from most to least specific,
+block a deployment at the time passed
+*/
+func decidePolicy(policies []access.Policy, now time.Time) policyDecision {
+	var window *access.Window
+	var onBlocked, onNoAccess, approvedComment, rejectedComment string
+	var requiredApprovals *int
+	for _, policy := range policies {
+		if window == nil {
+			window = policy.Window
+		}
+		if onBlocked == "" {
+			onBlocked = policy.OnBlocked
+		}
+		if onNoAccess == "" {
+			onNoAccess = policy.OnNoAccess
+		}
+		if approvedComment == "" {
+			approvedComment = policy.ApprovedComment
+		}
+		if rejectedComment == "" {
+			rejectedComment = policy.RejectedComment
+		}
+		if requiredApprovals == nil {
+			requiredApprovals = policy.RequiredApprovals
+		}
+	}
+
+	decision := policyDecision{
+		reject:          onBlocked == access.ACTION_REJECT,
+		rejectNoAccess:  onNoAccess == access.ACTION_REJECT,
+		approvedComment: approvedComment,
+		rejectedComment: rejectedComment,
+	}
+	if requiredApprovals != nil {
+		decision.requiredApprovals = *requiredApprovals
+	}
+
+	for _, policy := range policies {
+		for _, freeze := range policy.Freezes {
+			if freeze.Contains(now) {
+				decision.blocked = true
+				decision.reason = fmt.Sprintf("deployments are frozen for %q until %s", freeze.Name, time.Unix(freeze.End, 0).UTC().Format(time.RFC3339))
+				return decision
+			}
+		}
+	}
+
+	if window != nil && !window.Contains(now) {
+		decision.blocked = true
+		decision.reason = fmt.Sprintf("deployments are only allowed during the deployment window %s", window)
+	}
+
+	return decision
+}
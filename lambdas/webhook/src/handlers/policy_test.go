@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"webhook/access"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+)
+
+/*
+Test for case where user has access but the environment
+is in an active freeze, the deployment should be left pending
+*/
+func TestFreezeLeavesPending(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	store := storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	store.PutPolicy(access.Policy{
+		Key:     access.GrantKey(access.ScopedRepository(owner_name, access.WILDCARD), access.WILDCARD),
+		Freezes: []access.Freeze{activeFreeze()},
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, review, "deployment should have been left pending")
+}
+
+/*
+Test for case where user has access but the environment is in an
+active freeze and the policy rejects blocked deployments
+*/
+func TestFreezeRejects(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	store := storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	store.PutPolicy(access.Policy{
+		Key:       access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name),
+		Freezes:   []access.Freeze{activeFreeze()},
+		OnBlocked: access.ACTION_REJECT,
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_REJECTED_STATE, review.State)
+	assert.Contains(t, review.Comment, "incident")
+}
+
+/*
+Test for case where user has access but the current time
+is outside the environment's deployment window
+*/
+func TestOutsideDeploymentWindow(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	store := storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	store.PutPolicy(access.Policy{
+		Key:       access.GrantKey(access.ScopedRepository(owner_name, access.WILDCARD), env_name),
+		Window:    closedWindow(),
+		OnBlocked: access.ACTION_REJECT,
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_REJECTED_STATE, review.State)
+	assert.Contains(t, review.Comment, "deployment window")
+}
+
+/*
+Test for case where user has access and the freeze of
+the environment has ended, the deployment should be approved
+*/
+func TestEndedFreezeApproves(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	store := storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	store.PutPolicy(access.Policy{
+		Key: access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name),
+		Freezes: []access.Freeze{{
+			Name:  "incident",
+			Start: time.Now().Add(-2 * time.Hour).Unix(),
+			End:   time.Now().Add(-time.Hour).Unix(),
+		}},
+		OnBlocked: access.ACTION_REJECT,
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_APPROVED_STATE, review.State)
+}
+
+/*
+Test for case where requester has access but the environment is in an active freeze
+and its policy leaves blocked deployments pending, the protection rule should still
+be rejected as a protection rule left pending is never revisited
+*/
+func TestProtectionRuleFreezeRejects(t *testing.T) {
+	// arrange
+	store := storeWithGrant(requester_name, repo_name, env_name)
+	store.PutPolicy(access.Policy{
+		Key:     access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name),
+		Freezes: []access.Freeze{activeFreeze()},
+	})
+	accessStore = store
+
+	var review github.ReviewCustomDeploymentProtectionRuleRequest
+	ghClient = getMockedProtectionRuleGhClient(&review)
+	event := createdDeploymentProtectionRuleEvent(repo_name, owner_name, requester_name, env_name, run_id)
+
+	// act
+	err := HandleDeploymentProtectionRuleEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, PROTECTION_RULE_REJECTED_STATE, review.State)
+	assert.Contains(t, review.Comment, "frozen")
+}
+
+/*
+Test for case where user has no access and the environment's policy
+rejects deployments without access, the comment should name the missing grant
+*/
+func TestNoAccessRejects(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	store := access.NewMemoryStore()
+	store.PutPolicy(access.Policy{
+		Key:        access.GrantKey(access.ScopedRepository(owner_name, access.WILDCARD), access.WILDCARD),
+		OnNoAccess: access.ACTION_REJECT,
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_REJECTED_STATE, review.State)
+	assert.Contains(t, review.Comment, access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name))
+}
+
+/*
+Test for case where user is denied access and the environment's policy
+rejects deployments without access, the comment should name the deny grant
+*/
+func TestDenyRejects(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	var review *github.PendingDeploymentsRequest
+	ghClient = getMockedReviewGhClient(run_id, env_name, &review)
+	denyKey := access.GrantKey(access.ScopedRepository(owner_name, repo_name), access.WILDCARD)
+	store := access.NewMemoryStore()
+	store.Deny(requester_name, denyKey)
+	store.PutPolicy(access.Policy{
+		Key:        access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name),
+		OnNoAccess: access.ACTION_REJECT,
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.NotNil(t, review, "deployment should have been reviewed")
+	assert.Equal(t, PENDING_DEPLOYMENT_REJECTED_STATE, review.State)
+	assert.Contains(t, review.Comment, denyKey)
+}
+
+/*
+returns a freeze named incident that started an hour ago and ends in an hour
+*/
+func activeFreeze() access.Freeze {
+	return access.Freeze{
+		Name:  "incident",
+		Start: time.Now().Add(-time.Hour).Unix(),
+		End:   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+/*
+returns a one minute window that starts twelve hours from now, every day
+*/
+func closedWindow() *access.Window {
+	start := time.Now().UTC().Add(12 * time.Hour)
+	offset := time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute
+	return &access.Window{
+		Days:     []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+		Start:    offset,
+		End:      offset + time.Minute,
+		Location: time.UTC,
+	}
+}
+
+/*
+mocks the pending deployments endpoints, review is set
+to the review posted for the pending deployment
+*/
+func getMockedReviewGhClient(runID int64, envName string, review **github.PendingDeploymentsRequest) *github.Client {
+
+	deploymentURL := "example.com"
+
+	pendingDeployments := []*github.PendingDeployment{{
+		Environment: &github.PendingDeploymentEnvironment{ID: &runID, Name: &envName},
+	}}
+	reviewedDeployments := []*github.Deployment{{
+		URL: &deploymentURL,
+	}}
+
+	mockedHTTPClient := ghMock.NewMockedHTTPClient(
+		ghMock.WithRequestMatch(
+			ghMock.GetReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			pendingDeployments),
+		ghMock.WithRequestMatchHandler(
+			ghMock.PostReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var posted github.PendingDeploymentsRequest
+				if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				*review = &posted
+				w.Write(ghMock.MustMarshal(reviewedDeployments))
+			}),
+		),
+	)
+
+	return github.NewClient(mockedHTTPClient)
+}
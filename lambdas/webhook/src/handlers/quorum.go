@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"webhook/access"
+	"webhook/approval"
+	"webhook/audit"
+
+	"github.com/google/go-github/v66/github"
+	"go.uber.org/zap"
+)
+
+/*
+*
+returns the <owner>/<repo>#<env> key the quorum of the environment is kept under
+*/
+func quorumKey(eval *evaluation, environment string) string {
+	return strings.ToLower(access.GrantKey(access.ScopedRepository(eval.owner, eval.repository), environment))
+}
+
+/*
+*
+opens the quorum of the run's pending deployment to the environment for the
+evaluation's requester, or returns the quorum already open for them. A dry
+run only reads the store and returns the quorum that would have been opened
+*/
+func openQuorum(ctx context.Context, eval *evaluation, environment string) (approval.Quorum, error) {
+	if eval.approvals == nil {
+		return approval.Quorum{}, errors.New("an approval store has not been configured")
+	}
+
+	quorum := approval.Quorum{
+		Key:       quorumKey(eval, environment),
+		RunID:     eval.runID,
+		Requester: strings.ToLower(eval.requester),
+	}
+	if !eval.dryRun {
+		return eval.approvals.Open(ctx, quorum)
+	}
+
+	open, err := eval.approvals.Get(ctx, quorum.Key, quorum.RunID, quorum.Requester)
+	if err != nil {
+		return approval.Quorum{}, err
+	}
+	if open != nil {
+		return *open, nil
+	}
+	eval.logger.Infoln("dry run, not opening quorum", zap.String("environment", environment))
+	return quorum, nil
+}
+
+/*
+*
+records the evaluation's approver in the quorum of the environment, opening it in case the
+run's workflow run event was lost or hasn't been processed yet. nil is returned if no quorum is
+open for the requester. A dry run leaves the store as is and only adds the approver to the copy returned
+*/
+func recordQuorumApproval(ctx context.Context, eval *evaluation, environment string) (*approval.Quorum, error) {
+	quorum, err := openQuorum(ctx, eval, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	approver := strings.ToLower(eval.approver)
+	if !eval.dryRun {
+		return eval.approvals.Approve(ctx, quorum.Key, quorum.RunID, quorum.Requester, approver)
+	}
+
+	if !quorum.HasApproved(approver) {
+		quorum.Approvers = append(quorum.Approvers, approver)
+	}
+	eval.logger.Infoln("dry run, not recording approval", zap.String("environment", environment))
+	return &quorum, nil
+}
+
+/*
+*
+approves the pending deployment once the quorum of the environment has been reached and
+closes the quorum, so a re-run of the run needs to be approved again. decision is the
+requester's access to the environment and approvers the grant holders that approved it
+*/
+func approveQuorum(ctx context.Context, eval *evaluation, pendingDeployment *github.PendingDeployment, environment string, decision accessDecision, policy policyDecision, approvers []string) error {
+	funcLogger := eval.logger.With(zap.String("environment", environment), zap.Strings("approvers", approvers))
+	funcLogger.Info("deployment has been approved by enough grant holders, will attempt to approve pending deployment")
+
+	reason := fmt.Sprintf("%s, approved by %s", decision.reason, strings.Join(approvers, ", "))
+	statusCode, err := approvePendingDeployment(ctx, eval, pendingDeployment, approvalComment(eval, environment, decision, policy, approvers))
+	eval.recordDecision(environment, decision, audit.APPROVED_DECISION, reason, statusCode, err)
+	if err != nil {
+		funcLogger.Error("error observed while trying to approve pending deployment", zap.Error(err))
+		return err
+	}
+
+	// the deployment is approved, a quorum left open only expires
+	closeQuorum(ctx, eval, environment)
+	return nil
+}
+
+/*
+*
+closes the quorum of the environment so a re-run of the run needs to be approved again,
+a quorum that can't be closed only expires so the error is logged rather than returned
+*/
+func closeQuorum(ctx context.Context, eval *evaluation, environment string) {
+	funcLogger := eval.logger.With(zap.String("environment", environment))
+	if eval.dryRun {
+		funcLogger.Infoln("dry run, not closing quorum")
+		return
+	}
+
+	if err := eval.approvals.Close(ctx, quorumKey(eval, environment), eval.runID); err != nil {
+		funcLogger.Warnln("error observed while trying to close quorum", zap.Error(err))
+	}
+}
+
+/*
+*
+explains why a deployment is waiting on the approval of other grant holders
+*/
+func awaitingApprovalsReason(approvers []string, required int) string {
+	if len(approvers) == 0 {
+		return fmt.Sprintf("awaiting the approval of %d other grant holders", required)
+	}
+	return fmt.Sprintf("approved by %s, awaiting the approval of %d more grant holders", strings.Join(approvers, ", "), required-len(approvers))
+}
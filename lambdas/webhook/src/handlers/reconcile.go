@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"webhook/access"
+	"webhook/util"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/google/go-github/v66/github"
+	"go.uber.org/zap"
+)
+
+const (
+	// comma separated <owner>/<repo> repositories swept for waiting runs
+	RECONCILE_REPOSITORIES_ENV_VAR_KEY = "RECONCILE_REPOSITORIES"
+	RECONCILE_REPOSITORIES_DEFAULT     = ""
+
+	// waiting runs are evaluated and logged but not reviewed during a dry run
+	RECONCILE_DRY_RUN_ENV_VAR_KEY = "RECONCILE_DRY_RUN"
+	RECONCILE_DRY_RUN_DEFAULT     = "false"
+
+	WAITING_RUN_STATUS      = "waiting"
+	WORKFLOW_RUNS_PAGE_SIZE = 100
+)
+
+/*
+returns the repositories (<owner>/<repo>) sourced from RECONCILE_REPOSITORIES_ENV_VAR_KEY
+and whether the reconciler is configured to only do a dry run
+*/
+func ReconcileConfig() ([]string, bool, error) {
+	var repositories []string
+	for _, repository := range strings.Split(util.LookupEnv(RECONCILE_REPOSITORIES_ENV_VAR_KEY, RECONCILE_REPOSITORIES_DEFAULT, false), ",") {
+		repository = strings.TrimSpace(repository)
+		if repository == "" {
+			continue
+		}
+		if owner, name, found := strings.Cut(repository, access.OWNER_SEPARATOR); !found || owner == "" || name == "" {
+			return nil, false, fmt.Errorf("repository %q to reconcile is not an <owner>/<repo>", repository)
+		}
+		repositories = append(repositories, repository)
+	}
+
+	dryRun := strings.ToLower(util.LookupEnv(RECONCILE_DRY_RUN_ENV_VAR_KEY, RECONCILE_DRY_RUN_DEFAULT, false)) == "true"
+	return repositories, dryRun, nil
+}
+
+/*
+sweeps the repositories (<owner>/<repo>) for workflow runs waiting on a review and
+reviews them as the workflow run event would have, so runs whose delivery was lost
+or failed are still approved. A run that fails doesn't stop the others from being reviewed
+*/
+func ReconcileWaitingRuns(ctx context.Context, mocking bool, repositories []string, dryRun bool) error {
+	funcLogger := logInstance.With(zap.Strings("repositories", repositories), zap.Bool("dry_run", dryRun))
+
+	if !mocking {
+		clientSetupErr := setupClients(ctx)
+		if clientSetupErr != nil {
+			funcLogger.Errorln("error while setting up clients")
+			return clientSetupErr
+		}
+	}
+
+	_, subSegment := xray.BeginSubsegment(ctx, "ReconcileWaitingRuns")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	var errs []error
+	for _, repository := range repositories {
+		owner, name, _ := strings.Cut(repository, access.OWNER_SEPARATOR)
+		if err := reconcileRepository(ctx, mocking, owner, name, dryRun); err != nil {
+			funcLogger.Errorln("error observed while reconciling repository", zap.String("repository", repository), zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+reviews every waiting run of the repository, the requester of a run is the actor that triggered it
+*/
+func reconcileRepository(ctx context.Context, mocking bool, owner string, repository string, dryRun bool) error {
+	// the lambda's dry run applies to the reconciler as well
+	dryRun = dryRun || dryRunEnabled
+
+	repoEval := newEvaluation("", owner, repository, 0)
+	funcLogger := repoEval.logger.With(zap.Bool("dry_run", dryRun))
+
+	if err := repoEval.useInstallationClient(ctx, mocking, 0); err != nil {
+		funcLogger.Errorln("error while getting github client to reconcile repository", zap.Error(err))
+		return err
+	}
+
+	runs, err := listWaitingRuns(ctx, repoEval, "")
+	if err != nil {
+		funcLogger.Errorln("error observed while listing waiting runs", zap.Error(err))
+		return err
+	}
+	funcLogger.Infoln("found waiting runs", zap.Int("run_count", len(runs)))
+
+	var errs []error
+	for _, run := range runs {
+		requester := runRequester(run)
+		if requester == "" {
+			err := fmt.Errorf("waiting run %d has no actor", run.GetID())
+			funcLogger.Errorln("invalid field", zap.Error(err))
+			errs = append(errs, err)
+			continue
+		}
+
+		eval := newEvaluation(requester, owner, repository, run.GetID())
+		eval.ghClient = repoEval.ghClient
+		eval.dryRun = dryRun
+		eval.source = RECONCILER_AUDIT_SOURCE
+		eval.logger = eval.logger.With(zap.Bool("dry_run", dryRun), zap.Bool("reconciler", true))
+
+		eval.logger.Infoln("reviewing waiting run")
+		if err := reviewWorkflowRun(ctx, eval); err != nil {
+			eval.logger.Errorln("error observed while reviewing waiting run", zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+returns the requester of a run, the actor that triggered it (ex. re-ran it) or else the actor that started it
+*/
+func runRequester(run *github.WorkflowRun) string {
+	if requester := run.GetTriggeringActor().GetLogin(); requester != "" {
+		return requester
+	}
+	return run.GetActor().GetLogin()
+}
+
+/*
+lists the workflow runs of the repository that are waiting on a review, page by page.
+Only the runs of the head commit are listed when headSHA is set
+*/
+func listWaitingRuns(ctx context.Context, eval *evaluation, headSHA string) ([]*github.WorkflowRun, error) {
+	opts := &github.ListWorkflowRunsOptions{
+		Status:      WAITING_RUN_STATUS,
+		HeadSHA:     headSHA,
+		ListOptions: github.ListOptions{PerPage: WORKFLOW_RUNS_PAGE_SIZE},
+	}
+
+	var runs []*github.WorkflowRun
+	for {
+		page, resp, err := eval.ghClient.Actions.ListRepositoryWorkflowRuns(ctx, eval.owner, eval.repository, opts)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, page.WorkflowRuns...)
+
+		if resp.NextPage == 0 {
+			return runs, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
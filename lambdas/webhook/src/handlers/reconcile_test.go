@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"webhook/access"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+)
+
+/*
+Test for case where a run is waiting and the actor that triggered
+it has access, the missed deployment should be approved
+*/
+func TestReconcileApprovesWaitingRun(t *testing.T) {
+	// arrange
+	reviewed := 0
+	ghClient = getMockedReconcileGhClient(requester_name, &reviewed)
+	accessStore = storeWithGrant(requester_name, repo_name, env_name)
+
+	// act
+	err := ReconcileWaitingRuns(context.TODO(), true, []string{owner_name + "/" + repo_name}, false)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, 1, reviewed, "waiting deployment should have been approved")
+}
+
+/*
+Test for case where the reconciler is doing a dry run, the waiting
+run should be evaluated but the deployment should not be reviewed
+*/
+func TestReconcileDryRun(t *testing.T) {
+	// arrange
+	reviewed := 0
+	ghClient = getMockedReconcileGhClient(requester_name, &reviewed)
+	accessStore = storeWithGrant(requester_name, repo_name, env_name)
+
+	// act
+	err := ReconcileWaitingRuns(context.TODO(), true, []string{owner_name + "/" + repo_name}, true)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, 0, reviewed, "waiting deployment should not have been reviewed during a dry run")
+}
+
+/*
+Test for case where the actor that triggered the waiting
+run has no access, the deployment should be left waiting
+*/
+func TestReconcileNoAccess(t *testing.T) {
+	// arrange
+	reviewed := 0
+	ghClient = getMockedReconcileGhClient(requester_name, &reviewed)
+	accessStore = access.NewMemoryStore()
+
+	// act
+	err := ReconcileWaitingRuns(context.TODO(), true, []string{owner_name + "/" + repo_name}, false)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, 0, reviewed, "waiting deployment should not have been approved")
+}
+
+/*
+Test for case where the repositories to reconcile are configured,
+entries that aren't an <owner>/<repo> should be refused
+*/
+func TestReconcileConfig(t *testing.T) {
+	// arrange
+	t.Setenv(RECONCILE_REPOSITORIES_ENV_VAR_KEY, " octo-org/api, ,octo-org/web ")
+	t.Setenv(RECONCILE_DRY_RUN_ENV_VAR_KEY, "TRUE")
+
+	// act
+	repositories, dryRun, err := ReconcileConfig()
+	t.Setenv(RECONCILE_REPOSITORIES_ENV_VAR_KEY, "octo-org")
+	_, _, invalidErr := ReconcileConfig()
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"octo-org/api", "octo-org/web"}, repositories)
+	assert.True(t, dryRun)
+	assert.NotNil(t, invalidErr)
+}
+
+/*
+mocks a repository with one waiting run triggered by actor,
+reviewed is incremented each time a pending deployment review is posted
+*/
+func getMockedReconcileGhClient(actor string, reviewed *int) *github.Client {
+	envID := int64(1)
+	envName := env_name
+	deploymentURL := "example.com"
+
+	mockedHTTPClient := ghMock.NewMockedHTTPClient(
+		ghMock.WithRequestMatch(
+			ghMock.GetReposActionsRunsByOwnerByRepo,
+			github.WorkflowRuns{
+				TotalCount: github.Int(1),
+				WorkflowRuns: []*github.WorkflowRun{{
+					ID:              github.Int64(run_id),
+					Status:          github.String(WAITING_RUN_STATUS),
+					TriggeringActor: &github.User{Login: github.String(actor)},
+				}},
+			},
+		),
+		ghMock.WithRequestMatch(
+			ghMock.GetReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			[]*github.PendingDeployment{{Environment: &github.PendingDeploymentEnvironment{ID: &envID, Name: &envName}}},
+		),
+		ghMock.WithRequestMatchHandler(
+			ghMock.PostReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				*reviewed++
+				w.Write(ghMock.MustMarshal([]*github.Deployment{{URL: &deploymentURL}}))
+			}),
+		),
+	)
+
+	return github.NewClient(mockedHTTPClient)
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"webhook/access"
+	"webhook/util"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.uber.org/zap"
+)
+
+const (
+	// grants given to team:<org>/<slug> subjects are only looked up when enabled,
+	// resolving team memberships costs a GitHub API call per team holding a grant
+	TEAM_GRANTS_ENABLED_ENV_VAR_KEY = "TEAM_GRANTS_ENABLED"
+	TEAM_GRANTS_ENABLED_DEFAULT     = "false"
+
+	ACTIVE_MEMBERSHIP_STATE = "active"
+
+	// how long the teams holding grants are cached before the access store is asked again
+	TEAM_SUBJECTS_CACHE_TTL = 5 * time.Minute
+	// how long a requester's membership of a team is cached before GitHub is asked again,
+	// so someone added to or removed from a team is picked up without a cold start
+	TEAM_MEMBERSHIP_CACHE_TTL = 5 * time.Minute
+)
+
+var (
+	teamGrantsEnabled bool
+
+	// the requester's memberships of the teams checked so far are cached for
+	// TEAM_MEMBERSHIP_CACHE_TTL, keyed by <owner>/<login> and then team slug
+	teamCacheMutex sync.RWMutex
+	teamCache      = map[string]map[string]teamMembership{}
+
+	// the slugs of the teams holding grants, keyed by owner
+	grantedTeamsMutex sync.RWMutex
+	grantedTeamsCache = map[string]grantedTeams{}
+)
+
+/*
+whether the requester is an active member of a team and when that stops being fresh
+*/
+type teamMembership struct {
+	member    bool
+	expiresAt time.Time
+}
+
+/*
+the slugs of an owner's teams holding grants and when they stop being fresh
+*/
+type grantedTeams struct {
+	slugs     []string
+	expiresAt time.Time
+}
+
+func init() {
+	teamGrantsEnabled = strings.ToLower(util.LookupEnv(TEAM_GRANTS_ENABLED_ENV_VAR_KEY, TEAM_GRANTS_ENABLED_DEFAULT, false)) == "true"
+}
+
+/*
+*
+returns the subjects whose grants apply to the requester, the requester's
+own login always comes first followed by the team:<org>/<slug> subject
+of every team of owner holding a grant the requester is an active member of
+*/
+func requesterSubjects(ctx context.Context, eval *evaluation, requester string, owner string) ([]string, error) {
+	subjects := []string{requester}
+	if !teamGrantsEnabled {
+		return subjects, nil
+	}
+
+	teams, err := getTeamMemberships(ctx, eval, requester, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, team := range teams {
+		subjects = append(subjects, access.TeamSubject(owner, team))
+	}
+	return subjects, nil
+}
+
+/*
+*
+returns the slugs of the teams of owner holding a grant that the requester is an active
+member of. Only those teams are checked through the Teams API, once per requester and
+team, so an org's teams without grants never cost a call
+*/
+func getTeamMemberships(ctx context.Context, eval *evaluation, requester string, owner string) ([]string, error) {
+	funcLogger := eval.logger.With()
+
+	_, subSegment := xray.BeginSubsegment(ctx, "getTeamMemberships")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	slugs, err := getGrantedTeams(ctx, eval, owner)
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to list teams holding grants", zap.Error(err))
+		return nil, err
+	}
+
+	cacheKey := access.ScopedRepository(owner, requester)
+
+	now := time.Now()
+	teamCacheMutex.RLock()
+	memberships := make(map[string]bool, len(slugs))
+	for _, slug := range slugs {
+		if cached, exists := teamCache[cacheKey][slug]; exists && now.Before(cached.expiresAt) {
+			memberships[slug] = cached.member
+		}
+	}
+	teamCacheMutex.RUnlock()
+
+	checked := map[string]bool{}
+	for _, slug := range slugs {
+		if _, cached := memberships[slug]; cached {
+			continue
+		}
+
+		member, err := isActiveTeamMember(ctx, eval, owner, slug, requester)
+		if err != nil {
+			funcLogger.Errorln("error observed while trying to get team membership", zap.String("team", slug), zap.Error(err))
+			return nil, err
+		}
+		checked[slug] = member
+	}
+
+	if len(checked) > 0 {
+		expiresAt := now.Add(TEAM_MEMBERSHIP_CACHE_TTL)
+		teamCacheMutex.Lock()
+		if teamCache[cacheKey] == nil {
+			teamCache[cacheKey] = map[string]teamMembership{}
+		}
+		for slug, member := range checked {
+			teamCache[cacheKey][slug] = teamMembership{member: member, expiresAt: expiresAt}
+			memberships[slug] = member
+		}
+		teamCacheMutex.Unlock()
+	}
+
+	teams := []string{}
+	for _, slug := range slugs {
+		if memberships[slug] {
+			teams = append(teams, slug)
+		}
+	}
+
+	funcLogger.Infoln("resolved team memberships", zap.Strings("teams", teams), zap.Int("checked_count", len(checked)))
+	return teams, nil
+}
+
+/*
+*
+returns true if the requester is an active member of the team. GitHub answers 404 both
+for a non-member and for a team the token can't see (ex. a secret team without members:read),
+so a 404 is only read as non-membership once the team itself is found, a team that can't
+be resolved could hold a deny
+*/
+func isActiveTeamMember(ctx context.Context, eval *evaluation, owner string, slug string, requester string) (bool, error) {
+	membership, resp, err := eval.ghClient.Teams.GetTeamMembershipBySlug(ctx, owner, slug, requester)
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		if err != nil {
+			return false, err
+		}
+		return membership.GetState() == ACTIVE_MEMBERSHIP_STATE, nil
+	}
+
+	if _, _, err := eval.ghClient.Teams.GetTeamBySlug(ctx, owner, slug); err != nil {
+		return false, fmt.Errorf("unable to resolve team %s/%s holding a grant: %w", owner, slug, err)
+	}
+	return false, nil
+}
+
+/*
+*
+returns the lowercase slugs of the teams of owner holding a grant in the access store,
+the slugs are cached for TEAM_SUBJECTS_CACHE_TTL so new team grants are picked up
+*/
+func getGrantedTeams(ctx context.Context, eval *evaluation, owner string) ([]string, error) {
+	grantedTeamsMutex.RLock()
+	cached, exists := grantedTeamsCache[owner]
+	grantedTeamsMutex.RUnlock()
+	if exists && time.Now().Before(cached.expiresAt) {
+		return cached.slugs, nil
+	}
+
+	subjects, err := eval.store.GetTeamSubjects(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	var slugs []string
+	for _, subject := range subjects {
+		if slug, isTeam := access.TeamSlug(strings.ToLower(subject), owner); isTeam && slug != "" {
+			slugs = append(slugs, slug)
+		}
+	}
+
+	grantedTeamsMutex.Lock()
+	grantedTeamsCache[owner] = grantedTeams{slugs: slugs, expiresAt: time.Now().Add(TEAM_SUBJECTS_CACHE_TTL)}
+	grantedTeamsMutex.Unlock()
+
+	return slugs, nil
+}
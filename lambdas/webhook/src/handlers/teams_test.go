@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"slices"
+	"testing"
+	"time"
+	"webhook/access"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+
+	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
+)
+
+const (
+	team_slug = "deployers"
+)
+
+/*
+Test for case where user has no grant of their own but
+is an active member of a team with a <owner>/<repo>#<env> grant
+*/
+func TestTeamAccess(t *testing.T) {
+	// arrange
+	enableTeamGrants(t)
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedTeamsGhClient(run_id, env_name, &approved, map[string]string{team_slug: ACTIVE_MEMBERSHIP_STATE}, nil, nil)
+	accessStore = storeWithGrant(access.TeamSubject(owner_name, team_slug), access.ScopedRepository(owner_name, repo_name), env_name)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, approved, "deployment should have been approved")
+}
+
+/*
+Test for case where user's team membership is still pending,
+the team's grant should not apply
+*/
+func TestPendingTeamMembership(t *testing.T) {
+	// arrange
+	enableTeamGrants(t)
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedTeamsGhClient(run_id, env_name, &approved, map[string]string{team_slug: "pending"}, nil, nil)
+	accessStore = storeWithGrant(access.TeamSubject(owner_name, team_slug), access.ScopedRepository(owner_name, repo_name), env_name)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where user has an exact grant of their own
+but a team they belong to is denied the environment
+*/
+func TestTeamDenyOverridesUserAccess(t *testing.T) {
+	// arrange
+	enableTeamGrants(t)
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedTeamsGhClient(run_id, env_name, &approved, map[string]string{team_slug: ACTIVE_MEMBERSHIP_STATE}, nil, nil)
+	store := storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	store.Deny(access.TeamSubject(owner_name, team_slug), access.GrantKey(access.ScopedRepository(owner_name, access.WILDCARD), env_name))
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where team grants are disabled, a team's
+grant should not apply even if the user is a member
+*/
+func TestTeamGrantsDisabled(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedTeamsGhClient(run_id, env_name, &approved, map[string]string{team_slug: ACTIVE_MEMBERSHIP_STATE}, nil, nil)
+	accessStore = storeWithGrant(access.TeamSubject(owner_name, team_slug), access.ScopedRepository(owner_name, repo_name), env_name)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where only some of the org's teams hold grants, only the memberships
+of those teams should be checked and only once for the same requester
+*/
+func TestTeamMembershipChecks(t *testing.T) {
+	// arrange
+	enableTeamGrants(t)
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	checks := 0
+	ghClient = getMockedTeamsGhClient(run_id, env_name, &approved, map[string]string{team_slug: ACTIVE_MEMBERSHIP_STATE, "unused-team": ACTIVE_MEMBERSHIP_STATE}, []string{"reviewers"}, &checks)
+	store := storeWithGrant(access.TeamSubject(owner_name, team_slug), access.ScopedRepository(owner_name, repo_name), env_name)
+	store.Add(access.TeamSubject(owner_name, "reviewers"), access.GrantKey(access.ScopedRepository(owner_name, repo_name), "other-env"))
+	store.Add(access.TeamSubject("other-owner", "other-team"), access.GrantKey(access.ScopedRepository("other-owner", repo_name), env_name))
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+	subjects, subjectsErr := requesterSubjects(context.TODO(), newEvaluation(requester_name, owner_name, repo_name, run_id), requester_name, owner_name)
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, approved, "deployment should have been approved")
+	assert.Equal(t, 2, checks, "only the owner's teams holding grants should have been checked, once each")
+	assert.Nil(t, subjectsErr)
+	assert.Equal(t, []string{requester_name, access.TeamSubject(owner_name, team_slug)}, subjects)
+}
+
+/*
+Test for case where a team holding a deny can't be seen by the token, its
+membership 404s like a non-member's would, the deployment should not be approved
+*/
+func TestHiddenTeamDenyFailsClosed(t *testing.T) {
+	// arrange
+	enableTeamGrants(t)
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedTeamsGhClient(run_id, env_name, &approved, map[string]string{}, nil, nil)
+	store := storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	store.Deny(access.TeamSubject(owner_name, "secret-team"), access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name))
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.NotNil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where the requester's cached membership has gone stale,
+the membership should be checked again rather than trusted
+*/
+func TestStaleTeamMembershipRechecked(t *testing.T) {
+	// arrange
+	enableTeamGrants(t)
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	checks := 0
+	ghClient = getMockedTeamsGhClient(run_id, env_name, &approved, map[string]string{team_slug: ACTIVE_MEMBERSHIP_STATE}, nil, &checks)
+	store := storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	store.Deny(access.TeamSubject(owner_name, team_slug), access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name))
+	accessStore = store
+
+	// the requester was added to the deny team after their non-membership was cached
+	teamCache[access.ScopedRepository(owner_name, requester_name)] = map[string]teamMembership{
+		team_slug: {member: false, expiresAt: time.Now().Add(-time.Second)},
+	}
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, 1, checks, "stale membership should have been checked again")
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+enables team grants for the test, the caches are cleared
+so teams and memberships of other tests are not reused
+*/
+func enableTeamGrants(t *testing.T) {
+	resetTeamCaches := func() {
+		teamCache = map[string]map[string]teamMembership{}
+		grantedTeamsCache = map[string]grantedTeams{}
+	}
+
+	teamGrantsEnabled = true
+	resetTeamCaches()
+	t.Cleanup(func() {
+		teamGrantsEnabled = false
+		resetTeamCaches()
+	})
+}
+
+/*
+mocks the pending deployments endpoints along with the team memberships of the owner,
+memberships maps team slugs to the requester's membership state (teams missing from
+it are teams the requester is not a member of). Only the teams in memberships or
+visibleTeams are found, other teams are hidden from the token. The org's teams are not
+listed, so only the teams holding grants can be checked, checks counts them when not nil
+*/
+func getMockedTeamsGhClient(runID int64, envName string, approved *bool, memberships map[string]string, visibleTeams []string, checks *int) *github.Client {
+
+	deploymentURL := "example.com"
+
+	pendingDeployments := []*github.PendingDeployment{{
+		Environment: &github.PendingDeploymentEnvironment{ID: &runID, Name: &envName},
+	}}
+	approvedDeployments := []*github.Deployment{{
+		URL: &deploymentURL,
+	}}
+
+	mockedHTTPClient := ghMock.NewMockedHTTPClient(
+		ghMock.WithRequestMatch(
+			ghMock.GetReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			pendingDeployments),
+		ghMock.WithRequestMatchHandler(
+			ghMock.PostReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				*approved = true
+				w.Write(ghMock.MustMarshal(approvedDeployments))
+			}),
+		),
+		ghMock.WithRequestMatchHandler(
+			ghMock.GetOrgsTeamsMembershipsByOrgByTeamSlugByUsername,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if checks != nil {
+					*checks++
+				}
+
+				// path is /orgs/<org>/teams/<slug>/memberships/<username>
+				slug := path.Base(path.Dir(path.Dir(r.URL.Path)))
+				state, member := memberships[slug]
+				if !member {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Write(ghMock.MustMarshal(github.Membership{State: &state}))
+			}),
+		),
+		ghMock.WithRequestMatchHandler(
+			ghMock.GetOrgsTeamsByOrgByTeamSlug,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// path is /orgs/<org>/teams/<slug>
+				slug := path.Base(r.URL.Path)
+				if _, member := memberships[slug]; !member && !slices.Contains(visibleTeams, slug) {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Write(ghMock.MustMarshal(github.Team{Slug: &slug}))
+			}),
+		),
+	)
+
+	return github.NewClient(mockedHTTPClient)
+}
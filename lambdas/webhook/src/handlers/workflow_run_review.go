@@ -8,44 +8,56 @@ import (
 	"strings"
 	"sync"
 	"time"
-	"webhook/db"
+	"webhook/access"
+	"webhook/approval"
+	"webhook/audit"
 	gh "webhook/github"
 	"webhook/logger"
 	"webhook/util"
 
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-xray-sdk-go/xray"
 	"github.com/google/go-github/v66/github"
 	"go.uber.org/zap"
 )
 
 var (
-	logInstance *zap.SugaredLogger
-	tableName   string
-	Current     WorkflowRun
-
-	ghClient       *github.Client
-	dynamodbClient *dynamodb.Client
+	logInstance       *zap.SugaredLogger
+	legacyKeysEnabled bool
+	dryRunEnabled     bool
+
+	// clients are shared across events, clientsMutex guards setting them
+	clientsMutex  sync.RWMutex
+	ghClient      *github.Client
+	accessStore   access.AccessStore
+	auditor       audit.Auditor
+	approvalStore approval.Store
 )
 
 const (
-	TABLE_NAME_ENV_VAR_KEY = "DYNAMO_DB_TABLE_NAME"
-	TABLE_NAME_DEFAULT     = "deployment-webhooks-table"
-	REQUESTED_ACTION       = "requested"
-)
+	REQUESTED_ACTION = "requested"
 
-type WorkflowRun struct {
-	repository string
-	ID         int64
-	owner      string
-	requester  string
-}
+	PENDING_DEPLOYMENT_APPROVED_STATE = "approved"
+	PENDING_DEPLOYMENT_REJECTED_STATE = "rejected"
+
+	// legacy un-scoped <repo>#<env> keys are read as a fallback to <owner>/<repo>#<env>
+	// keys while grants are migrated, set to false once the migration is done
+	LEGACY_KEYS_ENABLED_ENV_VAR_KEY = "ACCESS_LEGACY_KEYS_ENABLED"
+	LEGACY_KEYS_ENABLED_DEFAULT     = "true"
+
+	// every event is evaluated against the real access store and GitHub, but the decisions
+	// are only logged and audited, never posted, so grant changes or a new version can be
+	// run beside manual approvals before they are trusted to approve
+	DRY_RUN_ENV_VAR_KEY = "DRY_RUN"
+	DRY_RUN_DEFAULT     = "false"
+)
 
 func init() {
 	logInstance = logger.GetLogger().Sugar()
-	tableName = util.LookupEnv(TABLE_NAME_ENV_VAR_KEY, TABLE_NAME_DEFAULT, false)
-	Current = WorkflowRun{}
+	legacyKeysEnabled = strings.ToLower(util.LookupEnv(LEGACY_KEYS_ENABLED_ENV_VAR_KEY, LEGACY_KEYS_ENABLED_DEFAULT, false)) == "true"
+	dryRunEnabled = strings.ToLower(util.LookupEnv(DRY_RUN_ENV_VAR_KEY, DRY_RUN_DEFAULT, false)) == "true"
+	if dryRunEnabled {
+		logInstance.Warnln("dry run is enabled, pending deployments will not be reviewed")
+	}
 }
 
 func HandleWorkflowRunEvent(ctx context.Context, mocking bool, event *github.WorkflowRunEvent) error {
@@ -73,32 +85,93 @@ func HandleWorkflowRunEvent(ctx context.Context, mocking bool, event *github.Wor
 		return nil
 	}
 
-	// get the requestor and their repo
+	// get the requestor, their repo and the run being evaluated
+	var requester, owner, repository string
+	var runID int64
 	if event.GetSender() != nil && event.GetSender().GetLogin() != "" {
-		Current.requester = event.GetSender().GetLogin()
+		requester = event.GetSender().GetLogin()
 	} else {
 		err := fmt.Errorf("sender or sender login from event payload is nil or empty")
 		funcLogger.Errorln("invalid field", zap.Error(err))
 		return err
 	}
 	if event.GetRepo() != nil && event.GetRepo().GetName() != "" {
-		Current.repository = event.Repo.GetName()
+		repository = event.Repo.GetName()
 	} else {
 		err := fmt.Errorf("repo or repo name from event payload is nil or empty")
 		funcLogger.Errorln("invalid field", zap.Error(err))
 		return err
 	}
+	if event.GetRepo().GetOwner() != nil && event.GetRepo().GetOwner().GetLogin() != "" {
+		owner = event.GetRepo().GetOwner().GetLogin()
+	} else {
+		err := fmt.Errorf("repo owner or repo owner login from event payload is nil or empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+	if event.GetWorkflowRun() != nil && event.GetWorkflowRun().GetID() != 0 {
+		runID = event.GetWorkflowRun().GetID()
+	} else {
+		err := fmt.Errorf("workflow run or workflow run ID from event payload is nil or empty")
+		funcLogger.Errorln("invalid field", zap.Error(err))
+		return err
+	}
+
+	eval := newEvaluation(requester, owner, repository, runID)
+	if subSegment != nil {
+		eval.withTraceID(subSegment.TraceID)
+	}
+	funcLogger = eval.logger.With()
+
+	if err := eval.useInstallationClient(ctx, mocking, event.GetInstallation().GetID()); err != nil {
+		funcLogger.Errorln("error while getting github client to handle workflow run event", zap.Error(err))
+		return err
+	}
 
-	logInstance = logInstance.With(zap.String("requester", Current.requester), zap.String("repository", Current.repository))
-	funcLogger = logInstance.With()
+	funcLogger.Infof("Processing event: %T", event)
+
+	return reviewWorkflowRun(ctx, eval)
+}
 
-	pendingDeployments, err := getPendingDeployments(ctx, event)
+/*
+*
+reviews the pending deployments of the evaluation's run, approving those the requester
+has access to and rejecting or leaving pending the others as their policies say.
+Shared by the workflow run event and the reconciler sweeping waiting runs
+*/
+func reviewWorkflowRun(ctx context.Context, eval *evaluation) error {
+	funcLogger := eval.logger.With()
+
+	// the decisions made so far are recorded even if reviewing a later deployment fails
+	defer eval.writeAudit(ctx)
+
+	pendingDeployments, err := getPendingDeployments(ctx, eval)
 	if err != nil {
 		funcLogger.Errorln("error while fetching pending deployments to handle workflow run event")
 		return err
 	}
 
-	funcLogger.Infof("Processing event: %T", event)
+	// collect the environments of every pending deployment so access is checked once for the run
+	var environments []string
+	for _, pendingDeployment := range pendingDeployments {
+		if pendingDeployment.GetEnvironment() != nil && pendingDeployment.GetEnvironment().GetName() != "" {
+			environments = append(environments, pendingDeployment.GetEnvironment().GetName())
+		}
+	}
+
+	// check if requestor (sender) has permission for repo/env
+	requesterPerms, err := requesterHasPermission(ctx, eval, environments)
+	if err != nil {
+		funcLogger.Errorln("error observed while checking if requester has permission", zap.Error(err))
+		return err
+	}
+
+	// check the deployment windows, freezes and no access settings of the environments
+	policyDecisions, err := deploymentPolicies(ctx, eval, environments)
+	if err != nil {
+		funcLogger.Errorln("error observed while checking deployment policies", zap.Error(err))
+		return err
+	}
 
 	// approve deployments for environments where requester has access
 	for _, pendingDeployment := range pendingDeployments {
@@ -111,22 +184,74 @@ func HandleWorkflowRunEvent(ctx context.Context, mocking bool, event *github.Wor
 			continue
 		}
 
-		// check if requestor (sender) has permission for repo/env
-		requesterHasPerm, err := requesterHasPermission(ctx, environment)
-		if err != nil {
-			funcLogger.Errorln("error observed while checking if requester has permission", zap.Error(err))
-			return err
+		// a deployment the requester has no access to is rejected or left pending as the policy says
+		if !requesterPerms[environment].allowed {
+			reason := noAccessReason(eval.requester, requesterPerms[environment])
+			if !policyDecisions[environment].rejectNoAccess {
+				eval.recordDecision(environment, requesterPerms[environment], audit.PENDING_DECISION, reason, 0, nil)
+				continue
+			}
+
+			funcLogger.Info("requester does not have permission, will attempt to reject pending deployment", zap.String("environment", environment))
+			comment := rejectionComment(eval, environment, requesterPerms[environment], policyDecisions[environment], reason)
+			statusCode, err := reviewPendingDeployment(ctx, eval, pendingDeployment, PENDING_DEPLOYMENT_REJECTED_STATE, comment)
+			eval.recordDecision(environment, requesterPerms[environment], audit.REJECTED_DECISION, reason, statusCode, err)
+			if err != nil {
+				funcLogger.Error("error observed while trying to reject pending deployment", zap.Error(err))
+				return err
+			}
+			continue
 		}
 
-		// approve the pending deployment if user has permission
-		if requesterHasPerm != nil && *requesterHasPerm {
-			funcLogger.Info("requester has permission, will attempt to approve pending deployment")
+		// a deployment blocked by a window or freeze is rejected or left pending as the policy says
+		if policy := policyDecisions[environment]; policy.blocked {
+			if !policy.reject {
+				funcLogger.Info("deployment is blocked by policy, leaving it pending", zap.String("environment", environment), zap.String("reason", policy.reason))
+				eval.recordDecision(environment, requesterPerms[environment], audit.PENDING_DECISION, policy.reason, 0, nil)
+				continue
+			}
 
-			err := approvePendingDeployment(ctx, pendingDeployment)
+			funcLogger.Info("deployment is blocked by policy, will attempt to reject pending deployment", zap.String("environment", environment), zap.String("reason", policy.reason))
+			comment := rejectionComment(eval, environment, requesterPerms[environment], policy, policy.reason)
+			statusCode, err := reviewPendingDeployment(ctx, eval, pendingDeployment, PENDING_DEPLOYMENT_REJECTED_STATE, comment)
+			eval.recordDecision(environment, requesterPerms[environment], audit.REJECTED_DECISION, policy.reason, statusCode, err)
 			if err != nil {
-				funcLogger.Error("error observed while trying to approve pending deployment", zap.Error(err))
+				funcLogger.Error("error observed while trying to reject pending deployment", zap.Error(err))
 				return err
 			}
+			continue
+		}
+
+		// a deployment that needs the approval of other grant holders waits for their /approve
+		// comments, unless they have already approved it (ex. approving it failed before)
+		if required := policyDecisions[environment].requiredApprovals; required > 0 {
+			quorum, err := openQuorum(ctx, eval, environment)
+			if err != nil {
+				funcLogger.Error("error observed while trying to open quorum", zap.Error(err))
+				return err
+			}
+
+			if len(quorum.Approvers) < required {
+				reason := awaitingApprovalsReason(quorum.Approvers, required)
+				funcLogger.Info("deployment requires the approval of other grant holders, leaving it pending", zap.String("environment", environment), zap.String("reason", reason))
+				eval.recordDecision(environment, requesterPerms[environment], audit.PENDING_DECISION, reason, 0, nil)
+				continue
+			}
+
+			if err := approveQuorum(ctx, eval, pendingDeployment, environment, requesterPerms[environment], policyDecisions[environment], quorum.Approvers); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// approve the pending deployment if user has permission
+		funcLogger.Info("requester has permission, will attempt to approve pending deployment", zap.String("environment", environment))
+
+		statusCode, err := approvePendingDeployment(ctx, eval, pendingDeployment, approvalComment(eval, environment, requesterPerms[environment], policyDecisions[environment], nil))
+		eval.recordDecision(environment, requesterPerms[environment], audit.APPROVED_DECISION, requesterPerms[environment].reason, statusCode, err)
+		if err != nil {
+			funcLogger.Error("error observed while trying to approve pending deployment", zap.Error(err))
+			return err
 		}
 	}
 
@@ -134,208 +259,394 @@ func HandleWorkflowRunEvent(ctx context.Context, mocking bool, event *github.Wor
 	return nil
 }
 
-func requesterHasPermission(ctx context.Context, environment string) (*bool, error) {
-	funcLogger := logInstance.With(zap.String("environment", environment))
-	funcLogger.Infoln("checking if requester has permission")
+/*
+*
+checks if the requester has permission for each of the environments,
+the returned map is keyed by the environment names as passed
+*/
+func requesterHasPermission(ctx context.Context, eval *evaluation, environments []string) (map[string]accessDecision, error) {
+	return loginHasPermission(ctx, eval, eval.requester, environments)
+}
 
-	_, subSegment := xray.BeginSubsegment(ctx, "RequesterHasPermission")
+/*
+*
+checks if the login (the requester or a grant holder approving for them) has permission
+for each of the environments, the returned map is keyed by the environment names as passed
+*/
+func loginHasPermission(ctx context.Context, eval *evaluation, login string, environments []string) (map[string]accessDecision, error) {
+	funcLogger := eval.logger.With(zap.String("login", login), zap.Strings("environments", environments))
+	funcLogger.Infoln("checking if login has permission")
+
+	_, subSegment := xray.BeginSubsegment(ctx, "LoginHasPermission")
 	if subSegment != nil {
 		traceID := subSegment.TraceID
-		funcLogger = logInstance.With(zap.String("traceID", traceID))
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
 		defer subSegment.Close(nil)
 	}
 
-	hasAccess, err := checkRequesterAccess(ctx, strings.ToLower(Current.requester), strings.ToLower(Current.repository), strings.ToLower(environment))
+	lowerEnvironments := make([]string, 0, len(environments))
+	for _, environment := range environments {
+		lowerEnvironments = append(lowerEnvironments, strings.ToLower(environment))
+	}
+
+	envAccess, err := checkRequesterAccess(ctx, eval, strings.ToLower(login), strings.ToLower(eval.owner), strings.ToLower(eval.repository), lowerEnvironments)
 	if err != nil {
 		funcLogger.Errorln("error observed while checking request access", zap.Error(err))
 		return nil, err
 	}
 
-	return hasAccess, nil
+	hasPermission := make(map[string]accessDecision, len(environments))
+	for _, environment := range environments {
+		hasPermission[environment] = envAccess[strings.ToLower(environment)]
+	}
+
+	return hasPermission, nil
+}
+
+/*
+*
+an access level and the grant key that gives a requester that level of access,
+legacy levels are those of un-scoped <repo>#<env> keys
+*/
+type accessLevel struct {
+	name   string
+	key    string
+	legacy bool
 }
 
 /*
 *
-concurrently checks requester access across three levels
-Exact access -> requester has access to the exact repo and environment
-Repo access -> requester has access to a repo and all its environments (<repo>#<env> -> <repo>#*)
-Env access -> requester has access to an env across all repos (<repo>#<env> -> *#<env>)
-Org access -> requester has access to an org, so all repos and all environments (<repo>#<env> -> *#*)
+returns the grant keys, from most to least specific, that give access to the environment
+Exact access -> requester has access to the exact repo and environment (<owner>/<repo>#<env>)
+Repo access -> requester has access to a repo and all its environments (<owner>/<repo>#<env> -> <owner>/<repo>#*)
+Env access -> requester has access to an env across all repos of the owner (<owner>/<repo>#<env> -> <owner>/*#<env>)
+Org access -> requester has access to an org, so all repos and all environments (<owner>/<repo>#<env> -> <owner>/*#*)
+while legacy keys are enabled, the same four levels of un-scoped keys follow as a fallback
+(<repo>#<env>, <repo>#*, *#<env>, *#*)
 *
 */
-func checkRequesterAccess(ctx context.Context, requester string, repository string, environment string) (*bool, error) {
-	funcLogger := logInstance.With()
+func accessLevels(owner string, repository string, environment string) []accessLevel {
+	scopedRepository := access.ScopedRepository(owner, repository)
+	orgRepositories := access.ScopedRepository(owner, access.WILDCARD)
+
+	levels := []accessLevel{
+		{name: "exact", key: access.GrantKey(scopedRepository, environment)},
+		{name: "repo", key: access.GrantKey(scopedRepository, access.WILDCARD)},
+		{name: "environment", key: access.GrantKey(orgRepositories, environment)},
+		{name: "org", key: access.GrantKey(orgRepositories, access.WILDCARD)},
+	}
 
-	_, subSegment := xray.BeginSubsegment(ctx, "checkRequesterAccess")
-	if subSegment != nil {
-		traceID := subSegment.TraceID
-		funcLogger = logInstance.With(zap.String("traceID", traceID))
-		defer subSegment.Close(nil)
+	if legacyKeysEnabled {
+		levels = append(levels,
+			accessLevel{name: "legacy exact", key: access.GrantKey(repository, environment), legacy: true},
+			accessLevel{name: "legacy repo", key: access.GrantKey(repository, access.WILDCARD), legacy: true},
+			accessLevel{name: "legacy environment", key: access.GrantKey(access.WILDCARD, environment), legacy: true},
+			accessLevel{name: "legacy org", key: access.GrantKey(access.WILDCARD, access.WILDCARD), legacy: true},
+		)
 	}
 
-	accessCheck := make(chan *bool, 4) // channel to store access checks
-	errChan := make(chan error, 4)     // chanel to store errors
+	return levels
+}
 
-	var wg sync.WaitGroup
-	wg.Add(4)
+/*
+*
+a grant held by the requester that applies to an environment, and the level it applies at
+*/
+type grantMatch struct {
+	level  string
+	grant  access.Grant
+	legacy bool
+}
 
-	go func() {
-		defer wg.Done()
-		// checks if requester has exact access to a specific environment in a repository
-		exactAccessInput := &dynamodb.GetItemInput{
-			TableName: &tableName,
-			Key: map[string]types.AttributeValue{
-				"login":    &types.AttributeValueMemberS{Value: requester},
-				"repo-env": &types.AttributeValueMemberS{Value: strings.Join([]string{repository, environment}, "#")},
-			},
+/*
+*
+returns the grants that apply to the environment in order of precedence
+exact grant -> pattern grants (sorted by key) -> repo, environment and org wildcard grants
+owner scoped grants come first, followed by legacy un-scoped grants in the same order.
+At each level the requester's own grant comes before grants of the requester's teams
+*
+*/
+func matchGrants(grants map[string][]access.Grant, patternGrants []access.Grant, owner string, repository string, environment string) []grantMatch {
+	levels := accessLevels(owner, repository, environment)
+
+	var matches []grantMatch
+	for _, legacy := range []bool{false, true} {
+		var scopeLevels []accessLevel
+		for _, level := range levels {
+			if level.legacy == legacy {
+				scopeLevels = append(scopeLevels, level)
+			}
 		}
-		requesterHasExactAccess, err := checkAccessByInput(ctx, exactAccessInput)
-		if err != nil {
-			errChan <- err
-			funcLogger.Errorln("error observed while trying to check if requester has exact access", zap.Error(err))
-			return
+		if len(scopeLevels) == 0 {
+			continue
 		}
-		if requesterHasExactAccess != nil && *requesterHasExactAccess {
-			funcLogger.Infoln("requester has exact access")
-			accessCheck <- requesterHasExactAccess
+
+		for _, grant := range grants[scopeLevels[0].key] {
+			matches = append(matches, grantMatch{level: scopeLevels[0].name, grant: grant, legacy: legacy})
+		}
+		for _, patternGrant := range patternGrants {
+			if access.IsScoped(patternGrant.Key) == legacy {
+				continue
+			}
+			if access.MatchPattern(patternGrant.Key, owner, repository, environment) {
+				level := "pattern"
+				if legacy {
+					level = "legacy pattern"
+				}
+				matches = append(matches, grantMatch{level: level, grant: patternGrant, legacy: legacy})
+			}
+		}
+		for _, level := range scopeLevels[1:] {
+			for _, grant := range grants[level.key] {
+				matches = append(matches, grantMatch{level: level.name, grant: grant, legacy: legacy})
+			}
 		}
+	}
 
-	}()
+	return matches
+}
 
-	go func() {
-		defer wg.Done()
+/*
+*
+the outcome of checking a requester's access to an environment, level and key
+are those of the grant that decided it (if any)
+*/
+type accessDecision struct {
+	allowed bool
+	denied  bool
+	legacy  bool
+	level   string
+	key     string
+	subject string
+	reason  string
+}
 
-		// checks if requester has repo access (and thus access to all environments)
-		repoAccessInput := &dynamodb.GetItemInput{
-			TableName: &tableName,
-			Key: map[string]types.AttributeValue{
-				"login":    &types.AttributeValueMemberS{Value: requester},
-				"repo-env": &types.AttributeValueMemberS{Value: strings.Join([]string{repository, "*"}, "#")},
-			},
+/*
+*
+decides access from the matching grants, a deny at any level beats an allow at any level.
+The first deny or allow in order of precedence is reported, so the decision is the same
+no matter which order the store returned the grants in.
+*/
+func decideAccess(matches []grantMatch, exactKey string) accessDecision {
+	var allow *grantMatch
+	for i, match := range matches {
+		if match.grant.Denies() {
+			return accessDecision{denied: true, legacy: match.legacy, level: match.level, key: match.grant.Key, subject: match.grant.Login, reason: "deny grant " + match.grant.Key}
 		}
-		requesterHasRepoAccess, err := checkAccessByInput(ctx, repoAccessInput)
-		if err != nil {
-			errChan <- err
-			funcLogger.Errorln("error observed while trying to check if requester has repo level access", zap.Error(err))
-			return
+		if allow == nil {
+			allow = &matches[i]
 		}
-		if requesterHasRepoAccess != nil && *requesterHasRepoAccess {
-			funcLogger.Infoln("requester has repo access")
-			accessCheck <- requesterHasRepoAccess
+	}
+
+	if allow != nil {
+		return accessDecision{allowed: true, legacy: allow.legacy, level: allow.level, key: allow.grant.Key, subject: allow.grant.Login, reason: "allow grant " + allow.grant.Key}
+	}
+	return accessDecision{reason: "no grant for " + exactKey}
+}
+
+/*
+*
+checks requester access to every environment across the exact, pattern and wildcard levels,
+the candidate keys of all environments and of the requester and their teams are looked up
+in a single call to the access store while their pattern grants are listed alongside it
+*
+*/
+func checkRequesterAccess(ctx context.Context, eval *evaluation, requester string, owner string, repository string, environments []string) (map[string]accessDecision, error) {
+	funcLogger := eval.logger.With()
+
+	_, subSegment := xray.BeginSubsegment(ctx, "checkRequesterAccess")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	// a team that can't be resolved could hold a deny, so fail rather than skip it
+	subjects, err := requesterSubjects(ctx, eval, requester, owner)
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to resolve requester teams", zap.Error(err))
+		return nil, err
+	}
+
+	var keys []string
+	for _, environment := range environments {
+		for _, level := range accessLevels(owner, repository, environment) {
+			keys = append(keys, level.key)
 		}
-	}()
+	}
+
+	var grants map[string][]access.Grant
+	var patternGrants []access.Grant
+	var grantsErr, patternErr error
+
+	var wg sync.WaitGroup
+	wg.Add(2)
 
 	go func() {
 		defer wg.Done()
-
-		// checks if requester has org access (access to all repos and all environments)
-		orgAccessInput := &dynamodb.GetItemInput{
-			TableName: &tableName,
-			Key: map[string]types.AttributeValue{
-				"login":    &types.AttributeValueMemberS{Value: requester},
-				"repo-env": &types.AttributeValueMemberS{Value: strings.Join([]string{"*", "*"}, "#")},
-			},
-		}
-		requesterHasOrgAccess, err := checkAccessByInput(ctx, orgAccessInput)
-		if err != nil {
-			errChan <- err
-			funcLogger.Errorln("error observed while trying to check if requester has org access", zap.Error(err))
-			return
-		}
-		if requesterHasOrgAccess != nil && *requesterHasOrgAccess {
-			funcLogger.Infoln("requester has org access")
-			accessCheck <- requesterHasOrgAccess
-		}
+		grants, grantsErr = checkAccessByInput(ctx, eval, subjects, keys)
 	}()
 
 	go func() {
 		defer wg.Done()
-
-		// checks if requester has environment access (across all repos)
-		repoAccessInput := &dynamodb.GetItemInput{
-			TableName: &tableName,
-			Key: map[string]types.AttributeValue{
-				"login":    &types.AttributeValueMemberS{Value: requester},
-				"repo-env": &types.AttributeValueMemberS{Value: strings.Join([]string{"*", environment}, "#")},
-			},
-		}
-		requesterHasRepoAccess, err := checkAccessByInput(ctx, repoAccessInput)
-		if err != nil {
-			errChan <- err
-			funcLogger.Errorln("error observed while trying to check if requester has environment level access", zap.Error(err))
-			return
-		}
-		if requesterHasRepoAccess != nil && *requesterHasRepoAccess {
-			funcLogger.Infoln("requester has environment access")
-			accessCheck <- requesterHasRepoAccess
-		}
+		patternGrants, patternErr = checkPatternAccess(ctx, eval, subjects)
 	}()
 
 	wg.Wait()
-	close(accessCheck)
-	close(errChan)
-
-	var firstErr error
-	for {
-		select { // read from error and access chanel
-		case result, ok := <-accessCheck:
-			if ok && result != nil && *result {
-				funcLogger.Infoln("requester has access")
-				return result, nil // determined access, exit early
-			}
-		case err, ok := <-errChan:
-			if ok && err != nil {
-				if firstErr == nil {
-					firstErr = err // save first err as err to return
-				}
-			}
+
+	if err := errors.Join(grantsErr, patternErr); err != nil {
+		funcLogger.Errorln("error observed while trying to check requester access", zap.Error(err))
+		return nil, err
+	}
+
+	requesterAccess := make(map[string]accessDecision, len(environments))
+	for _, environment := range environments {
+		matches := matchGrants(grants, patternGrants, owner, repository, environment)
+		decision := decideAccess(matches, access.GrantKey(access.ScopedRepository(owner, repository), environment))
+		requesterAccess[environment] = decision
+
+		envLogger := funcLogger.With(zap.String("environment", environment), zap.String("reason", decision.reason))
+		switch {
+		case decision.allowed:
+			envLogger.Infoln("requester has access", zap.String("access_level", decision.level), zap.String("grant", decision.key), zap.String("subject", decision.subject))
+		case decision.denied:
+			envLogger.Infoln("requester was denied access", zap.String("access_level", decision.level), zap.String("grant", decision.key), zap.String("subject", decision.subject))
+		default:
+			envLogger.Infoln("requester did not have access")
 		}
 
-		// if we've reviewed all the access we can
-		// AND we've reviewed all the errors
-		// then break
-		if len(accessCheck) == 0 && (len(errChan) == 0) {
-			break
+		if decision.legacy {
+			envLogger.Warnln("access was decided by a legacy un-scoped grant, migrate it to an <owner>/<repo>#<env> key", zap.String("grant", decision.key))
 		}
 	}
 
-	// requester did not have any access, return false
-	requesterAccess := false
-	funcLogger.Infoln("requester did not have access")
-	return &requesterAccess, nil
+	return requesterAccess, nil
 }
 
-func checkAccessByInput(ctx context.Context, input *dynamodb.GetItemInput) (*bool, error) {
-	funcLogger := logInstance.With()
+/*
+*
+lists the pattern grants of the subjects in the access store
+*/
+func checkPatternAccess(ctx context.Context, eval *evaluation, subjects []string) ([]access.Grant, error) {
+	funcLogger := eval.logger.With()
+
+	_, subSegment := xray.BeginSubsegment(ctx, "checkPatternAccess")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	patternGrants, err := eval.store.GetPatternGrants(ctx, subjects)
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to get pattern grants from access store", zap.Error(err))
+		return nil, err
+	}
+	return validGrants(funcLogger, patternGrants, time.Now()), nil
+}
+
+/*
+*
+looks up the keys for the subjects in the access store, returns the grants held
+by the subjects keyed by grant key, in the order the subjects were passed
+*/
+func checkAccessByInput(ctx context.Context, eval *evaluation, subjects []string, keys []string) (map[string][]access.Grant, error) {
+	funcLogger := eval.logger.With(zap.Strings("subjects", subjects), zap.Strings("keys", keys))
 
 	_, subSegment := xray.BeginSubsegment(ctx, "checkAccessByInput")
 	if subSegment != nil {
 		traceID := subSegment.TraceID
-		funcLogger = logInstance.With(zap.String("traceID", traceID))
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
 		defer subSegment.Close(nil)
 	}
 
-	result, err := dynamodbClient.GetItem(ctx, input)
+	grants, err := eval.store.GetGrants(ctx, subjects, keys)
 	if err != nil {
-		errMsg := "error observed while trying to get dynamodb item"
-		funcLogger.Errorln(errMsg, zap.Error(err), zap.Any("input", *input))
+		funcLogger.Errorln("error observed while trying to get grants from access store", zap.Error(err))
 		return nil, err
 	}
-	hasAccess := (result.Item != nil)
-	return &hasAccess, nil
+
+	// stores don't promise an order, group the grants by subject so the requester's own come first
+	grants = validGrants(funcLogger, grants, time.Now())
+	heldGrants := make(map[string][]access.Grant, len(grants))
+	for _, subject := range subjects {
+		for _, grant := range grants {
+			if grant.Login == subject {
+				heldGrants[grant.Key] = append(heldGrants[grant.Key], grant)
+			}
+		}
+	}
+	return heldGrants, nil
+}
+
+/*
+*
+drops the grants that are expired or not yet valid at the time passed, dropped grants
+are logged so they can be told apart from grants that were never held
+*/
+func validGrants(funcLogger *zap.SugaredLogger, grants []access.Grant, now time.Time) []access.Grant {
+	valid := make([]access.Grant, 0, len(grants))
+	for _, grant := range grants {
+		validity := grant.ValidityAt(now)
+		if validity == access.GRANT_VALID {
+			valid = append(valid, grant)
+			continue
+		}
+
+		grantLogger := funcLogger.With(zap.String("subject", grant.Login), zap.String("grant", grant.Key), zap.String("effect", grant.Effect))
+		switch validity {
+		case access.GRANT_EXPIRED:
+			grantLogger.Infoln("ignoring expired grant", zap.Time("not_after", time.Unix(grant.NotAfter, 0)))
+		case access.GRANT_NOT_YET_VALID:
+			grantLogger.Infoln("ignoring grant that is not yet valid", zap.Time("not_before", time.Unix(grant.NotBefore, 0)))
+		}
+	}
+	return valid
+}
+
+/*
+*
+checks the deployment policies of the environments as passed, the
+returned map is keyed by the environment names as passed
+*/
+func deploymentPolicies(ctx context.Context, eval *evaluation, environments []string) (map[string]policyDecision, error) {
+	lowerEnvironments := make([]string, 0, len(environments))
+	for _, environment := range environments {
+		lowerEnvironments = append(lowerEnvironments, strings.ToLower(environment))
+	}
+
+	decisions, err := checkDeploymentPolicies(ctx, eval, strings.ToLower(eval.owner), strings.ToLower(eval.repository), lowerEnvironments)
+	if err != nil {
+		return nil, err
+	}
+
+	envDecisions := make(map[string]policyDecision, len(environments))
+	for _, environment := range environments {
+		envDecisions[environment] = decisions[strings.ToLower(environment)]
+	}
+	return envDecisions, nil
 }
 
 /*
 *
 approves the pending deployment passed as user has access
 */
-func approvePendingDeployment(ctx context.Context, pendingDeployment *github.PendingDeployment) error {
-	funcLogger := logInstance.With()
+func approvePendingDeployment(ctx context.Context, eval *evaluation, pendingDeployment *github.PendingDeployment, comment string) (int, error) {
+	return reviewPendingDeployment(ctx, eval, pendingDeployment, PENDING_DEPLOYMENT_APPROVED_STATE, comment)
+}
 
-	_, subSegment := xray.BeginSubsegment(ctx, "approvePendingDeployment")
+/*
+*
+approves or rejects the pending deployment passed with the comment,
+the status code of GitHub's response is returned (0 if GitHub wasn't called)
+*/
+func reviewPendingDeployment(ctx context.Context, eval *evaluation, pendingDeployment *github.PendingDeployment, state string, comment string) (int, error) {
+	funcLogger := eval.logger.With(zap.String("state", state))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "reviewPendingDeployment")
 	if subSegment != nil {
 		traceID := subSegment.TraceID
-		funcLogger = logInstance.With(zap.String("traceID", traceID))
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
 		defer subSegment.Close(nil)
 	}
 
@@ -346,17 +657,29 @@ func approvePendingDeployment(ctx context.Context, pendingDeployment *github.Pen
 		errMsg := "environment or environment ID from pending deployment payload is nil or empty"
 		err := errors.New(errMsg)
 		funcLogger.Errorln(errMsg, zap.Error(err))
-		return err
+		return 0, err
 	}
 
 	funcLogger = funcLogger.With(zap.Int64("envID", envID))
 
-	req := github.PendingDeploymentsRequest{EnvironmentIDs: []int64{envID}, State: "approved", Comment: "Approved via Go GitHub Webhook Lambda! 🚀"}
+	req := github.PendingDeploymentsRequest{EnvironmentIDs: []int64{envID}, State: state, Comment: comment}
 
-	approvedDeployments, approvalResp, approvalErr := ghClient.Actions.PendingDeployments(ctx, Current.owner, Current.repository, Current.ID, &req)
-	if approvalResp.Response.StatusCode != http.StatusOK || approvalErr != nil {
-		funcLogger.Error("error or incorrect status code observed while approving deployments", zap.Error(approvalErr), zap.Int("status_code", approvalResp.Response.StatusCode))
-		return approvalErr
+	if eval.dryRun {
+		funcLogger.Infoln("dry run, not reviewing pending deployment", zap.String("comment", comment))
+		return 0, nil
+	}
+
+	approvedDeployments, approvalResp, approvalErr := eval.ghClient.Actions.PendingDeployments(ctx, eval.owner, eval.repository, eval.runID, &req)
+	statusCode := 0
+	if approvalResp != nil {
+		statusCode = approvalResp.StatusCode
+	}
+	if approvalErr != nil || statusCode != http.StatusOK {
+		funcLogger.Error("error or incorrect status code observed while reviewing deployments", zap.Error(approvalErr), zap.Int("status_code", statusCode))
+		if approvalErr == nil {
+			approvalErr = fmt.Errorf("incorrect status code %d observed while reviewing deployments", statusCode)
+		}
+		return statusCode, approvalErr
 	}
 
 	var approvedDeploymentsURLs []string
@@ -366,45 +689,25 @@ func approvePendingDeployment(ctx context.Context, pendingDeployment *github.Pen
 		}
 	}
 
-	funcLogger.Infoln("approved deployments", zap.Strings("deployment_urls", approvedDeploymentsURLs))
+	funcLogger.Infoln("reviewed deployments", zap.Strings("deployment_urls", approvedDeploymentsURLs))
 
-	return nil
+	return statusCode, nil
 }
 
-func getPendingDeployments(ctx context.Context, event *github.WorkflowRunEvent) ([]*github.PendingDeployment, error) {
-	funcLogger := logInstance.With()
+func getPendingDeployments(ctx context.Context, eval *evaluation) ([]*github.PendingDeployment, error) {
+	funcLogger := eval.logger.With()
 
 	_, subSegment := xray.BeginSubsegment(ctx, "getPendingDeployments")
 	if subSegment != nil {
 		traceID := subSegment.TraceID
-		funcLogger = logInstance.With(zap.String("traceID", traceID))
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
 		defer subSegment.Close(nil)
 	}
 
-	if event.GetRepo() != nil && event.GetRepo().GetOwner() != nil && event.GetRepo().GetOwner().GetLogin() != "" {
-		Current.owner = event.GetRepo().GetOwner().GetLogin()
-	} else {
-		err := fmt.Errorf("repo or repo owner or repo owner login from pending deployment is nil or empty")
-		funcLogger.Errorln(err.Error())
-		return nil, err
-	}
+	retryPolicy := gh.GetRetryPolicy()
+	for attempt := 1; ; attempt++ {
 
-	if event.GetWorkflowRun() != nil && event.GetWorkflowRun().GetID() != 0 {
-		Current.ID = event.GetWorkflowRun().GetID()
-	} else {
-		err := fmt.Errorf("workflow run or workflow run ID from pending deployment is nil or empty")
-		funcLogger.Errorln(err.Error())
-		return nil, err
-	}
-
-	funcLogger = funcLogger.With(zap.String("owner", Current.owner), zap.Int64("runID", Current.ID))
-
-	maxRetries := 3
-	retryDelay := 1
-
-	for i := 0; i < maxRetries; i++ {
-
-		pendingDeployments, resp, err := ghClient.Actions.GetPendingDeployments(ctx, Current.owner, Current.repository, Current.ID)
+		pendingDeployments, resp, err := eval.ghClient.Actions.GetPendingDeployments(ctx, eval.owner, eval.repository, eval.runID)
 		if err != nil || resp.StatusCode != http.StatusOK {
 			funcLogger.Errorln("error or incorrect status code while fetching pending deployments", zap.Error(err))
 			return nil, err
@@ -414,47 +717,98 @@ func getPendingDeployments(ctx context.Context, event *github.WorkflowRunEvent)
 			return pendingDeployments, nil
 		}
 
-		funcLogger.Warnln("No pending deployments found, retrying...", zap.Int("attempt", i+1), zap.Int("nextDelay", retryDelay))
-
-		time.Sleep(time.Second * time.Duration(retryDelay))
-		retryDelay ^= 2 // exponential backoff
+		// the run's pending deployments may not have been created yet
+		retryDelay := retryPolicy.Backoff(attempt)
+		if err := retryPolicy.Wait(ctx, attempt, retryDelay); err != nil {
+			funcLogger.Errorln("no pending deployments found", zap.Int("attempt", attempt), zap.Error(err))
+			return nil, fmt.Errorf("no pending deployments found after %d attempts: %w", attempt, err)
+		}
+		funcLogger.Warnln("no pending deployments found, retrying", zap.Int("attempt", attempt), zap.Duration("delay", retryDelay))
 	}
-
-	return nil, fmt.Errorf("no pending deployments found after %d retries", maxRetries)
 }
 
 func setupClients(ctx context.Context) error {
-	ghClientErr := setGhClient(ctx)
-	if ghClientErr != nil {
-		return ghClientErr
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	storeErr := setAccessStore(ctx)
+	if storeErr != nil {
+		return storeErr
+	}
+
+	if auditErr := setAuditor(ctx); auditErr != nil {
+		return auditErr
 	}
 
-	dbClientErr := setDbClient(ctx)
-	if dbClientErr != nil {
-		return dbClientErr
+	return setApprovalStore(ctx)
+}
+
+func setAccessStore(ctx context.Context) error {
+	store, err := access.GetAccessStore(ctx)
+	if err != nil {
+		logInstance.Errorln("error observed while trying to get access store", zap.Error(err))
+		return err
 	}
 
+	accessStore = store
 	return nil
 }
 
-func setGhClient(ctx context.Context) error {
-	client, err := gh.GetGitHubClient(ctx)
+func setAuditor(ctx context.Context) error {
+	auditLog, err := audit.GetAuditor(ctx)
 	if err != nil {
-		logInstance.Errorln("error while getting github client to handle workflow run review", zap.Error(err))
+		logInstance.Errorln("error observed while trying to get auditor", zap.Error(err))
 		return err
 	}
 
-	ghClient = client
+	auditor = auditLog
 	return nil
 }
 
-func setDbClient(ctx context.Context) error {
-	client, err := db.GetDynamoClient(ctx)
+func setApprovalStore(ctx context.Context) error {
+	store, err := approval.GetStore(ctx)
 	if err != nil {
-		logInstance.Errorln("error observed while trying to get dynamodb client", zap.Error(err))
+		logInstance.Errorln("error observed while trying to get approval store", zap.Error(err))
 		return err
 	}
 
-	dynamodbClient = client
+	approvalStore = store
 	return nil
 }
+
+/*
+returns the shared github client, this is the stubbed client when mocking
+as real events use the client of their installation (see useInstallationClient)
+*/
+func getGhClient() *github.Client {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	return ghClient
+}
+
+/*
+returns the shared access store, safe to call while clients are being set up
+*/
+func getAccessStore() access.AccessStore {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	return accessStore
+}
+
+/*
+returns the shared auditor, nil when mocking without a stubbed auditor
+*/
+func getAuditor() audit.Auditor {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	return auditor
+}
+
+/*
+returns the shared approval store, nil when mocking without a stubbed store
+*/
+func getApprovalStore() approval.Store {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	return approvalStore
+}
@@ -2,22 +2,21 @@ package handlers
 
 import (
 	"context"
-	"strings"
+	"net/http"
 	"testing"
+	"time"
+	"webhook/access"
+	"webhook/audit"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/awsdocs/aws-doc-sdk-examples/gov2/testtools"
 	"github.com/google/go-github/v66/github"
 	"github.com/stretchr/testify/assert"
 
-	"github.com/awsdocs/aws-doc-sdk-examples/gov2/testtools"
 	ghMock "github.com/migueleliasweb/go-github-mock/src/mock"
 )
 
-var (
-	stubber *testtools.AwsmStubber
-)
-
 const (
 	repo_name      = "test-repo"
 	env_name       = "test-env"
@@ -26,12 +25,6 @@ const (
 	run_id         = int64(123456)
 )
 
-func init() {
-	// set up dynamodb client from stubber
-	stubber = testtools.NewStubber()
-	dynamodbClient = dynamodb.NewFromConfig(*stubber.SdkConfig)
-}
-
 /*
 Test for case where user has no access based on
 */
@@ -39,14 +32,16 @@ func TestNoAccess(t *testing.T) {
 	// arrange
 	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
 
-	ghClient = getMockedGhClient(run_id, env_name)
-	stubGetItem(requester_name, repo_name, env_name, false)
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = access.NewMemoryStore()
 
 	// act
 	err := HandleWorkflowRunEvent(context.TODO(), true, event)
 
 	// assert
 	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
 }
 
 /*
@@ -57,14 +52,16 @@ func TestExactAccess(t *testing.T) {
 	// arrange
 	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
 
-	ghClient = getMockedGhClient(run_id, env_name)
-	stubGetItem(requester_name, repo_name, env_name, true)
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, repo_name, env_name)
 
 	// act
 	err := HandleWorkflowRunEvent(context.TODO(), true, event)
 
 	// assert
 	assert.Nil(t, err)
+	assert.True(t, approved, "deployment should have been approved")
 }
 
 /*
@@ -73,19 +70,18 @@ table entry of *#*
 */
 func TestOrgAccess(t *testing.T) {
 	// arrange
-	repo_name := "*"
-	env_name := "*"
-
 	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
 
-	ghClient = getMockedGhClient(run_id, env_name)
-	stubGetItem(requester_name, repo_name, env_name, true)
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, access.WILDCARD, access.WILDCARD)
 
 	// act
 	err := HandleWorkflowRunEvent(context.TODO(), true, event)
 
 	// assert
 	assert.Nil(t, err)
+	assert.True(t, approved, "deployment should have been approved")
 }
 
 /*
@@ -94,18 +90,18 @@ table entry of <repo>#*
 */
 func TestRepoAccess(t *testing.T) {
 	// arrange
-	env_name := "*"
-
 	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
 
-	ghClient = getMockedGhClient(run_id, env_name)
-	stubGetItem(requester_name, repo_name, env_name, true)
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, repo_name, access.WILDCARD)
 
 	// act
 	err := HandleWorkflowRunEvent(context.TODO(), true, event)
 
 	// assert
 	assert.Nil(t, err)
+	assert.True(t, approved, "deployment should have been approved")
 }
 
 /*
@@ -114,45 +110,406 @@ table entry of *#<env>
 */
 func TestEnvAccess(t *testing.T) {
 	// arrange
-	repo_name := "*"
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, access.WILDCARD, env_name)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, approved, "deployment should have been approved")
+}
 
+/*
+Test for case where user has access to another environment
+in the repo, but not the pending one
+*/
+func TestOtherEnvAccess(t *testing.T) {
+	// arrange
 	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
 
-	ghClient = getMockedGhClient(run_id, env_name)
-	stubGetItem(requester_name, repo_name, env_name, true)
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, repo_name, "other-env")
 
 	// act
 	err := HandleWorkflowRunEvent(context.TODO(), true, event)
 
 	// assert
 	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
 }
 
-func stubGetItem(requester string, repo string, env string, itemExists bool) {
+/*
+Test for case where user has access based on table entry of *#*
+but is denied the exact <repo>#<env>, deny should win
+*/
+func TestDenyOverridesOrgAccess(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
 
-	entry := map[string]types.AttributeValue{
-		"login":    &types.AttributeValueMemberS{Value: requester},
-		"repo-env": &types.AttributeValueMemberS{Value: strings.Join([]string{repo, env}, "#")},
-	}
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	store := storeWithGrant(requester_name, access.WILDCARD, access.WILDCARD)
+	store.Deny(requester_name, access.GrantKey(repo_name, env_name))
+	accessStore = store
 
-	tableName := TABLE_NAME_DEFAULT
-	input := &dynamodb.GetItemInput{TableName: &tableName, Key: entry}
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
 
-	var output *dynamodb.GetItemOutput = nil
-	if itemExists {
-		output = &dynamodb.GetItemOutput{Item: entry}
-	}
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where user has exact access to <repo>#<env>
+but is denied *#<env>, a less specific deny should still win
+*/
+func TestEnvDenyOverridesExactAccess(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	store := storeWithGrant(requester_name, repo_name, env_name)
+	store.Deny(requester_name, access.GrantKey(access.WILDCARD, env_name))
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where user has access based on
+a pattern table entry such as test-*#test-*
+*/
+func TestPatternAccess(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, "test-*", "test-*")
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, approved, "deployment should have been approved")
+}
+
+/*
+Test for case where user has access based on table entry
+of *#* but is denied by a pattern table entry
+*/
+func TestPatternDenyOverridesOrgAccess(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	store := storeWithGrant(requester_name, access.WILDCARD, access.WILDCARD)
+	store.Deny(requester_name, access.GrantKey(access.WILDCARD, "test-*"))
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where user has access based on table entry of <owner>/<repo>#<env>
+but is denied the repository across every owner with a * owner grant
+*/
+func TestWildcardOwnerDenyOverridesExactAccess(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
 
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	store := storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+	store.Deny(requester_name, access.GrantKey(access.ScopedRepository(access.WILDCARD, repo_name), access.WILDCARD))
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where user has access based on a table entry of <owner>/*#* but the
+DynamoDB table also holds a malformed deny pattern for them, the deployment should
+not be approved
+*/
+func TestInvalidPatternDenyFailsClosed(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+
+	denyItem := map[string]types.AttributeValue{
+		access.LOGIN_ATTRIBUTE:    &types.AttributeValueMemberS{Value: requester_name},
+		access.REPO_ENV_ATTRIBUTE: &types.AttributeValueMemberS{Value: access.GrantKey(access.ScopedRepository(owner_name, "test-[repo"), env_name)},
+		access.EFFECT_ATTRIBUTE:   &types.AttributeValueMemberS{Value: access.EFFECT_DENY},
+	}
+	stubber := testtools.NewStubber()
 	stubber.Add(testtools.Stub{
-		OperationName: "GetItem",
-		Input:         input,
-		Output:        output,
+		OperationName: "Query",
+		Input:         &dynamodb.QueryInput{},
+		IgnoreFields:  []string{"TableName", "KeyConditionExpression", "FilterExpression", "ExpressionAttributeNames", "ExpressionAttributeValues"},
+		Output:        &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{denyItem}},
 		SkipErrorTest: true,
-		Error:         nil,
 	})
+	accessStore = patternGrantsStore{
+		MemoryStore: storeWithGrant(requester_name, access.ScopedRepository(owner_name, access.WILDCARD), access.WILDCARD),
+		patterns:    access.NewDynamoDBStore(dynamodb.NewFromConfig(*stubber.SdkConfig), access.TABLE_NAME_DEFAULT),
+	}
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.NotNil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+	assert.Nil(t, stubber.VerifyAllStubsCalled())
+}
+
+/*
+patternGrantsStore reads exact and wildcard grants from a memory store and pattern
+grants from another store, so the pattern lookup can be stubbed on its own
+*/
+type patternGrantsStore struct {
+	*access.MemoryStore
+	patterns access.AccessStore
+}
+
+func (s patternGrantsStore) GetPatternGrants(ctx context.Context, logins []string) ([]access.Grant, error) {
+	return s.patterns.GetPatternGrants(ctx, logins)
+}
+
+/*
+Test for case where user has access based on
+table entry of <owner>/<repo>#<env>
+*/
+func TestScopedExactAccess(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, access.ScopedRepository(owner_name, repo_name), env_name)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, approved, "deployment should have been approved")
+}
+
+/*
+Test for case where user has access based on
+table entry of <owner>/*#*
+*/
+func TestScopedOrgAccess(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, access.ScopedRepository(owner_name, access.WILDCARD), access.WILDCARD)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, approved, "deployment should have been approved")
+}
+
+/*
+Test for case where user has access to the same
+<repo>#<env> but in another organization
+*/
+func TestScopedAccessOtherOwner(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, access.ScopedRepository("other-owner", repo_name), env_name)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where user only has a legacy un-scoped
+<repo>#<env> grant and legacy keys are disabled
+*/
+func TestLegacyKeysDisabled(t *testing.T) {
+	// arrange
+	legacyKeysEnabled = false
+	t.Cleanup(func() { legacyKeysEnabled = true })
+
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, repo_name, env_name)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where user's <owner>/<repo>#<env> grant has expired
+*/
+func TestExpiredGrant(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	store := access.NewMemoryStore()
+	store.Put(access.Grant{
+		Login:    requester_name,
+		Key:      access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name),
+		Effect:   access.EFFECT_ALLOW,
+		NotAfter: time.Now().Add(-time.Hour).Unix(),
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
+}
+
+/*
+Test for case where user's <owner>/<repo>#<env> grant
+has a validity window that has not started yet
+*/
+func TestNotYetValidGrant(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	store := access.NewMemoryStore()
+	store.Put(access.Grant{
+		Login:     requester_name,
+		Key:       access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name),
+		Effect:    access.EFFECT_ALLOW,
+		NotBefore: time.Now().Add(time.Hour).Unix(),
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved")
 }
 
-func getMockedGhClient(runID int64, envName string) *github.Client {
+/*
+Test for case where user has access based on table entry of
+<owner>/*#* and a deny grant that has since expired
+*/
+func TestExpiredDenyGrant(t *testing.T) {
+	// arrange
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	store := storeWithGrant(requester_name, access.ScopedRepository(owner_name, access.WILDCARD), access.WILDCARD)
+	store.Put(access.Grant{
+		Login:     requester_name,
+		Key:       access.GrantKey(access.ScopedRepository(owner_name, repo_name), env_name),
+		Effect:    access.EFFECT_DENY,
+		NotBefore: time.Now().Add(-2 * time.Hour).Unix(),
+		NotAfter:  time.Now().Add(-time.Hour).Unix(),
+	})
+	accessStore = store
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.True(t, approved, "deployment should have been approved")
+}
+
+/*
+Test for case where user has access while dry run is enabled, the
+deployment should not be approved but the approval should be audited
+*/
+func TestDryRun(t *testing.T) {
+	// arrange
+	dryRunEnabled = true
+	t.Cleanup(func() { dryRunEnabled = false })
+
+	event := createdWorkflowRunEvent(repo_name, owner_name, requester_name, run_id)
+
+	approved := false
+	ghClient = getMockedGhClient(run_id, env_name, &approved)
+	accessStore = storeWithGrant(requester_name, repo_name, env_name)
+	auditLog := useMemoryAuditor(t)
+
+	// act
+	err := HandleWorkflowRunEvent(context.TODO(), true, event)
+
+	// assert
+	assert.Nil(t, err)
+	assert.False(t, approved, "deployment should not have been approved during a dry run")
+	records := auditLog.Records()
+	assert.Len(t, records, 1)
+	assert.Equal(t, audit.APPROVED_DECISION, records[0].Decision)
+	assert.True(t, records[0].DryRun)
+}
+
+/*
+returns an in-memory access store where requester has the <repo>#<env> grant
+*/
+func storeWithGrant(requester string, repo string, env string) *access.MemoryStore {
+	store := access.NewMemoryStore()
+	store.Add(requester, access.GrantKey(repo, env))
+	return store
+}
+
+/*
+mocks the pending deployments endpoints, approved is set
+to true if the pending deployment approval is posted
+*/
+func getMockedGhClient(runID int64, envName string, approved *bool) *github.Client {
 
 	deploymentURL := "example.com"
 
@@ -169,8 +526,12 @@ func getMockedGhClient(runID int64, envName string) *github.Client {
 		ghMock.WithRequestMatch(
 			ghMock.GetReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
 			pendingDeployments),
-		ghMock.WithRequestMatch(ghMock.PostReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
-			approvedDeployments,
+		ghMock.WithRequestMatchHandler(
+			ghMock.PostReposActionsRunsPendingDeploymentsByOwnerByRepoByRunId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				*approved = true
+				w.Write(ghMock.MustMarshal(approvedDeployments))
+			}),
 		),
 	)
 
@@ -6,13 +6,18 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"webhook/audit"
+	"webhook/delivery"
+	gh "webhook/github"
 	"webhook/handlers"
 	"webhook/logger"
+	"webhook/queue"
 	"webhook/secrets"
 	"webhook/util"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-secretsmanager-caching-go/v2/secretcache"
 	"github.com/aws/aws-xray-sdk-go/xray"
 	"github.com/awslabs/aws-lambda-go-api-proxy/core"
 	"github.com/google/go-github/v66/github"
@@ -21,7 +26,6 @@ import (
 
 var (
 	logInstance *zap.SugaredLogger
-	mocking     bool
 )
 
 const (
@@ -31,19 +35,55 @@ const (
 
 	CONTENT_TYPE_HEADER     = "Content-Type"
 	INTERNAL_MOCKING_HEADER = "X-Mock-Enabled"
+
+	// in async mode validated deliveries are queued and answered with 202 Accepted,
+	// the queue worker entrypoint then processes them outside of GitHub's webhook timeout
+	PROCESSING_MODE_ENV_VAR_KEY = "PROCESSING_MODE"
+	PROCESSING_MODE_DEFAULT     = SYNC_PROCESSING_MODE
+	SYNC_PROCESSING_MODE        = "sync"
+	ASYNC_PROCESSING_MODE       = "async"
+
+	// the api entrypoint handles API Gateway requests, the queue entrypoint SQS batches
+	// and the reconcile and redeliver entrypoints the EventBridge schedules of the maintenance lambdas
+	LAMBDA_ENTRYPOINT_ENV_VAR_KEY = "LAMBDA_ENTRYPOINT"
+	LAMBDA_ENTRYPOINT_DEFAULT     = API_ENTRYPOINT
+	API_ENTRYPOINT                = "api"
+	QUEUE_ENTRYPOINT              = "queue"
+	RECONCILE_ENTRYPOINT          = "reconcile"
+	REDELIVER_ENTRYPOINT          = "redeliver"
 )
 
 func init() {
 	logInstance = logger.GetLogger().Sugar()
 }
 
+/*
+GitHubEventMonitor validates the webhook deliveries received through API Gateway and
+processes each of them once. Its webhook secrets, ledger, queue and event handlers
+are those of the lambda unless replaced (ex. in tests)
+*/
 type GitHubEventMonitor struct {
-	webhookSecretKey []byte
+	webhookSecrets func(ctx context.Context, mocking bool, target secretTarget) ([]webhookSecret, *webhookSecretEntry, error)
+	getLedger      func(ctx context.Context) (delivery.Ledger, error)
+	getQueue       func(ctx context.Context) (queue.Queue, error)
+	handlerFor     func(mocking bool, event interface{}) func(ctx context.Context) error
+}
+
+/*
+creates a monitor using the webhook secrets, delivery ledger, event queue and event handlers of the lambda
+*/
+func NewGitHubEventMonitor() *GitHubEventMonitor {
+	return &GitHubEventMonitor{
+		webhookSecrets: getWebhookSecrets,
+		getLedger:      delivery.GetLedger,
+		getQueue:       queue.GetQueue,
+		handlerFor:     eventHandler,
+	}
 }
 
 func (s *GitHubEventMonitor) HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	funcLogger := logInstance.With()
-	mocking = ShouldUseMock(&request.Headers, funcLogger)
+	mocking := ShouldUseMock(&request.Headers, funcLogger)
 	logger.InitializeXRay(mocking)
 
 	_, subSegment := xray.BeginSubsegment(ctx, "HandleRequest")
@@ -53,13 +93,6 @@ func (s *GitHubEventMonitor) HandleRequest(ctx context.Context, request events.A
 		defer subSegment.Close(nil)
 	}
 
-	webhookSecretErr := s.sourceSecret(ctx)
-	if webhookSecretErr != nil {
-		errMsg := "a webhook secret has not been configured"
-		funcLogger.Errorln(errMsg)
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError, Body: buildResponseBody(errMsg, http.StatusInternalServerError)}, nil
-	}
-
 	logAPIGatewayRequest(request, funcLogger)
 
 	reqAccessor := core.RequestAccessor{}
@@ -69,12 +102,34 @@ func (s *GitHubEventMonitor) HandleRequest(ctx context.Context, request events.A
 		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError, Body: buildResponseBody(errMsg, http.StatusInternalServerError)}, nil
 	}
 
-	payload, err := github.ValidatePayload(httpReq, s.webhookSecretKey)
+	webhookSecrets, secretEntry, webhookSecretErr := s.sourceSecrets(ctx, mocking, newSecretTarget(request.PathParameters, httpReq.Header))
+	if errors.Is(webhookSecretErr, errUnknownTenant) {
+		errMsg := fmt.Sprintf("invalid payload; %s", webhookSecretErr)
+		funcLogger.Errorln("unknown tenant", zap.Error(webhookSecretErr))
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusUnauthorized, Body: buildResponseBody(errMsg, http.StatusUnauthorized)}, nil
+	}
+	if webhookSecretErr != nil {
+		errMsg := "a webhook secret has not been configured"
+		funcLogger.Errorln(errMsg)
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError, Body: buildResponseBody(errMsg, http.StatusInternalServerError)}, nil
+	}
+
+	payload, matchedStage, err := validatePayload(httpReq, webhookSecrets)
 	if err != nil {
 		errMsg := fmt.Sprintf("invalid payload; %s", err)
 		funcLogger.Errorln("invalid payload", zap.Error(err))
 		return events.APIGatewayProxyResponse{StatusCode: http.StatusUnauthorized, Body: buildResponseBody(errMsg, http.StatusUnauthorized)}, nil
 	}
+	funcLogger.Infoln("validated payload signature", zap.String("version_stage", matchedStage))
+
+	// the secret was picked by unsigned inputs, so the signed payload must be for an owner the secret covers
+	if secretEntry != nil {
+		if err := secretEntry.allows(payload); err != nil {
+			errMsg := fmt.Sprintf("invalid payload; %s", err)
+			funcLogger.Errorln("payload is not for an owner or installation of the webhook secret", zap.Error(err))
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusUnauthorized, Body: buildResponseBody(errMsg, http.StatusUnauthorized)}, nil
+		}
+	}
 	event, err := github.ParseWebHook(github.WebHookType(httpReq), payload)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to parse webhook; %s", err)
@@ -82,32 +137,136 @@ func (s *GitHubEventMonitor) HandleRequest(ctx context.Context, request events.A
 		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: buildResponseBody(errMsg, http.StatusBadRequest)}, nil
 	}
 
-	switch event := event.(type) {
-	case *github.WorkflowRunEvent:
-		if mocking {
-			return eventProcessedResp(), nil
+	enterpriseHost := httpReq.Header.Get(gh.ENTERPRISE_HOST_HEADER)
+	if enterpriseHost != "" {
+		funcLogger = funcLogger.With(zap.String("enterprise_host", enterpriseHost), zap.String("enterprise_version", httpReq.Header.Get(gh.ENTERPRISE_VERSION_HEADER)))
+		if _, err := gh.ResolveEndpoint(enterpriseHost); err != nil {
+			errMsg := fmt.Sprintf("unsupported github enterprise host; %s", err)
+			funcLogger.Errorln("unsupported github enterprise host", zap.Error(err))
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: buildResponseBody(errMsg, http.StatusBadRequest)}, nil
 		}
+		ctx = gh.WithEnterpriseHost(ctx, enterpriseHost)
+	}
 
-		err := handlers.HandleWorkflowRunEvent(ctx, mocking, event)
-		if err != nil {
-			errMsg := fmt.Sprintf("error while handling event type %T", event)
-			funcLogger.Errorln(errMsg, zap.Error(err))
-			return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError, Body: buildResponseBody(errMsg, http.StatusBadRequest)}, nil
-		}
-	default:
+	handle := s.handlerFor(mocking, event)
+	if handle == nil {
 		errMsg := fmt.Sprintf("unsupported event type %T", event)
 		funcLogger.Errorln(errMsg, zap.Error(errors.New(errMsg)))
 		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: buildResponseBody(errMsg, http.StatusBadRequest)}, nil
 	}
 
+	if mocking {
+		return eventProcessedResp(), nil
+	}
+
+	deliveryID := github.DeliveryID(httpReq)
+	funcLogger = funcLogger.With(zap.String("delivery_id", deliveryID))
+	if deliveryID == "" {
+		errMsg := fmt.Sprintf("missing %s header", github.DeliveryIDHeader)
+		funcLogger.Errorln(errMsg)
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: buildResponseBody(errMsg, http.StatusBadRequest)}, nil
+	}
+
+	ledger, err := s.getLedger(ctx)
+	if err != nil {
+		errMsg := "a delivery ledger has not been configured"
+		funcLogger.Errorln(errMsg, zap.Error(err))
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError, Body: buildResponseBody(errMsg, http.StatusInternalServerError)}, nil
+	}
+
+	// claimed before processing so a replayed or redelivered delivery never runs the approval flow twice
+	claimed, err := ledger.Claim(ctx, deliveryID)
+	if err != nil {
+		errMsg := "error while claiming delivery"
+		funcLogger.Errorln(errMsg, zap.Error(err))
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError, Body: buildResponseBody(errMsg, http.StatusInternalServerError)}, nil
+	}
+	if !claimed {
+		funcLogger.Infoln("delivery has already been processed")
+		return alreadyProcessedResp(), nil
+	}
+
+	if strings.ToLower(util.LookupEnv(PROCESSING_MODE_ENV_VAR_KEY, PROCESSING_MODE_DEFAULT, false)) == ASYNC_PROCESSING_MODE {
+		message := queue.Message{DeliveryID: deliveryID, EventType: github.WebHookType(httpReq), EnterpriseHost: enterpriseHost, Payload: payload}
+		if err := s.enqueueEvent(ctx, message); err != nil {
+			errMsg := "error while queueing event"
+			funcLogger.Errorln(errMsg, zap.Error(err))
+
+			// released so a redelivery of the delivery is queued again
+			if releaseErr := ledger.Release(ctx, deliveryID); releaseErr != nil {
+				funcLogger.Errorln("error while releasing delivery", zap.Error(releaseErr))
+			}
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError, Body: buildResponseBody(errMsg, http.StatusInternalServerError)}, nil
+		}
+		return eventQueuedResp(), nil
+	}
+
+	if err := handle(audit.WithDeliveryID(ctx, deliveryID)); err != nil {
+		errMsg := fmt.Sprintf("error while handling event type %T", event)
+		funcLogger.Errorln(errMsg, zap.Error(err))
+
+		// released so a redelivery of the failed delivery is processed again
+		if releaseErr := ledger.Release(ctx, deliveryID); releaseErr != nil {
+			funcLogger.Errorln("error while releasing delivery", zap.Error(releaseErr))
+		}
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError, Body: buildResponseBody(errMsg, http.StatusInternalServerError)}, nil
+	}
+
 	return eventProcessedResp(), nil
 }
 
 func main() {
 	defer logInstance.Sync()
 
-	eventMonitor := &GitHubEventMonitor{}
-	lambda.Start(eventMonitor.HandleRequest)
+	switch entrypoint := strings.ToLower(util.LookupEnv(LAMBDA_ENTRYPOINT_ENV_VAR_KEY, LAMBDA_ENTRYPOINT_DEFAULT, false)); entrypoint {
+	case QUEUE_ENTRYPOINT:
+		queueWorker := NewEventQueueWorker()
+		lambda.Start(queueWorker.HandleSQSEvent)
+	case RECONCILE_ENTRYPOINT:
+		reconciler := &Reconciler{}
+		lambda.Start(reconciler.HandleScheduledEvent)
+	case REDELIVER_ENTRYPOINT:
+		redelivery := &RedeliveryMaintenance{}
+		lambda.Start(redelivery.HandleScheduledEvent)
+	case API_ENTRYPOINT:
+		eventMonitor := NewGitHubEventMonitor()
+		lambda.Start(eventMonitor.HandleRequest)
+	default:
+		logInstance.Fatalln("unsupported lambda entrypoint", zap.String("entrypoint", entrypoint))
+	}
+}
+
+/*
+returns the handler of a supported event, or nil if the event type is not supported
+*/
+func eventHandler(mocking bool, event interface{}) func(ctx context.Context) error {
+	switch event := event.(type) {
+	case *github.WorkflowRunEvent:
+		return func(ctx context.Context) error {
+			return handlers.HandleWorkflowRunEvent(ctx, mocking, event)
+		}
+	case *github.DeploymentProtectionRuleEvent:
+		return func(ctx context.Context) error {
+			return handlers.HandleDeploymentProtectionRuleEvent(ctx, mocking, event)
+		}
+	case *github.IssueCommentEvent:
+		return func(ctx context.Context) error {
+			return handlers.HandleIssueCommentEvent(ctx, mocking, event)
+		}
+	default:
+		return nil
+	}
+}
+
+/*
+sends the validated delivery to the event queue for the queue worker to process
+*/
+func (s *GitHubEventMonitor) enqueueEvent(ctx context.Context, message queue.Message) error {
+	eventQueue, err := s.getQueue(ctx)
+	if err != nil {
+		return err
+	}
+	return eventQueue.Send(ctx, message)
 }
 
 func logAPIGatewayRequest(req events.APIGatewayProxyRequest, funcLogger *zap.SugaredLogger) {
@@ -156,40 +315,66 @@ func buildResponseBody(msg string, statusCode int) string {
 
 /*
 If we are mocking, we return GITHUB_WEBHOOK_SECRET_DEFAULT,
-otherwise we attempt to get the secret name from environment variables.
-We using the secret name sourced from GITHUB_WEBHOOK_SECRET_NAME_ENV_VAR_KEY
-or GITHUB_WEBHOOK_SECRET_NAME_DEFAULT if that environment variable is not found
+otherwise we attempt to get the secret of the delivery's target from
+GITHUB_WEBHOOK_SECRET_NAMES_ENV_VAR_KEY (see resolveWebhookSecret), the entry
+is returned so the payload can be checked against the owners it may sign for.
+Deliveries without a secret of their own use the secret name sourced from
+GITHUB_WEBHOOK_SECRET_NAME_ENV_VAR_KEY or GITHUB_WEBHOOK_SECRET_NAME_DEFAULT
+if that environment variable is not found.
+Every version stage of GITHUB_WEBHOOK_SECRET_STAGES_ENV_VAR_KEY that exists is
+returned so deliveries signed with either secret are valid during a rotation
 */
-func getWebhookSecret(ctx context.Context) (*string, error) {
-
-	var secretDefault = string(GITHUB_WEBHOOK_SECRET_DEFAULT)
+func getWebhookSecrets(ctx context.Context, mocking bool, target secretTarget) ([]webhookSecret, *webhookSecretEntry, error) {
 
 	if mocking {
-		return &secretDefault, nil
+		return []webhookSecret{{stage: secretcache.DefaultVersionStage, key: []byte(GITHUB_WEBHOOK_SECRET_DEFAULT)}}, nil, nil
+	}
+
+	secretNames, err := getWebhookSecretNames()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secretEntry, secretKey, err := resolveWebhookSecret(secretNames, target)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	webhookSecretName := util.LookupEnv(GITHUB_WEBHOOK_SECRET_NAME_ENV_VAR_KEY, GITHUB_WEBHOOK_SECRET_NAME_DEFAULT, false)
-	webhookSecret, err := secrets.GetSecretValue(ctx, webhookSecretName)
-	if webhookSecret == nil || err != nil {
-		logInstance.Errorln("error while getting webhook secret value")
-		return nil, err
+	if secretEntry != nil {
+		webhookSecretName = secretEntry.Secret
+	}
+	funcLogger := logInstance.With(zap.String("secret_name", webhookSecretName), zap.String("secret_key", secretKey))
+	funcLogger.Debugln("resolved webhook secret name")
+
+	var webhookSecrets []webhookSecret
+	for _, stage := range getWebhookSecretStages() {
+		webhookSecretValue, err := secrets.GetSecretValueWithStage(ctx, webhookSecretName, stage)
+		if webhookSecretValue == nil || err != nil {
+			// only the current version always exists, the others only exist during a rotation
+			if stage == secretcache.DefaultVersionStage {
+				funcLogger.Errorln("error while getting webhook secret value", zap.Error(err))
+				return nil, nil, errors.Join(errors.New("unable to get webhook secret value"), err)
+			}
+			funcLogger.Debugln("webhook secret version stage not found", zap.String("version_stage", stage), zap.Error(err))
+			continue
+		}
+		webhookSecrets = append(webhookSecrets, webhookSecret{stage: stage, key: []byte(*webhookSecretValue)})
 	}
 
-	return webhookSecret, nil
+	if len(webhookSecrets) == 0 {
+		return nil, nil, errors.New("no webhook secret version stage was found")
+	}
+	return webhookSecrets, secretEntry, nil
 }
 
 /*
-Sources Github Webhook secret and sets it if sourced.
-Returns error and leaves property unset if not sourced successfully.
+Sources Github Webhook secrets of the delivery's target for the current request.
+The secrets are returned rather than stored on the monitor
+so concurrent requests never share them.
 */
-func (s *GitHubEventMonitor) sourceSecret(ctx context.Context) error {
-
-	secret, err := getWebhookSecret(ctx)
-	if err != nil {
-		return err
-	}
-	s.webhookSecretKey = []byte(*secret)
-	return nil
+func (s *GitHubEventMonitor) sourceSecrets(ctx context.Context, mocking bool, target secretTarget) ([]webhookSecret, *webhookSecretEntry, error) {
+	return s.webhookSecrets(ctx, mocking, target)
 }
 
 /*
@@ -198,3 +383,19 @@ returns APIGatewayProxyResponse with Status OK (200) and message of "event proce
 func eventProcessedResp() events.APIGatewayProxyResponse {
 	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: buildResponseBody("event processed", http.StatusOK)}
 }
+
+/*
+returns APIGatewayProxyResponse with Status OK (200) and message of "already processed",
+for deliveries that have been processed before
+*/
+func alreadyProcessedResp() events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: buildResponseBody("already processed", http.StatusOK)}
+}
+
+/*
+returns APIGatewayProxyResponse with Status Accepted (202) and message of "event queued",
+for deliveries queued to be processed by the queue worker
+*/
+func eventQueuedResp() events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusAccepted, Body: buildResponseBody("event queued", http.StatusAccepted)}
+}
@@ -5,18 +5,26 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
+	"webhook/delivery"
+	gh "webhook/github"
+	"webhook/queue"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-secretsmanager-caching-go/v2/secretcache"
 	"github.com/google/go-github/v66/github"
 )
 
 const (
 	sha256Prefix string = "sha256"
+
+	delivery_id = "72d3162e-cc78-11e3-81ab-4c9367dc0958"
 )
 
 var (
@@ -26,13 +34,13 @@ var (
 	unSupportedEventReq              events.APIGatewayProxyRequest
 	parsedWebhookIncorrectHeaderType events.APIGatewayProxyRequest
 
-	eventMonitor *GitHubEventMonitor
+	eventMonitor     *GitHubEventMonitor
+	webhookSecretKey []byte
 )
 
 func init() {
-	eventMonitor = &GitHubEventMonitor{
-		webhookSecretKey: []byte(GITHUB_WEBHOOK_SECRET_DEFAULT),
-	}
+	eventMonitor = NewGitHubEventMonitor()
+	webhookSecretKey = []byte(GITHUB_WEBHOOK_SECRET_DEFAULT)
 }
 
 func TestInValidPayload(t *testing.T) {
@@ -105,6 +113,183 @@ func TestSupportedEventType(t *testing.T) {
 	assert.Contains(t, strings.ToLower(resp.Body), strings.ToLower("event processed"))
 }
 
+func TestSupportedProtectionRuleEventType(t *testing.T) {
+	t.Parallel()
+
+	// arrange
+	supportedProtectionRuleEventReq := generateAPIGatewayProxyRequest(&[]string{"deployment_protection_rule"}[0], nil, true)
+
+	// act
+	resp, _ := eventMonitor.HandleRequest(context.TODO(), supportedProtectionRuleEventReq)
+
+	// assert
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "incorrect status code")
+	assert.Contains(t, strings.ToLower(resp.Body), strings.ToLower("event processed"))
+}
+
+/*
+Test for case where the event comes from a GitHub Enterprise Server
+host that isn't configured, the event should be refused
+*/
+func TestUnsupportedEnterpriseHost(t *testing.T) {
+	t.Parallel()
+
+	// arrange
+	enterpriseEventReq := generateAPIGatewayProxyRequest(nil, nil, true)
+	enterpriseEventReq.Headers[gh.ENTERPRISE_HOST_HEADER] = "github.unknown.example.com"
+
+	// act
+	resp, _ := eventMonitor.HandleRequest(context.TODO(), enterpriseEventReq)
+
+	// assert
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "incorrect status code")
+	assert.Contains(t, strings.ToLower(resp.Body), "unsupported github enterprise host")
+}
+
+/*
+Test for case where the delivery has already been claimed, the
+delivery should not be handled again
+*/
+func TestDuplicateDelivery(t *testing.T) {
+	// arrange
+	ledger := delivery.NewMemoryLedger(delivery.DELIVERY_CACHE_SIZE, time.Hour)
+	ledger.Claim(context.TODO(), delivery_id)
+
+	handled := 0
+	monitor := getTestEventMonitor(ledger, &handled, nil)
+
+	// act
+	resp, _ := monitor.HandleRequest(context.TODO(), generateDeliveryRequest(delivery_id))
+
+	// assert
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "incorrect status code")
+	assert.Contains(t, strings.ToLower(resp.Body), "already processed")
+	assert.Zero(t, handled, "duplicate delivery should not have been handled")
+}
+
+/*
+Test for case where handling the delivery fails, the delivery
+should be released so a redelivery is processed again
+*/
+func TestFailedDeliveryReleased(t *testing.T) {
+	// arrange
+	ledger := delivery.NewMemoryLedger(delivery.DELIVERY_CACHE_SIZE, time.Hour)
+
+	handled := 0
+	monitor := getTestEventMonitor(ledger, &handled, errors.New("unable to review deployment"))
+
+	// act
+	resp, _ := monitor.HandleRequest(context.TODO(), generateDeliveryRequest(delivery_id))
+	claimed, claimedErr := ledger.Claimed(context.TODO(), delivery_id)
+
+	// assert
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "incorrect status code")
+	assert.Contains(t, strings.ToLower(resp.Body), "error while handling event")
+	assert.Equal(t, 1, handled)
+	assert.Nil(t, claimedErr)
+	assert.False(t, claimed, "failed delivery should have been released")
+}
+
+/*
+Test for case where deliveries are processed asynchronously, the
+delivery should be queued instead of handled
+*/
+func TestAsyncDeliveryQueued(t *testing.T) {
+	// arrange
+	t.Setenv(PROCESSING_MODE_ENV_VAR_KEY, ASYNC_PROCESSING_MODE)
+	ledger := delivery.NewMemoryLedger(delivery.DELIVERY_CACHE_SIZE, time.Hour)
+	eventQueue := queue.NewMemoryQueue()
+
+	handled := 0
+	monitor := getTestEventMonitor(ledger, &handled, nil)
+	monitor.getQueue = func(ctx context.Context) (queue.Queue, error) {
+		return eventQueue, nil
+	}
+
+	// act
+	resp, _ := monitor.HandleRequest(context.TODO(), generateDeliveryRequest(delivery_id))
+
+	// assert
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode, "incorrect status code")
+	assert.Contains(t, strings.ToLower(resp.Body), "event queued")
+	assert.Zero(t, handled, "queued delivery should not have been handled")
+
+	messages := eventQueue.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, delivery_id, messages[0].DeliveryID)
+	assert.Equal(t, "workflow_run", messages[0].EventType)
+	assert.JSONEq(t, "{\"key\":\"value\"}", string(messages[0].Payload))
+}
+
+/*
+Test for case where the delivery can't be queued, the delivery
+should be released so a redelivery is queued again
+*/
+func TestFailedEnqueueReleased(t *testing.T) {
+	// arrange
+	t.Setenv(PROCESSING_MODE_ENV_VAR_KEY, ASYNC_PROCESSING_MODE)
+	ledger := delivery.NewMemoryLedger(delivery.DELIVERY_CACHE_SIZE, time.Hour)
+
+	handled := 0
+	monitor := getTestEventMonitor(ledger, &handled, nil)
+	monitor.getQueue = func(ctx context.Context) (queue.Queue, error) {
+		return failingQueue{err: errors.New("queue does not exist")}, nil
+	}
+
+	// act
+	resp, _ := monitor.HandleRequest(context.TODO(), generateDeliveryRequest(delivery_id))
+	claimed, claimedErr := ledger.Claimed(context.TODO(), delivery_id)
+
+	// assert
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "incorrect status code")
+	assert.Contains(t, strings.ToLower(resp.Body), "error while queueing event")
+	assert.Zero(t, handled, "delivery should not have been handled")
+	assert.Nil(t, claimedErr)
+	assert.False(t, claimed, "delivery that couldn't be queued should have been released")
+}
+
+/*
+failingQueue fails to send every message with err
+*/
+type failingQueue struct {
+	err error
+}
+
+func (q failingQueue) Send(_ context.Context, _ queue.Message) error {
+	return q.err
+}
+
+/*
+returns a monitor signing with webhookSecretKey and claiming deliveries in ledger,
+its handler counts the events handled and fails with handleErr
+*/
+func getTestEventMonitor(ledger delivery.Ledger, handled *int, handleErr error) *GitHubEventMonitor {
+	return &GitHubEventMonitor{
+		webhookSecrets: func(ctx context.Context, mocking bool, target secretTarget) ([]webhookSecret, *webhookSecretEntry, error) {
+			return []webhookSecret{{stage: secretcache.DefaultVersionStage, key: webhookSecretKey}}, nil, nil
+		},
+		getLedger: func(ctx context.Context) (delivery.Ledger, error) {
+			return ledger, nil
+		},
+		handlerFor: func(mocking bool, event interface{}) func(ctx context.Context) error {
+			return func(ctx context.Context) error {
+				*handled++
+				return handleErr
+			}
+		},
+	}
+}
+
+/*
+returns a signed workflow_run delivery that isn't mocked, so it goes through the ledger
+*/
+func generateDeliveryRequest(deliveryID string) events.APIGatewayProxyRequest {
+	request := generateAPIGatewayProxyRequest(nil, nil, true)
+	delete(request.Headers, INTERNAL_MOCKING_HEADER)
+	request.Headers[github.DeliveryIDHeader] = deliveryID
+	return request
+}
+
 func generateAPIGatewayProxyRequest(eventTypeHeader *string, payload *string, validateSignature bool) events.APIGatewayProxyRequest {
 	if eventTypeHeader == nil {
 		temp := "workflow_run"
@@ -135,7 +320,7 @@ https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
 */
 func generateSignature(body string) string {
 	// create a new HMAC sha256 hash using the sample key
-	hmacHash := hmac.New(sha256.New, eventMonitor.webhookSecretKey)
+	hmacHash := hmac.New(sha256.New, webhookSecretKey)
 	// write the payload body to hmac
 	hmacHash.Write([]byte(body))
 	// return the hex encoded hmac body to get signature
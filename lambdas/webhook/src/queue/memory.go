@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*
+MemoryQueue keeps sent messages in memory, it stands in for SQS locally
+and in tests where its messages can be handed to the queue worker as an SQS event
+*/
+type MemoryQueue struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{}
+}
+
+func (q *MemoryQueue) Send(_ context.Context, message Message) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.messages = append(q.messages, message)
+	return nil
+}
+
+/*
+returns the messages sent so far
+*/
+func (q *MemoryQueue) Messages() []Message {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return append([]Message(nil), q.messages...)
+}
+
+/*
+returns the messages sent so far as the SQS event the queue worker would receive,
+message IDs are the position of the message in the queue
+*/
+func (q *MemoryQueue) SQSEvent() (events.SQSEvent, error) {
+	var event events.SQSEvent
+	for i, message := range q.Messages() {
+		body, err := json.Marshal(message)
+		if err != nil {
+			return events.SQSEvent{}, err
+		}
+		event.Records = append(event.Records, events.SQSMessage{
+			MessageId: strconv.Itoa(i),
+			Body:      string(body),
+			MessageAttributes: map[string]events.SQSMessageAttribute{
+				EVENT_TYPE_ATTRIBUTE:  {DataType: "String", StringValue: &message.EventType},
+				DELIVERY_ID_ATTRIBUTE: {DataType: "String", StringValue: &message.DeliveryID},
+			},
+		})
+	}
+	return event, nil
+}
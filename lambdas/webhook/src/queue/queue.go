@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"webhook/db"
+	"webhook/logger"
+	"webhook/util"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"go.uber.org/zap"
+)
+
+var (
+	queueInstance util.Lazy[Queue]
+
+	logInstance *zap.SugaredLogger
+)
+
+const (
+	EVENT_QUEUE_TYPE_ENV_VAR_KEY = "EVENT_QUEUE_TYPE"
+	EVENT_QUEUE_TYPE_DEFAULT     = SQS_QUEUE_TYPE
+
+	EVENT_QUEUE_URL_ENV_VAR_KEY = "EVENT_QUEUE_URL"
+	EVENT_QUEUE_URL_DEFAULT     = ""
+
+	SQS_QUEUE_TYPE    = "sqs"
+	MEMORY_QUEUE_TYPE = "memory"
+
+	EVENT_TYPE_ATTRIBUTE  = "event_type"
+	DELIVERY_ID_ATTRIBUTE = "delivery_id"
+)
+
+func init() {
+	logInstance = logger.GetLogger().Sugar()
+}
+
+/*
+Message is a validated webhook delivery queued for processing, the payload
+is the delivery's JSON payload as validated against the webhook secret
+*/
+type Message struct {
+	DeliveryID     string          `json:"delivery_id"`
+	EventType      string          `json:"event_type"`
+	EnterpriseHost string          `json:"enterprise_host,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+/*
+Queue hands validated deliveries over to be processed outside of the
+webhook request, so GitHub is answered well within its timeout
+*/
+type Queue interface {
+	Send(ctx context.Context, message Message) error
+}
+
+/*
+reads a message from the body of a queued message
+*/
+func ParseMessage(body string) (Message, error) {
+	var message Message
+	if err := json.Unmarshal([]byte(body), &message); err != nil {
+		return Message{}, fmt.Errorf("queued message is not valid JSON: %w", err)
+	}
+	if message.EventType == "" || len(message.Payload) == 0 {
+		return Message{}, errors.New("queued message is missing the event type or payload")
+	}
+	return message, nil
+}
+
+/*
+Returns the queue configured through EVENT_QUEUE_TYPE_ENV_VAR_KEY
+*/
+func GetQueue(ctx context.Context) (Queue, error) {
+	eventQueue, err := queueInstance.Get(func() (Queue, error) {
+		return newQueueFromEnv(ctx)
+	})
+	if err != nil {
+		logInstance.Errorln("cannot configure event queue", zap.Error(err))
+		return nil, err
+	}
+	return eventQueue, nil
+}
+
+func newQueueFromEnv(ctx context.Context) (Queue, error) {
+	queueType := strings.ToLower(util.LookupEnv(EVENT_QUEUE_TYPE_ENV_VAR_KEY, EVENT_QUEUE_TYPE_DEFAULT, false))
+	funcLogger := logInstance.With(zap.String("queue_type", queueType))
+
+	switch queueType {
+	case SQS_QUEUE_TYPE:
+		queueURL := util.LookupEnv(EVENT_QUEUE_URL_ENV_VAR_KEY, EVENT_QUEUE_URL_DEFAULT, false)
+		if queueURL == "" {
+			err := fmt.Errorf("%s must be set to queue events to SQS", EVENT_QUEUE_URL_ENV_VAR_KEY)
+			funcLogger.Errorln("missing event queue URL", zap.Error(err))
+			return nil, err
+		}
+		cfg, err := db.GetAWSConfig(ctx)
+		if err != nil {
+			funcLogger.Errorln("error observed while trying to get sqs client", zap.Error(err))
+			return nil, err
+		}
+		return NewSQSQueue(sqs.NewFromConfig(cfg), queueURL), nil
+	case MEMORY_QUEUE_TYPE:
+		funcLogger.Warnln("using an in-memory event queue, queued events are not processed by another lambda")
+		return NewMemoryQueue(), nil
+	default:
+		err := fmt.Errorf("unsupported event queue type %q", queueType)
+		funcLogger.Errorln("invalid event queue type", zap.Error(err))
+		return nil, err
+	}
+}
@@ -0,0 +1,42 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Test for case where a message is sent to the in-memory queue, the message
+should be read back from the body of its SQS record
+*/
+func TestMemoryQueueSQSEvent(t *testing.T) {
+	// arrange
+	message := Message{DeliveryID: "delivery-id", EventType: "workflow_run", EnterpriseHost: "github.example.com", Payload: json.RawMessage(`{"action":"requested"}`)}
+	eventQueue := NewMemoryQueue()
+	eventQueue.Send(context.TODO(), message)
+
+	// act
+	sqsEvent, err := eventQueue.SQSEvent()
+	parsed, parseErr := ParseMessage(sqsEvent.Records[0].Body)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, parseErr)
+	assert.Len(t, sqsEvent.Records, 1)
+	assert.Equal(t, "workflow_run", *sqsEvent.Records[0].MessageAttributes[EVENT_TYPE_ATTRIBUTE].StringValue)
+	assert.Equal(t, message, parsed)
+}
+
+/*
+Test for case where a queued message has no payload
+*/
+func TestParseMessageMissingPayload(t *testing.T) {
+	// act
+	_, err := ParseMessage(`{"delivery_id":"delivery-id","event_type":"workflow_run"}`)
+
+	// assert
+	assert.NotNil(t, err)
+}
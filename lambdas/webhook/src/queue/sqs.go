@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.uber.org/zap"
+)
+
+/*
+SQSQueue sends messages to an SQS queue, the event type and delivery ID
+are also set as message attributes so they can be seen without the body
+*/
+type SQSQueue struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+func NewSQSQueue(client *sqs.Client, queueURL string) *SQSQueue {
+	return &SQSQueue{client: client, queueURL: queueURL}
+}
+
+func (q *SQSQueue) Send(ctx context.Context, message Message) error {
+	funcLogger := logInstance.With(zap.String("delivery_id", message.DeliveryID), zap.String("event_type", message.EventType))
+
+	_, subSegment := xray.BeginSubsegment(ctx, "SQSQueue.Send")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to marshal message", zap.Error(err))
+		return err
+	}
+
+	output, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.queueURL),
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			EVENT_TYPE_ATTRIBUTE:  {DataType: aws.String("String"), StringValue: aws.String(message.EventType)},
+			DELIVERY_ID_ATTRIBUTE: {DataType: aws.String("String"), StringValue: aws.String(message.DeliveryID)},
+		},
+	})
+	if err != nil {
+		funcLogger.Errorln("error observed while trying to send message", zap.Error(err))
+		return err
+	}
+
+	funcLogger.Infoln("queued event", zap.String("message_id", aws.ToString(output.MessageId)))
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"webhook/handlers"
+	"webhook/logger"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.uber.org/zap"
+)
+
+/*
+Reconciler is triggered by an EventBridge schedule to sweep the configured
+repositories for waiting runs the webhook path missed, see handlers.ReconcileWaitingRuns
+*/
+type Reconciler struct{}
+
+func (r *Reconciler) HandleScheduledEvent(ctx context.Context, event events.EventBridgeEvent) error {
+	funcLogger := logInstance.With(zap.String("event_id", event.ID), zap.Time("scheduled_time", event.Time))
+	logger.InitializeXRay(false)
+
+	_, subSegment := xray.BeginSubsegment(ctx, "HandleScheduledEvent")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	repositories, dryRun, err := handlers.ReconcileConfig()
+	if err != nil {
+		funcLogger.Errorln("invalid reconciler configuration", zap.Error(err))
+		return err
+	}
+	if len(repositories) == 0 {
+		funcLogger.Warnln("no repositories are configured to reconcile")
+		return nil
+	}
+
+	funcLogger.Infoln("reconciling waiting runs", zap.Strings("repositories", repositories), zap.Bool("dry_run", dryRun))
+	return handlers.ReconcileWaitingRuns(ctx, false, repositories, dryRun)
+}
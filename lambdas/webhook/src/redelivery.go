@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"webhook/delivery"
+	"webhook/logger"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.uber.org/zap"
+)
+
+/*
+RedeliveryMaintenance is triggered by an EventBridge schedule to redeliver the
+failed deliveries of the configured hooks, see delivery.Redeliverer
+*/
+type RedeliveryMaintenance struct{}
+
+func (m *RedeliveryMaintenance) HandleScheduledEvent(ctx context.Context, event events.EventBridgeEvent) error {
+	funcLogger := logInstance.With(zap.String("event_id", event.ID), zap.Time("scheduled_time", event.Time))
+	logger.InitializeXRay(false)
+
+	_, subSegment := xray.BeginSubsegment(ctx, "HandleScheduledEvent")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	hooks, lookback, maxAttempts, err := delivery.RedeliveryConfig()
+	if err != nil {
+		funcLogger.Errorln("invalid redelivery configuration", zap.Error(err))
+		return err
+	}
+	if len(hooks) == 0 {
+		funcLogger.Warnln("no hooks are configured to redeliver failed deliveries of")
+		return nil
+	}
+
+	ledger, err := delivery.GetLedger(ctx)
+	if err != nil {
+		funcLogger.Errorln("error while getting delivery ledger", zap.Error(err))
+		return err
+	}
+
+	funcLogger.Infoln("redelivering failed deliveries", zap.Int("hook_count", len(hooks)), zap.Duration("lookback", lookback))
+	return delivery.NewRedeliverer(ledger, lookback, maxAttempts).RedeliverFailed(ctx, hooks)
+}
@@ -71,26 +71,38 @@ func configureSecretCache(ctx context.Context) error {
 	return returnedErr
 }
 
+/*
+returns the AWSCURRENT version of the secret
+*/
 func GetSecretValue(ctx context.Context, secretName string) (*string, error) {
-	funcLogger := logInstance.With(zap.String("secret_name", secretName))
+	return GetSecretValueWithStage(ctx, secretName, secretcache.DefaultVersionStage)
+}
+
+/*
+returns the version of the secret with the version stage (ex. AWSCURRENT or AWSPENDING),
+a missing version is only logged at debug as whether it is an error is up to the caller
+(AWSPENDING only exists during a rotation)
+*/
+func GetSecretValueWithStage(ctx context.Context, secretName string, versionStage string) (*string, error) {
+	funcLogger := logInstance.With(zap.String("secret_name", secretName), zap.String("version_stage", versionStage))
 	funcLogger.Infoln("getting secret value")
 
-	_, subSegment := xray.BeginSubsegment(ctx, "RequesterHasPermission")
+	_, subSegment := xray.BeginSubsegment(ctx, "GetSecretValue")
 	if subSegment != nil {
 		traceID := subSegment.TraceID
-		funcLogger = logInstance.With(zap.String("traceID", traceID))
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
 		defer subSegment.Close(nil)
 	}
 
 	cache, err := getSecretCache(ctx)
 	if err != nil {
-		funcLogger.Errorln("error while string to get secret client", zap.Error(err))
+		funcLogger.Errorln("error while trying to get secret client", zap.Error(err))
 		return nil, err
 	}
 
-	secretValue, err := cache.GetSecretStringWithContext(ctx, secretName)
+	secretValue, err := cache.GetSecretStringWithStageWithContext(ctx, secretName, versionStage)
 	if err != nil {
-		funcLogger.Errorln("error while string to get secret string", zap.Error(err))
+		funcLogger.Debugln("error while trying to get secret string", zap.Error(err))
 		return nil, err
 	}
 
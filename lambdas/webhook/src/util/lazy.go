@@ -0,0 +1,32 @@
+package util
+
+import "sync"
+
+/*
+Lazy holds a value that is created the first time it is asked for,
+the same value is returned for the life of the lambda. A creation that
+fails is retried on the next call rather than failing every later event
+*/
+type Lazy[T any] struct {
+	mu      sync.Mutex
+	created bool
+	value   T
+}
+
+/*
+returns the value, creating it with create until a creation succeeds
+*/
+func (l *Lazy[T]) Get(create func() (T, error)) (T, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.created {
+		value, err := create()
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		l.value, l.created = value, true
+	}
+	return l.value, nil
+}
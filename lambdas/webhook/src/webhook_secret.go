@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"webhook/util"
+
+	"github.com/google/go-github/v66/github"
+	"go.uber.org/zap"
+)
+
+const (
+	// JSON object mapping a tenant path parameter, hook ID or hook installation target to
+	// the name (or ARN) of its webhook secret and the repository owners (and app installations)
+	// it may sign deliveries for, ex. {"hook:123": {"secret": "ORG_A_WEBHOOK_SECRET", "owners": ["org-a"]}}.
+	// Deliveries that match no entry, and have no tenant path parameter, use the GITHUB_WEBHOOK_SECRET_NAME secret
+	GITHUB_WEBHOOK_SECRET_NAMES_ENV_VAR_KEY = "GITHUB_WEBHOOK_SECRET_NAMES"
+	GITHUB_WEBHOOK_SECRET_NAMES_DEFAULT     = ""
+
+	// GitHub sends these headers with every webhook delivery, see
+	// https://docs.github.com/en/webhooks/webhook-events-and-payloads#delivery-headers
+	HOOK_ID_HEADER          = "X-GitHub-Hook-ID"
+	HOOK_TARGET_TYPE_HEADER = "X-GitHub-Hook-Installation-Target-Type"
+	HOOK_TARGET_ID_HEADER   = "X-GitHub-Hook-Installation-Target-ID"
+
+	// path parameter of the /webhook/{tenant} route
+	TENANT_PATH_PARAMETER = "tenant"
+
+	// comma separated version stages of the webhook secret that deliveries are validated
+	// against, a delivery signed with any of them is valid so the secret can be rotated
+	// in Secrets Manager and then in GitHub without deliveries failing in between
+	GITHUB_WEBHOOK_SECRET_STAGES_ENV_VAR_KEY = "GITHUB_WEBHOOK_SECRET_STAGES"
+	GITHUB_WEBHOOK_SECRET_STAGES_DEFAULT     = "AWSCURRENT,AWSPENDING"
+
+	TENANT_SECRET_KEY_PREFIX = "path:"
+	HOOK_SECRET_KEY_PREFIX   = "hook:"
+)
+
+var (
+	webhookSecretNamesInstance map[string]webhookSecretEntry
+	webhookSecretNamesOnce     sync.Once
+	webhookSecretNamesError    error
+
+	// the delivery's tenant path parameter has no webhook secret of its own
+	errUnknownTenant = errors.New("no webhook secret is configured for the tenant")
+)
+
+/*
+webhookSecretEntry is a webhook secret of GITHUB_WEBHOOK_SECRET_NAMES_ENV_VAR_KEY. The
+path parameter and hook headers that pick a secret are not signed, so a delivery signed
+with the secret is only trusted for the repository owners and app installations listed
+*/
+type webhookSecretEntry struct {
+	Secret        string   `json:"secret"`
+	Owners        []string `json:"owners"`
+	Installations []int64  `json:"installations"`
+}
+
+/*
+the fields of a delivery's payload that are checked against the
+owners and installations of the secret it was signed with
+*/
+type deliveryScope struct {
+	Repository struct {
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Installation struct {
+		ID int64 `json:"id"`
+	} `json:"installation"`
+}
+
+/*
+webhookSecret is a version of the webhook secret and its version stage
+*/
+type webhookSecret struct {
+	stage string
+	key   []byte
+}
+
+/*
+secretTarget identifies who a delivery is for, the webhook
+secret is resolved from the most specific identifier set
+*/
+type secretTarget struct {
+	tenant     string
+	hookID     string
+	targetType string
+	targetID   string
+}
+
+/*
+reads the tenant path parameter and the hook headers of the delivery
+*/
+func newSecretTarget(pathParameters map[string]string, header http.Header) secretTarget {
+	return secretTarget{
+		tenant:     pathParameters[TENANT_PATH_PARAMETER],
+		hookID:     header.Get(HOOK_ID_HEADER),
+		targetType: strings.ToLower(header.Get(HOOK_TARGET_TYPE_HEADER)),
+		targetID:   header.Get(HOOK_TARGET_ID_HEADER),
+	}
+}
+
+/*
+returns the secret name keys of the target in the order they are looked up,
+the tenant path parameter, the hook ID and then the hook installation target
+(ex. organization:123 or repository:456)
+*/
+func (t secretTarget) keys() []string {
+	var keys []string
+	if t.tenant != "" {
+		keys = append(keys, TENANT_SECRET_KEY_PREFIX+t.tenant)
+	}
+	if t.hookID != "" {
+		keys = append(keys, HOOK_SECRET_KEY_PREFIX+t.hookID)
+	}
+	if t.targetType != "" && t.targetID != "" {
+		keys = append(keys, t.targetType+":"+t.targetID)
+	}
+	return keys
+}
+
+/*
+returns the target's webhook secret and the key it was resolved by, nil (and an empty
+key) is returned if no key of the target is mapped so the default secret is used.
+A tenant path parameter that isn't mapped is an error rather than using the default secret
+*/
+func resolveWebhookSecret(secretNames map[string]webhookSecretEntry, target secretTarget) (*webhookSecretEntry, string, error) {
+	for _, key := range target.keys() {
+		if entry, ok := secretNames[key]; ok {
+			return &entry, key, nil
+		}
+		if key == TENANT_SECRET_KEY_PREFIX+target.tenant {
+			return nil, "", fmt.Errorf("%w %q", errUnknownTenant, target.tenant)
+		}
+	}
+	return nil, "", nil
+}
+
+/*
+checks the repository owner and installation of the validated payload against those
+the secret may sign for, payloads without an installation only have their owner checked
+*/
+func (e *webhookSecretEntry) allows(payload []byte) error {
+	var scope deliveryScope
+	if err := json.Unmarshal(payload, &scope); err != nil {
+		return fmt.Errorf("unable to read the repository owner and installation of the payload: %w", err)
+	}
+
+	owner := scope.Repository.Owner.Login
+	if !slices.ContainsFunc(e.Owners, func(allowed string) bool { return strings.EqualFold(allowed, owner) }) {
+		return fmt.Errorf("the webhook secret may not sign deliveries for repository owner %q", owner)
+	}
+
+	installationID := scope.Installation.ID
+	if installationID != 0 && !slices.Contains(e.Installations, installationID) {
+		return fmt.Errorf("the webhook secret may not sign deliveries for installation %d", installationID)
+	}
+	return nil
+}
+
+/*
+returns the webhook secret names sourced from GITHUB_WEBHOOK_SECRET_NAMES_ENV_VAR_KEY,
+the JSON is only parsed once
+*/
+func getWebhookSecretNames() (map[string]webhookSecretEntry, error) {
+	webhookSecretNamesOnce.Do(func() {
+		webhookSecretNamesInstance, webhookSecretNamesError = parseWebhookSecretNames(
+			util.LookupEnv(GITHUB_WEBHOOK_SECRET_NAMES_ENV_VAR_KEY, GITHUB_WEBHOOK_SECRET_NAMES_DEFAULT, false),
+		)
+		if webhookSecretNamesError != nil {
+			logInstance.Errorln("error observed while parsing webhook secret names", zap.Error(webhookSecretNamesError))
+		}
+	})
+
+	return webhookSecretNamesInstance, webhookSecretNamesError
+}
+
+/*
+parses the JSON object of webhook secrets, an empty value maps nothing.
+Every entry needs a secret and the owners it may sign for
+*/
+func parseWebhookSecretNames(value string) (map[string]webhookSecretEntry, error) {
+	secretNames := map[string]webhookSecretEntry{}
+	if strings.TrimSpace(value) == "" {
+		return secretNames, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &secretNames); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON object of webhook secrets: %w", GITHUB_WEBHOOK_SECRET_NAMES_ENV_VAR_KEY, err)
+	}
+	for key, entry := range secretNames {
+		if entry.Secret == "" || len(entry.Owners) == 0 {
+			return nil, fmt.Errorf("%s entry %q is missing its secret or owners", GITHUB_WEBHOOK_SECRET_NAMES_ENV_VAR_KEY, key)
+		}
+	}
+	return secretNames, nil
+}
+
+/*
+returns the version stages of the webhook secret sourced from GITHUB_WEBHOOK_SECRET_STAGES_ENV_VAR_KEY
+*/
+func getWebhookSecretStages() []string {
+	var stages []string
+	for _, stage := range strings.Split(util.LookupEnv(GITHUB_WEBHOOK_SECRET_STAGES_ENV_VAR_KEY, GITHUB_WEBHOOK_SECRET_STAGES_DEFAULT, false), ",") {
+		if stage = strings.TrimSpace(stage); stage != "" {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+/*
+validates the signature of the request's payload against each webhook secret in order,
+returning the payload and the version stage of the secret that matched.
+The body is read once so it can be checked against every secret
+*/
+func validatePayload(r *http.Request, webhookSecrets []webhookSecret) ([]byte, string, error) {
+	signature := r.Header.Get(github.SHA256SignatureHeader)
+	if signature == "" {
+		signature = r.Header.Get(github.SHA1SignatureHeader)
+	}
+
+	contentType, _, err := mime.ParseMediaType(r.Header.Get(CONTENT_TYPE_HEADER))
+	if err != nil {
+		return nil, "", err
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = errors.New("no webhook secret to validate the payload with")
+	for _, secret := range webhookSecrets {
+		var payload []byte
+		if payload, err = github.ValidatePayloadFromBody(contentType, bytes.NewReader(body), signature, secret.key); err == nil {
+			return payload, secret.stage, nil
+		}
+	}
+	return nil, "", err
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	secretNames = map[string]webhookSecretEntry{
+		"path:tenant-a":    {Secret: "TENANT_A_WEBHOOK_SECRET", Owners: []string{"org-a"}},
+		"hook:123":         {Secret: "HOOK_123_WEBHOOK_SECRET", Owners: []string{"org-b"}, Installations: []int64{42}},
+		"organization:456": {Secret: "ORG_456_WEBHOOK_SECRET", Owners: []string{"org-c"}},
+	}
+)
+
+/*
+Test for case where the delivery has a tenant path parameter and hook headers,
+the tenant's secret should be used as the path parameter is looked up first
+*/
+func TestTenantWebhookSecret(t *testing.T) {
+	// arrange
+	target := newSecretTarget(map[string]string{TENANT_PATH_PARAMETER: "tenant-a"}, hookHeaders("123", "organization", "456"))
+
+	// act
+	entry, secretKey, err := resolveWebhookSecret(secretNames, target)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, "TENANT_A_WEBHOOK_SECRET", entry.Secret)
+	assert.Equal(t, "path:tenant-a", secretKey)
+}
+
+/*
+Test for case where the hook ID and installation target both have a secret,
+the hook's secret should be used
+*/
+func TestHookWebhookSecret(t *testing.T) {
+	// arrange
+	target := newSecretTarget(nil, hookHeaders("123", "organization", "456"))
+
+	// act
+	entry, secretKey, err := resolveWebhookSecret(secretNames, target)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, "HOOK_123_WEBHOOK_SECRET", entry.Secret)
+	assert.Equal(t, "hook:123", secretKey)
+}
+
+/*
+Test for case where only the installation target has a secret, the
+target's secret should be used regardless of the target type's casing
+*/
+func TestInstallationTargetWebhookSecret(t *testing.T) {
+	// arrange
+	target := newSecretTarget(nil, hookHeaders("789", "Organization", "456"))
+
+	// act
+	entry, secretKey, err := resolveWebhookSecret(secretNames, target)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, "ORG_456_WEBHOOK_SECRET", entry.Secret)
+	assert.Equal(t, "organization:456", secretKey)
+}
+
+/*
+Test for case where no identifier of the delivery has a secret,
+no entry should be resolved so the default secret is used
+*/
+func TestDefaultWebhookSecret(t *testing.T) {
+	// arrange
+	target := newSecretTarget(nil, hookHeaders("789", "repository", "456"))
+
+	// act
+	entry, secretKey, err := resolveWebhookSecret(secretNames, target)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Nil(t, entry)
+	assert.Empty(t, secretKey)
+}
+
+/*
+Test for case where the delivery's tenant path parameter has no secret, the delivery
+should be refused rather than validated with the hook's or the default secret
+*/
+func TestUnknownTenantWebhookSecret(t *testing.T) {
+	// arrange
+	target := newSecretTarget(map[string]string{TENANT_PATH_PARAMETER: "tenant-b"}, hookHeaders("123", "organization", "456"))
+
+	// act
+	entry, _, err := resolveWebhookSecret(secretNames, target)
+
+	// assert
+	assert.ErrorIs(t, err, errUnknownTenant)
+	assert.Nil(t, entry)
+}
+
+/*
+Test for case where a delivery is signed with a tenant's secret, only payloads
+for the tenant's owners and installations should be allowed
+*/
+func TestWebhookSecretScope(t *testing.T) {
+	// arrange
+	entry := secretNames["hook:123"]
+
+	// act
+	allowedErr := entry.allows([]byte(`{"repository": {"owner": {"login": "Org-B"}}, "installation": {"id": 42}}`))
+	noInstallationErr := entry.allows([]byte(`{"repository": {"owner": {"login": "org-b"}}}`))
+	otherOwnerErr := entry.allows([]byte(`{"repository": {"owner": {"login": "org-a"}}, "installation": {"id": 42}}`))
+	otherInstallationErr := entry.allows([]byte(`{"repository": {"owner": {"login": "org-b"}}, "installation": {"id": 7}}`))
+	noRepositoryErr := entry.allows([]byte(`{"action": "requested"}`))
+
+	// assert
+	assert.Nil(t, allowedErr)
+	assert.Nil(t, noInstallationErr)
+	assert.NotNil(t, otherOwnerErr)
+	assert.NotNil(t, otherInstallationErr)
+	assert.NotNil(t, noRepositoryErr)
+}
+
+/*
+Test for case where the webhook secret names are not a JSON object of
+secrets, or an entry doesn't say which owners it may sign for
+*/
+func TestInvalidWebhookSecretNames(t *testing.T) {
+	// act
+	_, err := parseWebhookSecretNames(`["hook:123"]`)
+	_, stringErr := parseWebhookSecretNames(`{"hook:123": "HOOK_123_WEBHOOK_SECRET"}`)
+	_, ownersErr := parseWebhookSecretNames(`{"hook:123": {"secret": "HOOK_123_WEBHOOK_SECRET"}}`)
+	emptyNames, emptyErr := parseWebhookSecretNames("")
+
+	// assert
+	assert.NotNil(t, err)
+	assert.NotNil(t, stringErr)
+	assert.NotNil(t, ownersErr)
+	assert.Nil(t, emptyErr)
+	assert.Empty(t, emptyNames)
+}
+
+/*
+Test for case where the delivery is signed with the pending secret during a
+rotation, the payload should be valid and the pending stage should be matched
+*/
+func TestPendingWebhookSecret(t *testing.T) {
+	// arrange
+	body := `{"key":"value"}`
+	req := signedRequest(t, body, "pending-secret")
+	webhookSecrets := []webhookSecret{
+		{stage: "AWSCURRENT", key: []byte("current-secret")},
+		{stage: "AWSPENDING", key: []byte("pending-secret")},
+	}
+
+	// act
+	payload, matchedStage, err := validatePayload(req, webhookSecrets)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, "AWSPENDING", matchedStage)
+	assert.Equal(t, body, string(payload))
+}
+
+/*
+Test for case where the delivery is signed with neither the current
+nor the pending secret, the payload should be invalid
+*/
+func TestNoMatchingWebhookSecret(t *testing.T) {
+	// arrange
+	req := signedRequest(t, `{"key":"value"}`, "previous-secret")
+	webhookSecrets := []webhookSecret{
+		{stage: "AWSCURRENT", key: []byte("current-secret")},
+		{stage: "AWSPENDING", key: []byte("pending-secret")},
+	}
+
+	// act
+	_, matchedStage, err := validatePayload(req, webhookSecrets)
+
+	// assert
+	assert.NotNil(t, err)
+	assert.Empty(t, matchedStage)
+}
+
+/*
+Test for case where the secret stages are configured with
+spaces and empty entries, only the named stages should be used
+*/
+func TestWebhookSecretStages(t *testing.T) {
+	// arrange
+	t.Setenv(GITHUB_WEBHOOK_SECRET_STAGES_ENV_VAR_KEY, " AWSCURRENT, ,AWSPENDING ")
+
+	// act
+	stages := getWebhookSecretStages()
+
+	// assert
+	assert.Equal(t, []string{"AWSCURRENT", "AWSPENDING"}, stages)
+}
+
+/*
+builds a webhook request with the body signed by the secret
+*/
+func signedRequest(t *testing.T, body string, secret string) *http.Request {
+	hmacHash := hmac.New(sha256.New, []byte(secret))
+	hmacHash.Write([]byte(body))
+
+	req, err := http.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unable to build request: %s", err)
+	}
+	req.Header.Set(CONTENT_TYPE_HEADER, "application/json")
+	req.Header.Set(github.SHA256SignatureHeader, sha256Prefix+"="+hex.EncodeToString(hmacHash.Sum(nil)))
+	return req
+}
+
+func hookHeaders(hookID string, targetType string, targetID string) http.Header {
+	header := http.Header{}
+	header.Set(HOOK_ID_HEADER, hookID)
+	header.Set(HOOK_TARGET_TYPE_HEADER, targetType)
+	header.Set(HOOK_TARGET_ID_HEADER, targetID)
+	return header
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"webhook/audit"
+	gh "webhook/github"
+	"webhook/logger"
+	"webhook/queue"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/google/go-github/v66/github"
+	"go.uber.org/zap"
+)
+
+/*
+EventQueueWorker processes the deliveries queued by the API Gateway
+handler in async mode, reporting the messages that failed so only
+they are retried (and eventually sent to the dead-letter queue)
+*/
+type EventQueueWorker struct {
+	handlerFor func(event interface{}) func(ctx context.Context) error
+}
+
+/*
+creates a worker handling events the same way the API Gateway handler does in sync mode
+*/
+func NewEventQueueWorker() *EventQueueWorker {
+	return &EventQueueWorker{
+		handlerFor: func(event interface{}) func(ctx context.Context) error {
+			return eventHandler(false, event)
+		},
+	}
+}
+
+func (w *EventQueueWorker) HandleSQSEvent(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	funcLogger := logInstance.With(zap.Int("record_count", len(sqsEvent.Records)))
+	logger.InitializeXRay(false)
+
+	_, subSegment := xray.BeginSubsegment(ctx, "HandleSQSEvent")
+	if subSegment != nil {
+		traceID := subSegment.TraceID
+		funcLogger = funcLogger.With(zap.String("traceID", traceID))
+		defer subSegment.Close(nil)
+	}
+
+	var response events.SQSEventResponse
+	for _, record := range sqsEvent.Records {
+		if err := w.processRecord(ctx, record); err != nil {
+			funcLogger.Errorln("error while processing queued event", zap.String("message_id", record.MessageId), zap.Error(err))
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+
+	funcLogger.Infoln("processed queued events", zap.Int("failure_count", len(response.BatchItemFailures)))
+	return response, nil
+}
+
+/*
+parses the queued delivery and runs its event handler, messages that can't be
+parsed or aren't supported fail so they end up in the dead-letter queue for inspection
+*/
+func (w *EventQueueWorker) processRecord(ctx context.Context, record events.SQSMessage) error {
+	message, err := queue.ParseMessage(record.Body)
+	if err != nil {
+		return err
+	}
+	funcLogger := logInstance.With(zap.String("message_id", record.MessageId), zap.String("delivery_id", message.DeliveryID), zap.String("event_type", message.EventType))
+
+	event, err := github.ParseWebHook(message.EventType, message.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to parse queued webhook: %w", err)
+	}
+
+	handle := w.handlerFor(event)
+	if handle == nil {
+		return fmt.Errorf("unsupported event type %T", event)
+	}
+
+	if message.EnterpriseHost != "" {
+		if _, err := gh.ResolveEndpoint(message.EnterpriseHost); err != nil {
+			return err
+		}
+		ctx = gh.WithEnterpriseHost(ctx, message.EnterpriseHost)
+	}
+
+	funcLogger.Infoln("processing queued event")
+	return handle(audit.WithDeliveryID(ctx, message.DeliveryID))
+}
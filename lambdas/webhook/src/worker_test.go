@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"webhook/queue"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+Test for case where queued events are handed to the worker through the
+in-memory queue, the events should be handled and none should fail
+*/
+func TestQueueWorkerHandlesQueuedEvents(t *testing.T) {
+	// arrange
+	eventQueue := queue.NewMemoryQueue()
+	eventQueue.Send(context.TODO(), queuedEvent(t, "workflow_run", github.WorkflowRunEvent{Action: github.String("requested")}))
+	eventQueue.Send(context.TODO(), queuedEvent(t, "deployment_protection_rule", github.DeploymentProtectionRuleEvent{Action: github.String("requested")}))
+	sqsEvent, _ := eventQueue.SQSEvent()
+
+	var handled []string
+	worker := getTestEventQueueWorker(&handled, nil)
+
+	// act
+	resp, err := worker.HandleSQSEvent(context.TODO(), sqsEvent)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Empty(t, resp.BatchItemFailures)
+	assert.Equal(t, []string{"*github.WorkflowRunEvent", "*github.DeploymentProtectionRuleEvent"}, handled)
+}
+
+/*
+Test for case where some queued events fail, only the failed
+messages should be reported so only they are retried
+*/
+func TestQueueWorkerReportsPartialFailures(t *testing.T) {
+	// arrange
+	eventQueue := queue.NewMemoryQueue()
+	eventQueue.Send(context.TODO(), queuedEvent(t, "workflow_run", github.WorkflowRunEvent{Action: github.String("requested")}))
+	eventQueue.Send(context.TODO(), queuedEvent(t, "deployment_protection_rule", github.DeploymentProtectionRuleEvent{Action: github.String("requested")}))
+	eventQueue.Send(context.TODO(), queuedEvent(t, "ping", github.PingEvent{}))
+	sqsEvent, _ := eventQueue.SQSEvent()
+	sqsEvent.Records = append(sqsEvent.Records, events.SQSMessage{MessageId: "malformed", Body: "not json"})
+
+	var handled []string
+	worker := getTestEventQueueWorker(&handled, errors.New("unable to review deployment"))
+
+	// act
+	resp, err := worker.HandleSQSEvent(context.TODO(), sqsEvent)
+
+	// assert
+	assert.Nil(t, err)
+	assert.Equal(t, []events.SQSBatchItemFailure{{ItemIdentifier: "1"}, {ItemIdentifier: "2"}, {ItemIdentifier: "malformed"}}, resp.BatchItemFailures)
+	assert.Equal(t, []string{"*github.WorkflowRunEvent", "*github.DeploymentProtectionRuleEvent"}, handled)
+}
+
+/*
+returns a worker that records the type of each event it handles, deployment
+protection rule events fail with protectionRuleErr
+*/
+func getTestEventQueueWorker(handled *[]string, protectionRuleErr error) *EventQueueWorker {
+	return &EventQueueWorker{
+		handlerFor: func(event interface{}) func(ctx context.Context) error {
+			switch event.(type) {
+			case *github.WorkflowRunEvent:
+				return func(ctx context.Context) error {
+					*handled = append(*handled, "*github.WorkflowRunEvent")
+					return nil
+				}
+			case *github.DeploymentProtectionRuleEvent:
+				return func(ctx context.Context) error {
+					*handled = append(*handled, "*github.DeploymentProtectionRuleEvent")
+					return protectionRuleErr
+				}
+			default:
+				return nil
+			}
+		},
+	}
+}
+
+func queuedEvent(t *testing.T, eventType string, event interface{}) queue.Message {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("unable to marshal event: %s", err)
+	}
+	return queue.Message{DeliveryID: "delivery-" + eventType, EventType: eventType, Payload: payload}
+}
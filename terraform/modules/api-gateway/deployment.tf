@@ -18,7 +18,8 @@ resource "aws_api_gateway_deployment" "webhook" {
   }
 
   depends_on = [
-    aws_api_gateway_integration.webhook_lambda
+    aws_api_gateway_integration.webhook_lambda,
+    aws_api_gateway_integration.webhook_tenant_lambda
   ]
 }
 
@@ -8,4 +8,11 @@ resource "aws_api_gateway_resource" "webhook" {
   parent_id   = aws_api_gateway_rest_api.webhook.root_resource_id
   rest_api_id = aws_api_gateway_rest_api.webhook.id
   path_part   = "webhook"
+}
+
+# per tenant API path, the tenant path parameter picks the tenant's webhook secret
+resource "aws_api_gateway_resource" "webhook_tenant" {
+  parent_id   = aws_api_gateway_resource.webhook.id
+  rest_api_id = aws_api_gateway_rest_api.webhook.id
+  path_part   = "{tenant}"
 }
\ No newline at end of file
@@ -21,6 +21,30 @@ resource "aws_api_gateway_integration" "webhook_lambda" {
   uri  = var.aws_lambda_webhook_function_invoke_arn
 }
 
+resource "aws_api_gateway_method" "post_webhook_tenant" {
+  rest_api_id      = aws_api_gateway_rest_api.webhook.id
+  resource_id      = aws_api_gateway_resource.webhook_tenant.id
+  api_key_required = var.use_api_key
+
+  http_method   = local.POST_METHOD
+  authorization = "NONE"
+
+  request_parameters = {
+    "method.request.path.tenant" = true
+  }
+}
+
+resource "aws_api_gateway_integration" "webhook_tenant_lambda" {
+  resource_id = aws_api_gateway_resource.webhook_tenant.id
+  rest_api_id = aws_api_gateway_rest_api.webhook.id
+
+  http_method             = aws_api_gateway_method.post_webhook_tenant.http_method
+  integration_http_method = aws_api_gateway_method.post_webhook_tenant.http_method
+
+  type = "AWS_PROXY"
+  uri  = var.aws_lambda_webhook_function_invoke_arn
+}
+
 resource "aws_lambda_permission" "api_gateway_permission" {
   statement_id  = "AllowExecutionFromAPIGateway"
   action        = "lambda:InvokeFunction"
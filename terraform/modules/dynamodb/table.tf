@@ -34,4 +34,26 @@ resource "aws_dynamodb_table" "table" {
     }
   }
 
+  # attributes only used as keys of the GSIs
+  dynamic "attribute" {
+    for_each = setsubtract(
+      toset([for key in flatten([for index in var.global_secondary_indexes : [index.hash_key, index.range_key]]) : key if key != null]),
+      toset([for key in [var.hash_key, var.range_key] : key if key != null])
+    )
+    content {
+      name = attribute.value
+      type = "S"
+    }
+  }
+
+  # only enable TTL if a ttl_attribute is provided,
+  # items are deleted some time after the epoch in that attribute
+  dynamic "ttl" {
+    for_each = var.ttl_attribute != null ? [var.ttl_attribute] : []
+    content {
+      attribute_name = ttl.value
+      enabled        = true
+    }
+  }
+
 }
@@ -45,3 +45,9 @@ variable "global_secondary_indexes" {
   }))
   default = []
 }
+
+variable "ttl_attribute" {
+  description = "The name of the epoch seconds attribute items expire at, TTL is disabled if not provided."
+  type        = string
+  default     = null
+}
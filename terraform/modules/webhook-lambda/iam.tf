@@ -34,10 +34,84 @@ resource "aws_iam_policy" "lambda_dynamodb_write_policy" {
         Action = [
           # "dynamodb:PutItem",
           "dynamodb:GetItem",
+          "dynamodb:BatchGetItem",
+          "dynamodb:Query",
+          # lists the teams holding grants when team grants are enabled
+          "dynamodb:Scan",
           # "dynamodb:UpdateItem",
           # "dynamodb:BatchWriteItem"
         ],
         Resource = module.dynamodb_table.table_arn
+      },
+      {
+        Effect = "Allow",
+        Action = [
+          "dynamodb:PutItem",
+          "dynamodb:DeleteItem",
+          "dynamodb:GetItem",
+        ],
+        Resource = module.deliveries_table.table_arn
+      },
+      {
+        Effect = "Allow",
+        Action = [
+          "dynamodb:PutItem",
+        ],
+        Resource = module.audit_table.table_arn
+      },
+      {
+        Effect = "Allow",
+        Action = [
+          "dynamodb:PutItem",
+          "dynamodb:GetItem",
+          "dynamodb:UpdateItem",
+          "dynamodb:DeleteItem",
+        ],
+        Resource = module.approvals_table.table_arn
+      }
+    ]
+  })
+}
+
+# allows lambda to write audit records to the audit bucket, only created if a bucket is set
+resource "aws_iam_policy" "lambda_audit_bucket_policy" {
+  count       = var.audit_bucket_name != "" ? 1 : 0
+  name        = "lambda_audit_bucket_policy"
+  description = "Policy to allow Lambda functions to write audit records"
+
+  policy = jsonencode({
+    Version = "2012-10-17",
+    Statement = [
+      {
+        Effect = "Allow",
+        Action = [
+          "s3:PutObject",
+        ],
+        Resource = "arn:aws:s3:::${var.audit_bucket_name}/*"
+      }
+    ]
+  })
+}
+
+# allows the webhook lambda to queue events and the worker lambda
+# to consume them, only created in async mode
+resource "aws_iam_policy" "lambda_sqs_policy" {
+  count       = var.async_processing ? 1 : 0
+  name        = "lambda_sqs_policy"
+  description = "Policy to allow Lambda functions to send and receive webhook events"
+
+  policy = jsonencode({
+    Version = "2012-10-17",
+    Statement = [
+      {
+        Effect = "Allow",
+        Action = [
+          "sqs:SendMessage",
+          "sqs:ReceiveMessage",
+          "sqs:DeleteMessage",
+          "sqs:GetQueueAttributes",
+        ],
+        Resource = aws_sqs_queue.events[0].arn
       }
     ]
   })
@@ -50,7 +124,12 @@ data "aws_iam_policy" "xray" {
 # allows lambda to access the github PAT and webhook secrets
 # using their ARNs
 locals {
-  secret_arns = [module.github_webhook_secret.secret_ARN, module.github_PAT_secret.secret_ARN]
+  secret_arns = concat(
+    [module.github_webhook_secret.secret_ARN, module.github_PAT_secret.secret_ARN],
+    module.github_app_secret[*].secret_ARN,
+    module.github_enterprise_PAT_secret[*].secret_ARN,
+    [for entry in values(var.github_webhook_secret_names) : entry.secret]
+  )
 }
 resource "aws_iam_policy" "secret_access" {
   name = "secrets-access-policy"
@@ -79,6 +158,6 @@ resource "aws_iam_role" "lambda_execution" {
 
   # ensures this policies are always attached, if removed will be reattched
   # if any added outside tf state, will be removed
-  managed_policy_arns = [data.aws_iam_policy.lambda_basic_execution.arn, aws_iam_policy.lambda_dynamodb_write_policy.arn,
-  data.aws_iam_policy.xray.arn, aws_iam_policy.secret_access.arn]
+  managed_policy_arns = concat([data.aws_iam_policy.lambda_basic_execution.arn, aws_iam_policy.lambda_dynamodb_write_policy.arn,
+  data.aws_iam_policy.xray.arn, aws_iam_policy.secret_access.arn], aws_iam_policy.lambda_sqs_policy[*].arn, aws_iam_policy.lambda_audit_bucket_policy[*].arn)
 }
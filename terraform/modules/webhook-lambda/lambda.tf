@@ -15,11 +15,44 @@ resource "aws_lambda_function" "webhook" {
   role = aws_iam_role.lambda_execution.arn
 
   environment {
-    variables = {
-      DYNAMO_DB_TABLE_NAME = module.dynamodb_table.table_name
-      # you can also use the secret name
-      GITHUB_WEBHOOK_SECRET_NAME = module.github_webhook_secret.secret_ARN
-      GITHUB_PAT_SECRET_NAME     = module.github_PAT_secret.secret_ARN
-    }
+    variables = merge(local.lambda_environment, {
+      # in async mode events are queued for the worker lambda and answered with 202
+      PROCESSING_MODE = var.async_processing ? "async" : "sync"
+      EVENT_QUEUE_URL = var.async_processing ? aws_sqs_queue.events[0].url : ""
+    })
   }
-}
\ No newline at end of file
+}
+
+# processes the events queued by the webhook lambda in async mode,
+# the same binary started with the queue entrypoint
+resource "aws_lambda_function" "worker" {
+  count = var.async_processing ? 1 : 0
+
+  filename      = local.zipped_lambda_file_path
+  function_name = "${local.profile}-webhook-worker-lambda"
+
+  source_code_hash = filesha256(local.zipped_lambda_file_path)
+
+  runtime       = var.lambda_runtime
+  handler       = "bootstrap"
+  architectures = ["arm64"]
+  timeout       = var.worker_timeout
+
+  role = aws_iam_role.lambda_execution.arn
+
+  environment {
+    variables = merge(local.lambda_environment, {
+      LAMBDA_ENTRYPOINT = "queue"
+    })
+  }
+}
+
+# only the failed messages of a batch are retried
+resource "aws_lambda_event_source_mapping" "worker" {
+  count = var.async_processing ? 1 : 0
+
+  event_source_arn        = aws_sqs_queue.events[0].arn
+  function_name           = aws_lambda_function.worker[0].arn
+  batch_size              = 10
+  function_response_types = ["ReportBatchItemFailures"]
+}
@@ -2,4 +2,28 @@ locals {
   profile = "${var.project_name}-${var.environment}"
 
   zipped_lambda_file_path = "${path.module}/../../../lambdas/webhook/build/lambda.zip"
-}
\ No newline at end of file
+
+  # environment shared by the webhook and worker lambdas
+  lambda_environment = {
+    DYNAMO_DB_TABLE_NAME = module.dynamodb_table.table_name
+    DELIVERY_TABLE_NAME  = module.deliveries_table.table_name
+    AUDIT_TABLE_NAME     = module.audit_table.table_name
+    APPROVAL_TABLE_NAME  = module.approvals_table.table_name
+    # decisions are only logged and audited while true, nothing is approved or rejected
+    DRY_RUN = tostring(var.dry_run)
+    # empty when audit records are only written to the audit table
+    AUDIT_S3_BUCKET = var.audit_bucket_name
+    # you can also use the secret name
+    GITHUB_WEBHOOK_SECRET_NAME = module.github_webhook_secret.secret_ARN
+    GITHUB_PAT_SECRET_NAME     = module.github_PAT_secret.secret_ARN
+    # JSON object of the secrets of tenants, hooks and hook installation targets
+    GITHUB_WEBHOOK_SECRET_NAMES = jsonencode(var.github_webhook_secret_names)
+    # empty when no GitHub App is configured, the PAT is used instead
+    GITHUB_APP_SECRET_NAME = length(module.github_app_secret) > 0 ? module.github_app_secret[0].secret_ARN : ""
+    # empty when events only come from github.com
+    GITHUB_ENTERPRISE_HOST            = var.github_enterprise_host
+    GITHUB_ENTERPRISE_BASE_URL        = var.github_enterprise_base_url
+    GITHUB_ENTERPRISE_CA_BUNDLE_PATH  = var.github_enterprise_ca_bundle_path
+    GITHUB_ENTERPRISE_PAT_SECRET_NAME = length(module.github_enterprise_PAT_secret) > 0 ? module.github_enterprise_PAT_secret[0].secret_ARN : ""
+  }
+}
@@ -12,4 +12,19 @@ output "dynamodb_table_name" {
 
 output "dynamodb_table_arn" {
   value = module.dynamodb_table.table_arn
-}
\ No newline at end of file
+}
+output "event_queue_url" {
+  value = var.async_processing ? aws_sqs_queue.events[0].url : null
+}
+
+output "event_dlq_url" {
+  value = var.async_processing ? aws_sqs_queue.events_dlq[0].url : null
+}
+
+output "audit_table_name" {
+  value = module.audit_table.table_name
+}
+
+output "approvals_table_name" {
+  value = module.approvals_table.table_name
+}
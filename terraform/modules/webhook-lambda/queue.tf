@@ -0,0 +1,23 @@
+# validated events are queued here in async mode and processed by the
+# worker lambda, messages failing max_receive_count times go to the DLQ
+resource "aws_sqs_queue" "events_dlq" {
+  count = var.async_processing ? 1 : 0
+
+  name                      = "${local.profile}-webhook-events-dlq"
+  message_retention_seconds = 1209600 # 14 days, the maximum
+}
+
+resource "aws_sqs_queue" "events" {
+  count = var.async_processing ? 1 : 0
+
+  name = "${local.profile}-webhook-events"
+
+  # must be at least the worker's timeout so a message isn't
+  # received again while the worker is still processing it
+  visibility_timeout_seconds = var.worker_timeout * 6
+
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.events_dlq[0].arn
+    maxReceiveCount     = var.queue_max_receive_count
+  })
+}
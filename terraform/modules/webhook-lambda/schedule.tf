@@ -0,0 +1,102 @@
+# sweeps the configured repositories for waiting runs the webhook
+# missed, the same binary started with the reconcile entrypoint
+resource "aws_lambda_function" "reconciler" {
+  count = length(var.reconcile_repositories) > 0 ? 1 : 0
+
+  filename      = local.zipped_lambda_file_path
+  function_name = "${local.profile}-webhook-reconciler-lambda"
+
+  source_code_hash = filesha256(local.zipped_lambda_file_path)
+
+  runtime       = var.lambda_runtime
+  handler       = "bootstrap"
+  architectures = ["arm64"]
+  timeout       = var.reconcile_timeout
+
+  role = aws_iam_role.lambda_execution.arn
+
+  environment {
+    variables = merge(local.lambda_environment, {
+      LAMBDA_ENTRYPOINT      = "reconcile"
+      RECONCILE_REPOSITORIES = join(",", var.reconcile_repositories)
+      RECONCILE_DRY_RUN      = tostring(var.reconcile_dry_run)
+    })
+  }
+}
+
+resource "aws_cloudwatch_event_rule" "reconcile" {
+  count = length(var.reconcile_repositories) > 0 ? 1 : 0
+
+  name                = "${local.profile}-webhook-reconcile-schedule"
+  description         = "Sweeps stuck pending deployments"
+  schedule_expression = var.reconcile_schedule_expression
+}
+
+resource "aws_cloudwatch_event_target" "reconcile" {
+  count = length(var.reconcile_repositories) > 0 ? 1 : 0
+
+  rule = aws_cloudwatch_event_rule.reconcile[0].name
+  arn  = aws_lambda_function.reconciler[0].arn
+}
+
+resource "aws_lambda_permission" "reconcile" {
+  count = length(var.reconcile_repositories) > 0 ? 1 : 0
+
+  statement_id  = "AllowExecutionFromEventBridge"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.reconciler[0].function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.reconcile[0].arn
+}
+
+# redelivers the failed deliveries of the configured hooks,
+# the same binary started with the redeliver entrypoint
+resource "aws_lambda_function" "redelivery" {
+  count = length(var.redelivery_hooks) > 0 ? 1 : 0
+
+  filename      = local.zipped_lambda_file_path
+  function_name = "${local.profile}-webhook-redelivery-lambda"
+
+  source_code_hash = filesha256(local.zipped_lambda_file_path)
+
+  runtime       = var.lambda_runtime
+  handler       = "bootstrap"
+  architectures = ["arm64"]
+  timeout       = var.redelivery_timeout
+
+  role = aws_iam_role.lambda_execution.arn
+
+  environment {
+    variables = merge(local.lambda_environment, {
+      LAMBDA_ENTRYPOINT       = "redeliver"
+      REDELIVERY_HOOKS        = join(",", var.redelivery_hooks)
+      REDELIVERY_LOOKBACK     = var.redelivery_lookback
+      REDELIVERY_MAX_ATTEMPTS = tostring(var.redelivery_max_attempts)
+    })
+  }
+}
+
+resource "aws_cloudwatch_event_rule" "redelivery" {
+  count = length(var.redelivery_hooks) > 0 ? 1 : 0
+
+  name                = "${local.profile}-webhook-redelivery-schedule"
+  description         = "Redelivers failed webhook deliveries"
+  schedule_expression = var.redelivery_schedule_expression
+}
+
+resource "aws_cloudwatch_event_target" "redelivery" {
+  count = length(var.redelivery_hooks) > 0 ? 1 : 0
+
+  rule = aws_cloudwatch_event_rule.redelivery[0].name
+  arn  = aws_lambda_function.redelivery[0].arn
+}
+
+resource "aws_lambda_permission" "redelivery" {
+  count = length(var.redelivery_hooks) > 0 ? 1 : 0
+
+  statement_id  = "AllowExecutionFromEventBridge"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.redelivery[0].function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.redelivery[0].arn
+}
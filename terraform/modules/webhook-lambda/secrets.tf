@@ -14,3 +14,24 @@ module "github_webhook_secret" {
   secret_description = "The secret for the GitHub webhooks, used to verify payloads."
 }
 
+# only created when the lambda should authenticate as a GitHub App,
+# the secret string is a JSON object with the app_id and private_key
+module "github_app_secret" {
+  source = "../secret"
+  count  = var.github_app_secret_string != null ? 1 : 0
+
+  secret_name        = var.github_app_secret_name
+  secret_string      = var.github_app_secret_string
+  secret_description = "The secret for the GitHub App ID and private key, used to approve requests as the app."
+}
+
+# only created when events also come from a GitHub Enterprise Server instance,
+# used to approve requests on that instance when no enterprise app is configured
+module "github_enterprise_PAT_secret" {
+  source = "../secret"
+  count  = var.github_enterprise_PAT_secret_string != null ? 1 : 0
+
+  secret_name        = var.github_enterprise_PAT_secret_name
+  secret_string      = var.github_enterprise_PAT_secret_string
+  secret_description = "The secret for the GitHub Enterprise Server PAT, used to approve requests on the enterprise instance."
+}
@@ -7,6 +7,65 @@ module "dynamodb_table" {
   hash_key  = "login"
   range_key = "repo-env"
 
+  # time-bounded grants are deleted once past their not_after
+  ttl_attribute = "not_after"
+
+  read_capacity  = 5
+  write_capacity = 5
+}
+
+# delivery ledger, X-GitHub-Delivery GUIDs that have been processed
+# are remembered until their expires_at so they aren't processed twice
+module "deliveries_table" {
+  source = "../dynamodb"
+
+  table_name   = "${local.profile}-deliveries-table"
+  billing_mode = "PROVISIONED"
+
+  hash_key      = "delivery_id"
+  ttl_attribute = "expires_at"
+
+  read_capacity  = 5
+  write_capacity = 5
+}
+
+# audit log, a record of the decision made for every pending environment
+# keyed by <owner>/<repo>#<env> and sorted by when it was recorded
+module "audit_table" {
+  source = "../dynamodb"
+
+  table_name   = "${local.profile}-audit-table"
+  billing_mode = "PROVISIONED"
+
+  hash_key  = "key"
+  range_key = "recorded_at"
+
+  # answers who made a deployment, and when
+  global_secondary_indexes = [{
+    name            = "requester-index"
+    hash_key        = "requester"
+    range_key       = "recorded_at"
+    projection_type = "ALL"
+    read_capacity   = 5
+    write_capacity  = 5
+  }]
+
+  read_capacity  = 5
+  write_capacity = 5
+}
+
+# approvals of other grant holders, kept per <owner>/<repo>#<env> and run
+# until the deployment is approved or the quorum expires at expires_at
+module "approvals_table" {
+  source = "../dynamodb"
+
+  table_name   = "${local.profile}-approvals-table"
+  billing_mode = "PROVISIONED"
+
+  hash_key      = "key"
+  range_key     = "run_id"
+  ttl_attribute = "expires_at"
+
   read_capacity  = 5
   write_capacity = 5
-}
\ No newline at end of file
+}
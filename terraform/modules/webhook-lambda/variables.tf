@@ -45,6 +45,21 @@ variable "github_webhook_secret_string" {
   sensitive   = true
 }
 
+variable "github_webhook_secret_names" {
+  type = map(object({
+    secret        = string
+    owners        = list(string)
+    installations = optional(list(number), [])
+  }))
+  description = <<EOF
+  Webhook secrets of tenants, hooks or hook installation targets that don't share
+  the github_webhook_secret, keyed by path:<tenant>, hook:<hook id> or <target type>:<target id>
+  (ex. organization:123). Each has the secret ARN and the repository owners (and app
+  installation IDs) it may sign deliveries for. The secrets are managed outside of this module
+  EOF
+  default     = {}
+}
+
 variable "github_PAT_secret_name" {
   type        = string
   description = "Secret name (or ARN) for GitHub Personal Access Token (PAT)"
@@ -56,3 +71,131 @@ variable "github_PAT_secret_string" {
   description = "Secret string for github PAT"
   sensitive   = true
 }
+
+variable "github_app_secret_name" {
+  type        = string
+  description = "Secret name (or ARN) for the GitHub App credentials"
+  default     = "GITHUB_APP_SECRET"
+}
+
+variable "github_app_secret_string" {
+  type        = string
+  description = "Secret string for the GitHub App, a JSON object with the app_id and private_key. The PAT is used if not set"
+  sensitive   = true
+  default     = null
+}
+
+variable "github_enterprise_host" {
+  type        = string
+  description = "Host of the GitHub Enterprise Server instance (ex. github.example.com) events may also come from. Only github.com events are handled if empty"
+  default     = ""
+}
+
+variable "github_enterprise_base_url" {
+  type        = string
+  description = "API base URL of the GitHub Enterprise Server instance, defaults to https://<host>/api/v3/ if empty"
+  default     = ""
+}
+
+variable "github_enterprise_ca_bundle_path" {
+  type        = string
+  description = "Path (within the lambda package) of a PEM bundle of the CAs that signed the GitHub Enterprise Server certificate"
+  default     = ""
+}
+
+variable "github_enterprise_PAT_secret_name" {
+  type        = string
+  description = "Secret name (or ARN) for the GitHub Enterprise Server Personal Access Token (PAT)"
+  default     = "GITHUB_ENTERPRISE_PAT_SECRET"
+}
+
+variable "github_enterprise_PAT_secret_string" {
+  type        = string
+  description = "Secret string for the GitHub Enterprise Server PAT"
+  sensitive   = true
+  default     = null
+}
+
+variable "async_processing" {
+  type        = bool
+  description = "Queue validated events to SQS and answer GitHub with 202, a worker lambda then processes them outside of GitHub's 10 second webhook timeout"
+  default     = false
+}
+
+variable "worker_timeout" {
+  type        = number
+  description = "Timeout in seconds of the worker lambda processing queued events"
+  default     = 60
+}
+
+variable "queue_max_receive_count" {
+  type        = number
+  description = "Times a queued event is attempted before it is sent to the dead-letter queue"
+  default     = 3
+}
+
+variable "reconcile_repositories" {
+  type        = list(string)
+  description = "Repositories (<owner>/<repo>) swept on a schedule for waiting runs the webhook missed, the reconciler is only created if not empty"
+  default     = []
+}
+
+variable "reconcile_schedule_expression" {
+  type        = string
+  description = "EventBridge schedule expression the reconciler runs on"
+  default     = "rate(15 minutes)"
+}
+
+variable "reconcile_dry_run" {
+  type        = bool
+  description = "Log the pending deployments the reconciler would approve without reviewing them"
+  default     = false
+}
+
+variable "reconcile_timeout" {
+  type        = number
+  description = "Timeout in seconds of the reconciler lambda"
+  default     = 300
+}
+
+variable "redelivery_hooks" {
+  type        = list(string)
+  description = "Hooks (<owner>/<repo>:<hook_id> or <org>:<hook_id>) whose failed deliveries are redelivered on a schedule, the redelivery lambda is only created if not empty"
+  default     = []
+}
+
+variable "redelivery_schedule_expression" {
+  type        = string
+  description = "EventBridge schedule expression failed deliveries are redelivered on"
+  default     = "rate(30 minutes)"
+}
+
+variable "redelivery_lookback" {
+  type        = string
+  description = "How far back failed deliveries are looked for (ex. 6h), at most 72h as GitHub only redelivers deliveries from the past 3 days"
+  default     = "6h"
+}
+
+variable "redelivery_max_attempts" {
+  type        = number
+  description = "Attempts (the original delivery and its redeliveries) after which a failed delivery is given up on"
+  default     = 3
+}
+
+variable "redelivery_timeout" {
+  type        = number
+  description = "Timeout in seconds of the redelivery lambda"
+  default     = 120
+}
+
+variable "audit_bucket_name" {
+  type        = string
+  description = "Existing S3 bucket audit records are also written to as JSON Lines (under audit/dt=YYYY-MM-DD/), records are only written to the audit table if empty"
+  default     = ""
+}
+
+variable "dry_run" {
+  type        = bool
+  description = "Evaluate every event against the real grants and GitHub but only log and audit the decisions, so grant changes or a new version can run beside manual approvals"
+  default     = false
+}